@@ -0,0 +1,160 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo"
+)
+
+// requireAdmin only lets through requests whose JWT (validated by the JWT
+// middleware) carries the admin role.
+func requireAdmin(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		token, ok := c.Get("user").(*jwt.Token)
+		if !ok {
+			return echo.ErrUnauthorized
+		}
+		claims, ok := token.Claims.(jwt.MapClaims)
+		if !ok {
+			return echo.ErrUnauthorized
+		}
+		role, _ := claims["role"].(string)
+		if username, ok := claims["username"].(string); ok {
+			setLogUsername(c.Request().Context(), username)
+		}
+		if !strings.EqualFold(role, "admin") {
+			return echo.NewHTTPError(http.StatusForbidden, "admin role required")
+		}
+		return next(c)
+	}
+}
+
+// adminActor returns the username of the authenticated operator.
+func adminActor(c echo.Context) string {
+	if token, ok := c.Get("user").(*jwt.Token); ok {
+		if claims, ok := token.Claims.(jwt.MapClaims); ok {
+			if username, ok := claims["username"].(string); ok {
+				return username
+			}
+		}
+	}
+	return "unknown"
+}
+
+// BreakerOverrideRequest is the optional body of the breaker override endpoints.
+type BreakerOverrideRequest struct {
+	Reason string `json:"reason"`
+	// TTL is a Go duration ("15m"); the override reverts to auto once it elapses.
+	TTL string `json:"ttl"`
+}
+
+// registerBreakerAdminRoutes mounts the operator controls of every breaker in
+// the registry. Breakers are addressed by registry key or name.
+func registerBreakerAdminRoutes(g *echo.Group, breakers *breakerRegistry, audit *auditLog) {
+	override := func(mode string) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			b, ok := breakers.Lookup(c.Param("name"))
+			if !ok {
+				return echo.NewHTTPError(http.StatusNotFound, "unknown breaker")
+			}
+
+			var body BreakerOverrideRequest
+			if c.Request().ContentLength != 0 {
+				if err := c.Bind(&body); err != nil {
+					return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+				}
+			}
+			var ttl time.Duration
+			if body.TTL != "" {
+				var err error
+				if ttl, err = time.ParseDuration(body.TTL); err != nil || ttl < 0 {
+					return echo.NewHTTPError(http.StatusBadRequest, "invalid ttl")
+				}
+			}
+
+			actor := adminActor(c)
+			if _, err := b.SetOverride(mode, actor, body.Reason, ttl); err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+			}
+			audit.Record(AuditEntry{
+				Actor:   actor,
+				Action:  "breaker." + mode,
+				Target:  b.Name(),
+				Details: map[string]string{"reason": body.Reason, "ttl": body.TTL},
+			})
+			return c.JSON(http.StatusOK, breakers.Stats(b))
+		}
+	}
+
+	g.POST("/breakers/:name/force-open", override(BreakerModeForcedOpen))
+	g.POST("/breakers/:name/force-closed", override(BreakerModeForcedClosed))
+	g.POST("/breakers/:name/auto", override(BreakerModeAuto))
+
+	g.POST("/breakers/:name/reset", func(c echo.Context) error {
+		b, ok := breakers.Lookup(c.Param("name"))
+		if !ok {
+			return echo.NewHTTPError(http.StatusNotFound, "unknown breaker")
+		}
+		err := b.Reset()
+		audit.Record(AuditEntry{Actor: adminActor(c), Action: "breaker.reset", Target: b.Name()})
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadGateway, "breaker reset on this replica only, the shared state was not cleared: "+err.Error())
+		}
+		return c.JSON(http.StatusOK, breakers.Stats(b))
+	})
+
+	g.GET("/audit", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, audit.Recent())
+	})
+}
+
+// LogLevelRequest is the body of PUT /admin/log-levels. An empty Component
+// changes the default level; an empty Level makes Component follow it again.
+type LogLevelRequest struct {
+	Component string `json:"component"`
+	Level     string `json:"level"`
+}
+
+// registerLogAdminRoutes mounts the endpoints reading and changing the log
+// levels while the service runs.
+func registerLogAdminRoutes(g *echo.Group, levels *logLevels, audit *auditLog) {
+	g.GET("/log-levels", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, levels.Snapshot())
+	})
+
+	g.PUT("/log-levels", func(c echo.Context) error {
+		var body LogLevelRequest
+		if err := c.Bind(&body); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+		}
+		var level slog.Level
+		switch {
+		case body.Level == "" && body.Component == "":
+			return echo.NewHTTPError(http.StatusBadRequest, "level is required")
+		case body.Level == "":
+			levels.Reset(body.Component)
+		default:
+			var err error
+			if level, err = parseLogLevel(body.Level); err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+			}
+			levels.Set(body.Component, level)
+		}
+
+		target := body.Component
+		if target == "" {
+			target = "default"
+		}
+		audit.Record(AuditEntry{
+			Actor:   adminActor(c),
+			Action:  "log.level",
+			Target:  target,
+			Details: map[string]string{"level": body.Level},
+		})
+		return c.JSON(http.StatusOK, levels.Snapshot())
+	})
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo"
+	"github.com/sony/gobreaker/v2"
+)
+
+func signedToken(t *testing.T, username, role string) string {
+	token := jwt.New(jwt.SigningMethodHS256)
+	claims := token.Claims.(jwt.MapClaims)
+	claims["username"] = username
+	claims["role"] = role
+	s, err := token.SignedString([]byte(jwtSecret.Get()))
+	if err != nil {
+		t.Fatalf("could not sign token: %v", err)
+	}
+	return s
+}
+
+func newAdminTestServer(breakers *breakerRegistry, audit *auditLog) *echo.Echo {
+	e := echo.New()
+	admin := e.Group("/admin", jwtSecret.Middleware, requireAdmin)
+	registerBreakerAdminRoutes(admin, breakers, audit)
+	return e
+}
+
+func adminRequest(e *echo.Echo, token, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAdmin_ForceOpenAndAuto(t *testing.T) {
+	breakers := newBreakerRegistry(&okClient{}, DefaultBreakerSettings(), false)
+	b := breakers.Breaker("users-api:8083", "")
+	audit := newAuditLog(nil, nil)
+	e := newAdminTestServer(breakers, audit)
+	token := signedToken(t, "admin", "ADMIN")
+
+	rec := adminRequest(e, token, "/admin/breakers/users-api:8083/force-open", `{"reason":"incident 42"}`)
+	var stats BreakerStats
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 with the breaker stats, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if state, _ := b.Status(); state != gobreaker.StateOpen || stats.State != "open" || stats.Override.Mode != BreakerModeForcedOpen {
+		t.Fatalf("expected forced open state, got %s %+v", state, stats)
+	}
+	req, _ := http.NewRequest("GET", "http://users-api:8083/users/admin", nil)
+	if _, err := b.Do(req); err != gobreaker.ErrOpenState {
+		t.Fatalf("expected forced open breaker to reject calls, got %v", err)
+	}
+
+	rec = adminRequest(e, token, "/admin/breakers/users-api:8083/auto", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if _, err := b.Do(req); err != nil {
+		t.Fatalf("expected auto mode to let calls through, got %v", err)
+	}
+
+	rec = adminRequest(e, token, "/admin/breakers/users-api:8083/reset", "")
+	stats = BreakerStats{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 with the breaker stats, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if stats.SchemaVersion != BreakerStatsSchemaVersion || stats.Key != "users-api:8083" || stats.State != "closed" || stats.Lifetime.Requests != 0 {
+		t.Fatalf("unexpected stats after reset: %+v", stats)
+	}
+
+	entries := audit.Recent()
+	if len(entries) != 3 || entries[0].Action != "breaker.forced_open" || entries[0].Actor != "admin" || entries[0].Details["reason"] != "incident 42" {
+		t.Fatalf("unexpected audit entries: %+v", entries)
+	}
+}
+
+func TestAdmin_RequiresAdminRole(t *testing.T) {
+	breakers := newBreakerRegistry(&okClient{}, DefaultBreakerSettings(), false)
+	breakers.Breaker("users-api:8083", "")
+	e := newAdminTestServer(breakers, newAuditLog(nil, nil))
+
+	rec := adminRequest(e, signedToken(t, "johnd", "USER"), "/admin/breakers/users-api:8083/force-open", "")
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for non-admin, got %d", rec.Code)
+	}
+	rec = adminRequest(e, "not-a-token", "/admin/breakers/users-api:8083/force-open", "")
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for invalid token, got %d", rec.Code)
+	}
+}
+
+func TestBreakerOverride_Expires(t *testing.T) {
+	b := newBreakerHTTPClient(&okClient{}, "ttl-breaker")
+	if _, err := b.SetOverride(BreakerModeForcedClosed, "admin", "", 10*time.Millisecond); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if b.Override().Mode != BreakerModeForcedClosed {
+		t.Fatalf("expected forced closed override")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if o := b.Override(); o.Mode != BreakerModeAuto {
+		t.Fatalf("expected override to expire, got %+v", o)
+	}
+}
+
+func TestBreaker_Reset(t *testing.T) {
+	b := newBreakerHTTPClient(&failingClient{}, "reset-breaker")
+	req, _ := http.NewRequest("GET", "http://example.local", nil)
+	for i := 0; i < 6; i++ {
+		b.Do(req)
+	}
+	if state, _ := b.Status(); state != gobreaker.StateOpen {
+		t.Fatalf("expected open breaker before reset")
+	}
+
+	b.Reset()
+	state, counts := b.Status()
+	if state != gobreaker.StateClosed || counts.Requests != 0 || b.Stats().Lifetime.Requests != 0 {
+		t.Fatalf("expected fresh breaker after reset, got %s %+v", state, counts)
+	}
+}
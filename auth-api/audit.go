@@ -0,0 +1,73 @@
+package main
+
+import (
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// EventAudit is the AuthEvent type of audit entries.
+const EventAudit = "audit"
+
+// AuditEntry records an operator action.
+type AuditEntry struct {
+	Time    time.Time         `json:"time"`
+	Actor   string            `json:"actor"`
+	Action  string            `json:"action"`
+	Target  string            `json:"target"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// auditLog logs operator actions, publishes them to the auth event stream and
+// keeps the most recent ones in memory for the admin API.
+type auditLog struct {
+	logger *slog.Logger
+	events *eventStream
+
+	mu     sync.Mutex
+	recent []AuditEntry
+	max    int
+}
+
+func newAuditLog(logger *slog.Logger, events *eventStream) *auditLog {
+	return &auditLog{logger: logger, events: events, max: 100}
+}
+
+func (a *auditLog) Record(entry AuditEntry) {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+
+	if a.logger != nil {
+		args := []any{"event", EventAudit, "actor", entry.Actor, "action", entry.Action, "target", entry.Target}
+		keys := make([]string, 0, len(entry.Details))
+		for k := range entry.Details {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			args = append(args, k, entry.Details[k])
+		}
+		a.logger.Info("operator action", args...)
+	}
+	if a.events != nil {
+		a.events.Publish(AuthEvent{Type: EventAudit, Time: entry.Time, Data: entry})
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.recent = append(a.recent, entry)
+	if len(a.recent) > a.max {
+		a.recent = a.recent[len(a.recent)-a.max:]
+	}
+}
+
+// Recent returns the retained entries, oldest first.
+func (a *auditLog) Recent() []AuditEntry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	out := make([]AuditEntry, len(a.recent))
+	copy(out, a.recent)
+	return out
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+)
+
+// CallOutcome is how a breaker accounts for one call.
+type CallOutcome int
+
+const (
+	OutcomeSuccess CallOutcome = iota
+	OutcomeFailure
+	// OutcomeIgnored calls are returned to the caller untouched and do not
+	// count for or against the breaker.
+	OutcomeIgnored
+)
+
+// defaultFailureBodyLimit caps how much of a response FailureBody may inspect.
+const defaultFailureBodyLimit = 64 << 10
+
+// BreakerClassifier decides which calls count as failures for a breaker.
+type BreakerClassifier struct {
+	// FailureStatusCodes lists the response codes counted as failures.
+	FailureStatusCodes map[int]bool
+	// FailureErrorClasses lists the transport error classes counted as
+	// failures; errors of other classes are ignored. Nil counts every error.
+	FailureErrorClasses map[RetryErrorClass]bool
+	// IgnoreCallerCancellation ignores calls failed because the caller
+	// cancelled the request context.
+	IgnoreCallerCancellation bool
+	// FailureBody, when set, is called with the start of every response not
+	// already failed by its status and reports whether it is a failure.
+	FailureBody func(resp *http.Response, body []byte) bool
+	// FailureBodyLimit is how many body bytes FailureBody sees. Defaults to 64KiB.
+	FailureBodyLimit int64
+}
+
+// DefaultBreakerClassifier counts transport errors and 5xx responses as
+// failures and ignores caller cancellations.
+func DefaultBreakerClassifier() *BreakerClassifier {
+	c := &BreakerClassifier{
+		FailureStatusCodes:       map[int]bool{},
+		IgnoreCallerCancellation: true,
+	}
+	for code := 500; code < 600; code++ {
+		c.FailureStatusCodes[code] = true
+	}
+	return c
+}
+
+// Classify returns the outcome of a call and, for failures, the error handed
+// to the breaker and the caller. The response body stays readable.
+func (c *BreakerClassifier) Classify(req *http.Request, resp *http.Response, err error) (CallOutcome, error) {
+	if err != nil {
+		if c.IgnoreCallerCancellation && errors.Is(req.Context().Err(), context.Canceled) {
+			return OutcomeIgnored, err
+		}
+		if c.FailureErrorClasses != nil && !c.FailureErrorClasses[classifyError(err)] {
+			return OutcomeIgnored, err
+		}
+		return OutcomeFailure, err
+	}
+
+	if c.FailureStatusCodes[resp.StatusCode] {
+		return OutcomeFailure, &ServerError{StatusCode: resp.StatusCode}
+	}
+
+	if c.FailureBody != nil && resp.Body != nil {
+		limit := c.FailureBodyLimit
+		if limit <= 0 {
+			limit = defaultFailureBodyLimit
+		}
+		body, rerr := io.ReadAll(io.LimitReader(resp.Body, limit))
+		if rerr != nil {
+			return OutcomeFailure, rerr
+		}
+		resp.Body = replayBody{Reader: io.MultiReader(bytes.NewReader(body), resp.Body), Closer: resp.Body}
+		if c.FailureBody(resp, body) {
+			return OutcomeFailure, &ResponseBodyError{StatusCode: resp.StatusCode}
+		}
+	}
+	return OutcomeSuccess, nil
+}
+
+// replayBody puts the bytes read by the classifier back in front of the body.
+type replayBody struct {
+	io.Reader
+	io.Closer
+}
+
+// ResponseBodyError is returned for responses failed by a FailureBody predicate.
+type ResponseBodyError struct {
+	StatusCode int
+}
+
+func (e *ResponseBodyError) Error() string {
+	return fmt.Sprintf("response body counted as failure (status %d)", e.StatusCode)
+}
+
+// ignoredOutcome carries an ignored call through the breaker engine, which
+// must count it neither as a failure nor as a success.
+type ignoredOutcome struct {
+	resp *http.Response
+	err  error
+}
+
+func (e *ignoredOutcome) Error() string {
+	if e.err != nil {
+		return "ignored call: " + e.err.Error()
+	}
+	return "ignored call"
+}
+
+func isIgnoredOutcome(err error) bool {
+	var ignored *ignoredOutcome
+	return errors.As(err, &ignored)
+}
+
+// breakerClassifierFromEnv builds the classifier from CB_FAILURE_STATUS_CODES,
+// CB_FAILURE_ERROR_CLASSES, CB_IGNORE_CANCELED and CB_FAILURE_BODY_REGEX,
+// starting from the defaults.
+func breakerClassifierFromEnv(env configLookup) (*BreakerClassifier, error) {
+	c := DefaultBreakerClassifier()
+
+	if v, ok := env("CB_FAILURE_STATUS_CODES"); ok {
+		codes, err := parseStatusCodes(v)
+		if err != nil {
+			return nil, fmt.Errorf("CB_FAILURE_STATUS_CODES: %w", err)
+		}
+		c.FailureStatusCodes = codes
+	}
+
+	if v, ok := env("CB_FAILURE_ERROR_CLASSES"); ok {
+		classes, err := parseErrorClasses(v)
+		if err != nil {
+			return nil, fmt.Errorf("CB_FAILURE_ERROR_CLASSES: %w", err)
+		}
+		c.FailureErrorClasses = classes
+	}
+
+	if v := env.get("CB_IGNORE_CANCELED"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("CB_IGNORE_CANCELED: %w", err)
+		}
+		c.IgnoreCallerCancellation = b
+	}
+
+	if v := env.get("CB_FAILURE_BODY_REGEX"); v != "" {
+		re, err := regexp.Compile(v)
+		if err != nil {
+			return nil, fmt.Errorf("CB_FAILURE_BODY_REGEX: %w", err)
+		}
+		c.FailureBody = func(resp *http.Response, body []byte) bool { return re.Match(body) }
+	}
+
+	return c, nil
+}
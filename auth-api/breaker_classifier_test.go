@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/sony/gobreaker/v2"
+)
+
+// statusBodyClient answers every call with the given status and body.
+type statusBodyClient struct {
+	status int
+	body   string
+}
+
+func (c *statusBodyClient) Do(req *http.Request) (*http.Response, error) {
+	return &http.Response{StatusCode: c.status, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(c.body))}, nil
+}
+
+// cancelingClient simulates a caller cancelling the request while it is in flight.
+type cancelingClient struct {
+	cancel context.CancelFunc
+}
+
+func (c *cancelingClient) Do(req *http.Request) (*http.Response, error) {
+	c.cancel()
+	return nil, req.Context().Err()
+}
+
+func newClassifiedBreaker(client HTTPDoer, c *BreakerClassifier) *breakerHTTPClient {
+	s := DefaultBreakerSettings()
+	s.Name = "classified-breaker"
+	s.Classifier = c
+	return newBreakerHTTPClientWithSettings(client, s)
+}
+
+func TestBreakerClassifier_StatusCodes(t *testing.T) {
+	c := DefaultBreakerClassifier()
+	c.FailureStatusCodes[http.StatusNotFound] = true
+	b := newClassifiedBreaker(&statusBodyClient{status: 404, body: "not found"}, c)
+	req, _ := http.NewRequest("GET", "http://users-api:8083/users/admin", nil)
+
+	for i := 0; i < 5; i++ {
+		var se *ServerError
+		if _, err := b.Do(req); !errors.As(err, &se) || se.StatusCode != 404 {
+			t.Fatalf("expected 404 to be a failure, got %v", err)
+		}
+	}
+	if state, _ := b.Status(); state != gobreaker.StateOpen {
+		t.Fatalf("expected repeated 404s to trip the breaker, got %s", state)
+	}
+}
+
+func TestBreakerClassifier_DefaultPassesClientErrors(t *testing.T) {
+	b := newClassifiedBreaker(&statusBodyClient{status: 404}, nil)
+	req, _ := http.NewRequest("GET", "http://users-api:8083/users/nobody", nil)
+
+	resp, err := b.Do(req)
+	if err != nil || resp.StatusCode != 404 {
+		t.Fatalf("expected 404 response by default, got resp=%v err=%v", resp, err)
+	}
+	if st := b.Stats(); st.Lifetime.Successes != 1 || st.Lifetime.Failures != 0 {
+		t.Fatalf("unexpected stats: %+v", st.Lifetime)
+	}
+}
+
+func TestBreakerClassifier_ErrorClasses(t *testing.T) {
+	c := DefaultBreakerClassifier()
+	c.FailureErrorClasses = map[RetryErrorClass]bool{ErrClassTimeout: true}
+	req, _ := http.NewRequest("GET", "http://users-api:8083/users/admin", nil)
+
+	if outcome, _ := c.Classify(req, nil, syscall.ECONNREFUSED); outcome != OutcomeIgnored {
+		t.Fatalf("expected conn_refused to be ignored, got %v", outcome)
+	}
+	if outcome, _ := c.Classify(req, nil, timeoutErr{}); outcome != OutcomeFailure {
+		t.Fatalf("expected timeout to be a failure, got %v", outcome)
+	}
+}
+
+func TestBreakerClassifier_BodyPredicate(t *testing.T) {
+	c := DefaultBreakerClassifier()
+	c.FailureBody = func(resp *http.Response, body []byte) bool {
+		return strings.Contains(string(body), "maintenance")
+	}
+	req, _ := http.NewRequest("GET", "http://users-api:8083/users/admin", nil)
+
+	b := newClassifiedBreaker(&statusBodyClient{status: 200, body: `{"error":"maintenance"}`}, c)
+	var be *ResponseBodyError
+	if _, err := b.Do(req); !errors.As(err, &be) {
+		t.Fatalf("expected body predicate failure, got %v", err)
+	}
+	if st := b.Stats(); st.LastFailure == nil || st.LastFailure.Reason != "body" {
+		t.Fatalf("expected last failure reason body, got %+v", st.LastFailure)
+	}
+
+	// the body of a passing response is still readable in full
+	b = newClassifiedBreaker(&statusBodyClient{status: 200, body: `{"username":"admin"}`}, c)
+	resp, err := b.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if body, _ := io.ReadAll(resp.Body); string(body) != `{"username":"admin"}` {
+		t.Fatalf("expected body to be replayed, got %q", body)
+	}
+}
+
+func TestBreakerClassifier_IgnoresCallerCancellation(t *testing.T) {
+	b := newClassifiedBreaker(nil, nil)
+	for i := 0; i < 10; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		b.client = &cancelingClient{cancel: cancel}
+		req, _ := http.NewRequestWithContext(ctx, "GET", "http://users-api:8083/users/admin", nil)
+		if _, err := b.Do(req); !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected the caller's cancellation, got %v", err)
+		}
+	}
+
+	st := b.Stats()
+	if st.State != "closed" || st.Lifetime.Failures != 0 || st.Lifetime.Ignored != 10 {
+		t.Fatalf("expected cancellations to be ignored, got state=%s lifetime=%+v", st.State, st.Lifetime)
+	}
+}
+
+func TestBreakerClassifier_CancelledProbeKeepsHalfOpen(t *testing.T) {
+	s := DefaultBreakerSettings()
+	s.Name = "classified-breaker"
+	s.MaxRequests = 1
+	s.Timeout = 20 * time.Millisecond
+	b := newBreakerHTTPClientWithSettings(&failingClient{}, s)
+	req, _ := http.NewRequest("GET", "http://users-api:8083/users/admin", nil)
+	for i := 0; i < int(s.ConsecutiveFailures); i++ {
+		b.Do(req)
+	}
+	time.Sleep(s.Timeout + 10*time.Millisecond)
+	if st := b.Stats(); st.State != "half-open" {
+		t.Fatalf("expected the breaker to be half-open, got %s", st.State)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b.client = &cancelingClient{cancel: cancel}
+	b.Do(req.WithContext(ctx))
+	if st := b.Stats(); st.State != "half-open" || st.Window.Requests != 0 {
+		t.Fatalf("expected the cancelled probe to leave the breaker half-open, got state=%s window=%+v", st.State, st.Window)
+	}
+
+	// the slot taken by the cancelled probe is free for the next one
+	b.client = &statusBodyClient{status: http.StatusOK}
+	if _, err := b.Do(req); err != nil {
+		t.Fatalf("expected the next probe to be let through, got %v", err)
+	}
+	if st := b.Stats(); st.State != "closed" {
+		t.Fatalf("expected the successful probe to close the breaker, got %s", st.State)
+	}
+}
+
+func TestBreakerClassifier_CountsCancellationWhenNotIgnored(t *testing.T) {
+	c := DefaultBreakerClassifier()
+	c.IgnoreCallerCancellation = false
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, "GET", "http://users-api:8083/users/admin", nil)
+	cancel()
+
+	if outcome, _ := c.Classify(req, nil, context.Canceled); outcome != OutcomeFailure {
+		t.Fatalf("expected cancellation to be a failure, got %v", outcome)
+	}
+}
+
+func TestBreakerClassifier_SlidingIgnoresCalls(t *testing.T) {
+	s := DefaultBreakerSettings()
+	s.Name = "classified-sliding-breaker"
+	s.Type = BreakerTypeSliding
+	s.Classifier = &BreakerClassifier{FailureErrorClasses: map[RetryErrorClass]bool{}}
+	b := newBreakerHTTPClientWithSettings(&failingClient{}, s)
+	req, _ := http.NewRequest("GET", "http://users-api:8083/users/admin", nil)
+
+	for i := 0; i < 10; i++ {
+		b.Do(req)
+	}
+	if st := b.Stats(); st.Window.Requests != 0 || st.Lifetime.Ignored != 10 {
+		t.Fatalf("expected ignored calls to stay out of the window, got %+v", st)
+	}
+}
+
+func TestBreakerClassifierFromEnv(t *testing.T) {
+	t.Setenv("CB_FAILURE_STATUS_CODES", "404,5xx")
+	t.Setenv("CB_FAILURE_BODY_REGEX", `"error":`)
+
+	c, err := breakerClassifierFromEnv(os.LookupEnv)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !c.FailureStatusCodes[404] || !c.FailureStatusCodes[503] || c.FailureStatusCodes[400] {
+		t.Fatalf("unexpected status codes: %v", c.FailureStatusCodes)
+	}
+	if !c.IgnoreCallerCancellation || c.FailureBody == nil || !c.FailureBody(nil, []byte(`{"error":"x"}`)) {
+		t.Fatalf("unexpected classifier: %+v", c)
+	}
+
+	t.Setenv("CB_FAILURE_ERROR_CLASSES", "bogus")
+	if _, err := breakerClassifierFromEnv(os.LookupEnv); err == nil {
+		t.Fatal("expected an error for an unknown error class")
+	}
+}
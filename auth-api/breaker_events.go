@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/sony/gobreaker/v2"
+)
+
+// EventBreakerStateChange is the AuthEvent type of breaker transitions.
+const EventBreakerStateChange = "breaker.state_change"
+
+// BreakerEvent describes a circuit breaker state transition.
+type BreakerEvent struct {
+	Breaker string    `json:"breaker"`
+	From    string    `json:"from"`
+	To      string    `json:"to"`
+	Time    time.Time `json:"time"`
+}
+
+// breakerNotifier logs breaker transitions, publishes them to the auth event
+// stream and delivers them to webhooks in the background.
+type breakerNotifier struct {
+	logger   *slog.Logger
+	events   *eventStream
+	webhooks []string
+	client   *http.Client
+	attempts int
+	backoff  time.Duration
+	queue    chan BreakerEvent
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func newBreakerNotifier(logger *slog.Logger, events *eventStream, webhooks []string) *breakerNotifier {
+	n := &breakerNotifier{
+		logger:   logger,
+		events:   events,
+		webhooks: webhooks,
+		client:   &http.Client{Timeout: 5 * time.Second},
+		attempts: 3,
+		backoff:  500 * time.Millisecond,
+		queue:    make(chan BreakerEvent, 64),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if len(webhooks) > 0 {
+		go n.deliverLoop()
+	} else {
+		close(n.done)
+	}
+	return n
+}
+
+// OnStateChange matches BreakerSettings.OnStateChange. It never blocks.
+func (n *breakerNotifier) OnStateChange(name string, from, to gobreaker.State) {
+	ev := BreakerEvent{Breaker: name, From: from.String(), To: to.String(), Time: time.Now()}
+
+	if n.logger != nil {
+		n.logger.Info("circuit breaker state changed",
+			"event", EventBreakerStateChange,
+			"breaker", ev.Breaker,
+			"from", ev.From,
+			"to", ev.To,
+		)
+	}
+	if n.events != nil {
+		n.events.Publish(AuthEvent{Type: EventBreakerStateChange, Time: ev.Time, Data: ev})
+	}
+	if len(n.webhooks) == 0 {
+		return
+	}
+	select {
+	case n.queue <- ev:
+	default:
+		if n.logger != nil {
+			n.logger.Warn("breaker webhook queue full, dropping transition", "breaker", ev.Breaker, "from", ev.From, "to", ev.To)
+		}
+	}
+}
+
+func (n *breakerNotifier) deliverLoop() {
+	defer close(n.done)
+	for {
+		select {
+		case ev := <-n.queue:
+			n.deliverAll(ev)
+		case <-n.stop:
+			// deliver what was queued before Close
+			for {
+				select {
+				case ev := <-n.queue:
+					n.deliverAll(ev)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (n *breakerNotifier) deliverAll(ev BreakerEvent) {
+	body, _ := json.Marshal(ev)
+	for _, url := range n.webhooks {
+		if err := n.deliver(url, body); err != nil && n.logger != nil {
+			n.logger.Warn("could not deliver breaker event", "webhook", url, "error", err)
+		}
+	}
+}
+
+// Close delivers the queued events and stops the webhook sender. Events
+// raised afterwards are still logged and published but not delivered.
+func (n *breakerNotifier) Close(ctx context.Context) error {
+	select {
+	case <-n.stop:
+	default:
+		close(n.stop)
+	}
+	select {
+	case <-n.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("breaker webhooks not delivered: %w", ctx.Err())
+	}
+}
+
+// deliver posts body to url, retrying with exponential backoff.
+func (n *breakerNotifier) deliver(url string, body []byte) error {
+	var err error
+	delay := n.backoff
+	for attempt := 1; attempt <= n.attempts; attempt++ {
+		var resp *http.Response
+		resp, err = n.client.Post(url, "application/json", bytes.NewReader(body))
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode < 300 {
+				return nil
+			}
+			err = fmt.Errorf("webhook answered %d", resp.StatusCode)
+		}
+		if attempt < n.attempts {
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+	return err
+}
+
+// breakerWebhooksFromEnv reads the comma separated CB_WEBHOOK_URLS.
+func breakerWebhooksFromEnv(env configLookup) ([]string, error) {
+	var urls []string
+	for _, u := range strings.Split(env.get("CB_WEBHOOK_URLS"), ",") {
+		if u = strings.TrimSpace(u); u == "" {
+			continue
+		}
+		if parsed, err := url.Parse(u); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return nil, fmt.Errorf("CB_WEBHOOK_URLS must list http(s) URLs, got %q", redactURL(u))
+		}
+		urls = append(urls, u)
+	}
+	return urls, nil
+}
+
+// breakerEventsHandler streams breaker transitions as Server-Sent Events.
+func breakerEventsHandler(events *eventStream) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		w := c.Response()
+		w.Header().Set(echo.HeaderContentType, "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		w.Flush()
+
+		ch, cancel := events.Subscribe(16)
+		defer cancel()
+
+		heartbeat := time.NewTicker(15 * time.Second)
+		defer heartbeat.Stop()
+
+		ctx := c.Request().Context()
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-heartbeat.C:
+				fmt.Fprint(w, ": keep-alive\n\n")
+				w.Flush()
+			case ev, ok := <-ch:
+				if !ok {
+					// the event stream closed for shutdown
+					return nil
+				}
+				if ev.Type != EventBreakerStateChange {
+					continue
+				}
+				data, err := json.Marshal(ev.Data)
+				if err != nil {
+					continue
+				}
+				fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data)
+				w.Flush()
+			}
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sony/gobreaker/v2"
+)
+
+func TestBreakerNotifier_PublishesTransitions(t *testing.T) {
+	events := newEventStream()
+	ch, cancel := events.Subscribe(4)
+	defer cancel()
+
+	n := newBreakerNotifier(nil, events, nil)
+	s := DefaultBreakerSettings()
+	s.Name = "notify-breaker"
+	s.OnStateChange = n.OnStateChange
+	cbClient := newBreakerHTTPClientWithSettings(&failingClient{}, s)
+
+	req, _ := http.NewRequest("GET", "http://example.local", nil)
+	for i := 0; i < 6; i++ {
+		cbClient.Do(req)
+	}
+
+	select {
+	case ev := <-ch:
+		be, ok := ev.Data.(BreakerEvent)
+		if ev.Type != EventBreakerStateChange || !ok || be.Breaker != "notify-breaker" || be.From != "closed" || be.To != "open" {
+			t.Fatalf("unexpected event: %+v", ev)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no state change event published")
+	}
+}
+
+func TestBreakerNotifier_WebhookRetried(t *testing.T) {
+	var calls int32
+	received := make(chan BreakerEvent, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		var ev BreakerEvent
+		json.NewDecoder(r.Body).Decode(&ev)
+		received <- ev
+	}))
+	defer srv.Close()
+
+	n := newBreakerNotifier(nil, nil, []string{srv.URL})
+	n.backoff = time.Millisecond
+	n.OnStateChange("users-api-breaker", gobreaker.StateClosed, gobreaker.StateOpen)
+
+	select {
+	case ev := <-received:
+		if ev.Breaker != "users-api-breaker" || ev.To != "open" {
+			t.Fatalf("unexpected webhook payload: %+v", ev)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("webhook was not delivered")
+	}
+	if atomic.LoadInt32(&calls) != 2 {
+		t.Fatalf("expected 2 webhook calls, got %d", calls)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// breakerRegistry routes each request to a circuit breaker chosen by host, or
+// by host and route template, so one failing dependency never trips another.
+// Breakers are created lazily on first use.
+type breakerRegistry struct {
+	client   HTTPDoer
+	defaults BreakerSettings
+	byRoute  bool
+
+	mu       sync.Mutex
+	settings map[string]BreakerSettings
+	breakers map[string]*breakerHTTPClient
+}
+
+func newBreakerRegistry(client HTTPDoer, defaults BreakerSettings, byRoute bool) *breakerRegistry {
+	return &breakerRegistry{
+		client:   client,
+		defaults: defaults,
+		byRoute:  byRoute,
+		settings: map[string]BreakerSettings{},
+		breakers: map[string]*breakerHTTPClient{},
+	}
+}
+
+// Configure sets the settings used for breakers of a host or of a full
+// registry key. It only affects breakers created afterwards.
+func (r *breakerRegistry) Configure(hostOrKey string, s BreakerSettings) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.settings[hostOrKey] = s
+}
+
+// Reconfigure applies the tunables of t to the defaults, to the configured
+// settings and to every breaker created so far, keeping their names.
+func (r *breakerRegistry) Reconfigure(t BreakerSettings) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.defaults = r.defaults.withTunablesOf(t)
+	for k, s := range r.settings {
+		r.settings[k] = s.withTunablesOf(t)
+	}
+	for _, b := range r.breakers {
+		b.Reconfigure(t)
+	}
+}
+
+// Breaker returns the breaker for host and route template, creating it if needed.
+func (r *breakerRegistry) Breaker(host, route string) *breakerHTTPClient {
+	key := r.key(host, route)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if b, ok := r.breakers[key]; ok {
+		return b
+	}
+
+	s, ok := r.settings[key]
+	if !ok {
+		if s, ok = r.settings[host]; !ok {
+			s = r.defaults
+		}
+	}
+	switch {
+	case s.Name == "":
+		s.Name = key
+	case key != host:
+		s.Name = s.Name + " " + route
+	}
+
+	b := newBreakerHTTPClientWithSettings(r.client, s)
+	r.breakers[key] = b
+	return b
+}
+
+func (r *breakerRegistry) key(host, route string) string {
+	if !r.byRoute || route == "" {
+		return host
+	}
+	return host + " " + route
+}
+
+func (r *breakerRegistry) Do(req *http.Request) (*http.Response, error) {
+	return r.Breaker(req.URL.Host, routeTemplateFromContext(req.Context())).Do(req)
+}
+
+var _ HTTPDoer = (*breakerRegistry)(nil)
+
+// Lookup finds a breaker by registry key or by name.
+func (r *breakerRegistry) Lookup(id string) (*breakerHTTPClient, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if b, ok := r.breakers[id]; ok {
+		return b, true
+	}
+	for _, b := range r.breakers {
+		if b.Name() == id {
+			return b, true
+		}
+	}
+	return nil, false
+}
+
+// Stats returns the statistics of b together with its registry key.
+func (r *breakerRegistry) Stats(b *breakerHTTPClient) BreakerStats {
+	st := b.Stats()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for k, rb := range r.breakers {
+		if rb == b {
+			st.Key = k
+			break
+		}
+	}
+	return st
+}
+
+// Status lists every breaker created so far, ordered by key.
+func (r *breakerRegistry) Status() []BreakerStats {
+	r.mu.Lock()
+	keys := make([]string, 0, len(r.breakers))
+	for k := range r.breakers {
+		keys = append(keys, k)
+	}
+	breakers := make(map[string]*breakerHTTPClient, len(r.breakers))
+	for k, b := range r.breakers {
+		breakers[k] = b
+	}
+	r.mu.Unlock()
+
+	sort.Strings(keys)
+	stats := make([]BreakerStats, 0, len(keys))
+	for _, k := range keys {
+		st := breakers[k].Stats()
+		st.Key = k
+		stats = append(stats, st)
+	}
+	return stats
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/sony/gobreaker/v2"
+)
+
+// hostClient fails every call to the given host and succeeds otherwise.
+type hostClient struct {
+	failing string
+}
+
+func (h *hostClient) Do(req *http.Request) (*http.Response, error) {
+	if req.URL.Host == h.failing {
+		return nil, errors.New("simulated failure")
+	}
+	return &http.Response{StatusCode: 200, Body: http.NoBody}, nil
+}
+
+func TestBreakerRegistry_IsolatesHosts(t *testing.T) {
+	settings := DefaultBreakerSettings()
+	reg := newBreakerRegistry(&hostClient{failing: "idp.local"}, settings, false)
+
+	bad, _ := http.NewRequest("GET", "http://idp.local/token", nil)
+	for i := 0; i < 6; i++ {
+		reg.Do(bad)
+	}
+	if _, err := reg.Do(bad); err != gobreaker.ErrOpenState {
+		t.Fatalf("expected idp breaker to be open, got %v", err)
+	}
+
+	good, _ := http.NewRequest("GET", "http://users-api:8083/users/admin", nil)
+	if _, err := reg.Do(good); err != nil {
+		t.Fatalf("users-api call should not be affected by idp breaker: %v", err)
+	}
+
+	infos := reg.Status()
+	if len(infos) != 2 || infos[0].Key != "idp.local" || infos[0].State != "open" || infos[1].State != "closed" {
+		t.Fatalf("unexpected registry status: %+v", infos)
+	}
+}
+
+func TestBreakerRegistry_PerHostSettingsAndRouteKeys(t *testing.T) {
+	reg := newBreakerRegistry(&okClient{}, DefaultBreakerSettings(), true)
+	custom := DefaultBreakerSettings()
+	custom.Name = "users-api-breaker"
+	reg.Configure("users-api:8083", custom)
+
+	req, _ := http.NewRequest("GET", "http://users-api:8083/users/admin", nil)
+	req = req.WithContext(WithRouteTemplate(req.Context(), "/users/{username}"))
+	reg.Do(req)
+
+	b := reg.Breaker("users-api:8083", "/users/{username}")
+	if name := b.cb.Name(); name != "users-api-breaker /users/{username}" {
+		t.Fatalf("unexpected breaker name %q", name)
+	}
+	if infos := reg.Status(); len(infos) != 1 || infos[0].Key != "users-api:8083 /users/{username}" {
+		t.Fatalf("unexpected registry status: %+v", infos)
+	}
+}
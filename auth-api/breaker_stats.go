@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// BreakerStatsSchemaVersion is bumped on any incompatible change to the JSON
+// of BreakerStats, documented in the README.
+const BreakerStatsSchemaVersion = 1
+
+// BreakerStats is the single statistics model of a breaker, served by the
+// breaker debug endpoints.
+type BreakerStats struct {
+	SchemaVersion      int                  `json:"schemaVersion"`
+	Key                string               `json:"key,omitempty"`
+	Name               string               `json:"name"`
+	State              string               `json:"state"`
+	Override           BreakerOverride      `json:"override"`
+	Lifetime           BreakerLifetimeStats `json:"lifetime"`
+	Window             BreakerWindowStats   `json:"window"`
+	LastFailure        *BreakerFailure      `json:"lastFailure,omitempty"`
+	LastStateChange    time.Time            `json:"lastStateChange"`
+	TimeInStateSeconds float64              `json:"timeInStateSeconds"`
+}
+
+// BreakerLifetimeStats counts every call since start or the last reset.
+// Requests = Successes + Failures + Rejected + Ignored.
+type BreakerLifetimeStats struct {
+	Requests  uint64            `json:"requests"`
+	Successes uint64            `json:"successes"`
+	Failures  uint64            `json:"failures"`
+	Rejected  uint64            `json:"rejected"`
+	Ignored   uint64            `json:"ignored"`
+	Fallbacks map[string]uint64 `json:"fallbacks"`
+}
+
+// BreakerWindowStats are the counts the engine currently bases its decisions
+// on: the gobreaker interval or the sliding window.
+type BreakerWindowStats struct {
+	Requests             uint32  `json:"requests"`
+	Successes            uint32  `json:"successes"`
+	Failures             uint32  `json:"failures"`
+	ConsecutiveSuccesses uint32  `json:"consecutiveSuccesses"`
+	ConsecutiveFailures  uint32  `json:"consecutiveFailures"`
+	SlowCalls            uint32  `json:"slowCalls"`
+	FailureRate          float64 `json:"failureRate"`
+}
+
+// BreakerFailure describes the most recent failed call.
+type BreakerFailure struct {
+	Reason string    `json:"reason"`
+	Error  string    `json:"error"`
+	Time   time.Time `json:"time"`
+}
+
+// Stats returns a consistent snapshot of the breaker statistics.
+func (b *breakerHTTPClient) Stats() BreakerStats {
+	cb := b.engine()
+	state, counts := b.Status()
+	since := time.Unix(0, atomic.LoadInt64(&b.stateSince))
+	// ignored calls are admitted by the engine but take no part in the window
+	requests := counts.Requests - counts.TotalExclusions
+
+	stats := BreakerStats{
+		SchemaVersion: BreakerStatsSchemaVersion,
+		Name:          cb.Name(),
+		State:         state.String(),
+		Override:      b.Override(),
+		Lifetime: BreakerLifetimeStats{
+			Requests:  atomic.LoadUint64(&b.reqs),
+			Successes: atomic.LoadUint64(&b.succ),
+			Failures:  atomic.LoadUint64(&b.fail),
+			Rejected:  atomic.LoadUint64(&b.rejects),
+			Ignored:   atomic.LoadUint64(&b.ignored),
+			Fallbacks: map[string]uint64{},
+		},
+		Window: BreakerWindowStats{
+			Requests:             requests,
+			Successes:            counts.TotalSuccesses,
+			Failures:             counts.TotalFailures,
+			ConsecutiveSuccesses: counts.ConsecutiveSuccesses,
+			ConsecutiveFailures:  counts.ConsecutiveFailures,
+		},
+		LastStateChange:    since,
+		TimeInStateSeconds: time.Since(since).Seconds(),
+	}
+	if requests > 0 {
+		stats.Window.FailureRate = float64(counts.TotalFailures) / float64(requests)
+	}
+	if sw, ok := cb.(*slidingWindowBreaker); ok {
+		stats.Window.SlowCalls = sw.Window().SlowCalls
+	}
+
+	b.mu.RLock()
+	for path, n := range b.fallbacks {
+		stats.Lifetime.Fallbacks[path] = n
+	}
+	if b.lastFailure != nil {
+		f := *b.lastFailure
+		stats.LastFailure = &f
+	}
+	b.mu.RUnlock()
+	return stats
+}
+
+func (b *breakerHTTPClient) recordFailure(err error) {
+	atomic.AddUint64(&b.fail, 1)
+	f := &BreakerFailure{Reason: failureReason(err), Error: err.Error(), Time: time.Now()}
+	b.mu.Lock()
+	b.lastFailure = f
+	b.mu.Unlock()
+}
+
+// failureReason names a failure like the retry layer does, e.g. "status_503" or "conn_refused".
+func failureReason(err error) string {
+	var se *ServerError
+	if errors.As(err, &se) {
+		return "status_" + strconv.Itoa(se.StatusCode)
+	}
+	var be *ResponseBodyError
+	if errors.As(err, &be) {
+		return "body"
+	}
+	return string(classifyError(err))
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestBreakerStats_ServerErrorCountedOnce(t *testing.T) {
+	b := newBreakerHTTPClient(&serverErrorClient{}, "stats-breaker")
+	req, _ := http.NewRequest("GET", "http://users-api:8083/users/admin", nil)
+
+	// five 500s trip the breaker, the sixth call is rejected
+	for i := 0; i < 6; i++ {
+		b.Do(req)
+	}
+
+	st := b.Stats()
+	if st.Lifetime.Requests != 6 || st.Lifetime.Failures != 5 || st.Lifetime.Rejected != 1 || st.Lifetime.Successes != 0 {
+		t.Fatalf("unexpected lifetime stats: %+v", st.Lifetime)
+	}
+	if st.LastFailure == nil || st.LastFailure.Reason != "status_500" {
+		t.Fatalf("expected last failure status_500, got %+v", st.LastFailure)
+	}
+	if st.State != "open" || st.SchemaVersion != BreakerStatsSchemaVersion {
+		t.Fatalf("unexpected state or schema version: %s v%d", st.State, st.SchemaVersion)
+	}
+}
+
+func TestBreakerStats_ResetClearsEverything(t *testing.T) {
+	b := newBreakerHTTPClient(&failingClient{}, "stats-reset-breaker")
+	req, _ := http.NewRequest("GET", "http://users-api:8083/users/admin", nil)
+	b.Do(req)
+
+	b.Reset()
+	st := b.Stats()
+	if st.Lifetime.Requests != 0 || st.Lifetime.Failures != 0 || st.Window.Requests != 0 || st.LastFailure != nil {
+		t.Fatalf("expected empty stats after reset, got %+v", st)
+	}
+}
+
+func TestBreakerStats_JSONShape(t *testing.T) {
+	b := newBreakerHTTPClient(&okClient{}, "stats-json-breaker")
+	req, _ := http.NewRequest("GET", "http://users-api:8083/users/admin", nil)
+	b.Do(req)
+
+	raw, _ := json.Marshal(b.Stats())
+	var doc map[string]interface{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{"schemaVersion", "name", "state", "override", "lifetime", "window", "lastStateChange", "timeInStateSeconds"} {
+		if _, ok := doc[field]; !ok {
+			t.Errorf("missing field %q in %s", field, raw)
+		}
+	}
+	if doc["lifetime"].(map[string]interface{})["successes"].(float64) != 1 {
+		t.Errorf("expected one success in %s", raw)
+	}
+}
@@ -1,156 +1,534 @@
 package main
 
 import (
-    "fmt"
-    "net/http"
-    "os"
-    "strconv"
-    "sync/atomic"
-    "time"
-
-    "github.com/sony/gobreaker"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sony/gobreaker/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// circuitBreaker is the breaker engine behind breakerHTTPClient. It is
+// satisfied by *gobreaker.CircuitBreaker and by slidingWindowBreaker.
+type circuitBreaker interface {
+	Name() string
+	State() gobreaker.State
+	Counts() gobreaker.Counts
+	Execute(req func() (interface{}, error)) (interface{}, error)
+}
+
+// Breaker engine types selectable through BreakerSettings.Type.
+const (
+	BreakerTypeGobreaker = "gobreaker"
+	BreakerTypeSliding   = "sliding"
 )
 
-// breakerHTTPClient wraps an HTTPDoer and uses gobreaker to protect calls.
+// Breaker modes; anything but BreakerModeAuto is an operator override.
+const (
+	BreakerModeAuto         = "auto"
+	BreakerModeForcedOpen   = "forced_open"
+	BreakerModeForcedClosed = "forced_closed"
+)
+
+// BreakerOverride describes the operator-selected mode of a breaker.
+type BreakerOverride struct {
+	Mode      string     `json:"mode"`
+	Reason    string     `json:"reason,omitempty"`
+	By        string     `json:"by,omitempty"`
+	Since     time.Time  `json:"since"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// breakerHTTPClient wraps an HTTPDoer and uses a circuit breaker to protect calls.
 type breakerHTTPClient struct {
-    cb     *gobreaker.CircuitBreaker
-    client HTTPDoer
-    // simple atomic counters to provide stable metrics for the handler
-    reqs uint64
-    succ uint64
-    fail uint64
-    consSucc uint64
-    consFail uint64
+	mu       sync.RWMutex
+	cb       circuitBreaker
+	settings BreakerSettings
+	override BreakerOverride
+	fallback BreakerFallback
+	client   HTTPDoer
+	// lifetime totals; the current window lives in the engine's Counts
+	reqs    uint64
+	succ    uint64
+	fail    uint64
+	rejects uint64
+	ignored uint64
+	// fallback results by path, e.g. "Cache" or "Unavailable"
+	fallbacks   map[string]uint64
+	lastFailure *BreakerFailure
+	// unix nanoseconds of the last state change
+	stateSince int64
+	// reconfigured is set while cb, not closed, waits to be replaced by an
+	// engine of the settings applied since
+	reconfigured bool
+}
+
+// BreakerFallback supplies a degraded result for a call rejected by an open
+// breaker. path names the fallback taken and is counted in the breaker stats.
+type BreakerFallback func(req *http.Request, cause error) (resp *http.Response, path string, err error)
+
+// BreakerSettings holds the tunables of a single circuit breaker.
+type BreakerSettings struct {
+	// Name of the breaker; derived from its registry key when empty.
+	Name                string
+	MaxRequests         uint32
+	Interval            time.Duration
+	Timeout             time.Duration
+	MinRequests         uint32
+	FailureRatio        float64
+	ConsecutiveFailures uint32
+
+	// Type selects the breaker engine: BreakerTypeGobreaker (default) or BreakerTypeSliding.
+	Type string
+	// The fields below only apply to the sliding-window engine.
+	Window            time.Duration
+	WindowBuckets     int
+	SlowCallDuration  time.Duration
+	SlowCallRatio     float64
+	HalfOpenSuccesses uint32
+
+	// Classifier decides which calls are failures; DefaultBreakerClassifier when nil.
+	Classifier *BreakerClassifier
+
+	// Store, when set, shares state and windowed counts with other replicas;
+	// Replica identifies this process in the store.
+	Store   sharedBreakerStore
+	Replica string
+
+	// OnStateChange, when set, is called on every state transition. It runs
+	// while the breaker holds its lock, so it must not block.
+	OnStateChange func(name string, from, to gobreaker.State)
+}
+
+// DefaultBreakerSettings are the breaker settings when no CB_* variable is set.
+func DefaultBreakerSettings() BreakerSettings {
+	return BreakerSettings{
+		MaxRequests:         2,
+		Interval:            30 * time.Second,
+		Timeout:             2 * time.Second,
+		MinRequests:         5,
+		FailureRatio:        0.5,
+		ConsecutiveFailures: 5,
+		Window:              60 * time.Second,
+		WindowBuckets:       10,
+		SlowCallRatio:       0.5,
+		HalfOpenSuccesses:   2,
+	}
+}
+
+// breakerSettingsFromEnv reads the CB_* variables over DefaultBreakerSettings,
+// including the failure classification, and rejects invalid values.
+func breakerSettingsFromEnv(env configLookup) (BreakerSettings, error) {
+	s := DefaultBreakerSettings()
+	s.Type = env.get("CB_TYPE")
+	switch s.Type {
+	case "", BreakerTypeGobreaker, BreakerTypeSliding:
+	default:
+		return s, fmt.Errorf("unknown CB_TYPE %q", s.Type)
+	}
+
+	counts := []struct {
+		name string
+		dst  *uint32
+		min  uint64
+	}{
+		{"CB_MAX_REQUESTS", &s.MaxRequests, 1},
+		{"CB_MIN_REQUESTS", &s.MinRequests, 0},
+		{"CB_CONSECUTIVE_FAILURES", &s.ConsecutiveFailures, 1},
+		{"CB_HALF_OPEN_SUCCESSES", &s.HalfOpenSuccesses, 1},
+	}
+	for _, c := range counts {
+		if v := env.get(c.name); v != "" {
+			n, err := strconv.ParseUint(v, 10, 32)
+			if err != nil || n < c.min {
+				return s, fmt.Errorf("%s must be an integer of at least %d, got %q", c.name, c.min, v)
+			}
+			*c.dst = uint32(n)
+		}
+	}
+	// probes needed to close follow the probes let through unless set
+	if env.get("CB_HALF_OPEN_SUCCESSES") == "" {
+		s.HalfOpenSuccesses = s.MaxRequests
+	}
+
+	durations := []struct {
+		name string
+		dst  *time.Duration
+		unit time.Duration
+		min  int
+	}{
+		{"CB_INTERVAL_SECONDS", &s.Interval, time.Second, 0},
+		{"CB_TIMEOUT_SECONDS", &s.Timeout, time.Second, 1},
+		{"CB_WINDOW_SECONDS", &s.Window, time.Second, 1},
+		{"CB_SLOW_CALL_MS", &s.SlowCallDuration, time.Millisecond, 0},
+	}
+	for _, d := range durations {
+		if v := env.get(d.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < d.min {
+				return s, fmt.Errorf("%s must be an integer of at least %d, got %q", d.name, d.min, v)
+			}
+			*d.dst = time.Duration(n) * d.unit
+		}
+	}
+
+	if v := env.get("CB_WINDOW_BUCKETS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return s, fmt.Errorf("CB_WINDOW_BUCKETS must be a positive integer, got %q", v)
+		}
+		s.WindowBuckets = n
+	}
+
+	for _, r := range []struct {
+		name string
+		dst  *float64
+	}{{"CB_FAILURE_RATIO", &s.FailureRatio}, {"CB_SLOW_CALL_RATIO", &s.SlowCallRatio}} {
+		if v := env.get(r.name); v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil || f <= 0 || f > 1 {
+				return s, fmt.Errorf("%s must be greater than 0 and at most 1, got %q", r.name, v)
+			}
+			*r.dst = f
+		}
+	}
+
+	classifier, err := breakerClassifierFromEnv(env)
+	if err != nil {
+		return s, err
+	}
+	s.Classifier = classifier
+	return s, nil
 }
 
 func newBreakerHTTPClient(client HTTPDoer, name string) *breakerHTTPClient {
-    // Read configuration from environment with sensible defaults for testing.
-    maxRequests := int64(2)
-    if v := os.Getenv("CB_MAX_REQUESTS"); v != "" {
-        if n, err := strconv.ParseInt(v, 10, 64); err == nil {
-            maxRequests = n
-        }
-    }
-
-    interval := 30 * time.Second
-    if v := os.Getenv("CB_INTERVAL_SECONDS"); v != "" {
-        if n, err := strconv.Atoi(v); err == nil {
-            interval = time.Duration(n) * time.Second
-        }
-    }
-
-    timeout := 2 * time.Second
-    if v := os.Getenv("CB_TIMEOUT_SECONDS"); v != "" {
-        if n, err := strconv.Atoi(v); err == nil {
-            timeout = time.Duration(n) * time.Second
-        }
-    }
-
-    minRequests := int64(5)
-    if v := os.Getenv("CB_MIN_REQUESTS"); v != "" {
-        if n, err := strconv.ParseInt(v, 10, 64); err == nil {
-            minRequests = n
-        }
-    }
-
-    failureRatio := 0.5
-    if v := os.Getenv("CB_FAILURE_RATIO"); v != "" {
-        if f, err := strconv.ParseFloat(v, 64); err == nil {
-            failureRatio = f
-        }
-    }
-
-    consecutiveFailures := int64(5)
-    if v := os.Getenv("CB_CONSECUTIVE_FAILURES"); v != "" {
-        if n, err := strconv.ParseInt(v, 10, 64); err == nil {
-            consecutiveFailures = n
-        }
-    }
-
-    settings := gobreaker.Settings{
-        Name:        name,
-        MaxRequests: uint32(maxRequests), // when half-open allow a couple requests
-        Interval:    interval,
-        Timeout:     timeout,
-        ReadyToTrip: func(counts gobreaker.Counts) bool {
-            // open the circuit if minRequests reached and error ratio >= failureRatio
-            failures := counts.TotalFailures
-            total := counts.Requests
-            if total >= uint32(minRequests) && float64(failures)/float64(total) >= failureRatio {
-                return true
-            }
-            if counts.ConsecutiveFailures >= uint32(consecutiveFailures) {
-                return true
-            }
-            return false
-        },
-    }
-
-    cb := gobreaker.NewCircuitBreaker(settings)
-    return &breakerHTTPClient{cb: cb, client: client}
+	s := DefaultBreakerSettings()
+	s.Name = name
+	return newBreakerHTTPClientWithSettings(client, s)
+}
+
+func newBreakerHTTPClientWithSettings(client HTTPDoer, s BreakerSettings) *breakerHTTPClient {
+	b := &breakerHTTPClient{
+		settings:   s,
+		override:   BreakerOverride{Mode: BreakerModeAuto, Since: time.Now()},
+		client:     client,
+		fallbacks:  map[string]uint64{},
+		stateSince: time.Now().UnixNano(),
+	}
+	b.cb = b.newEngine()
+	return b
+}
+
+// newEngine builds the engine from the client settings, tracking state changes.
+func (b *breakerHTTPClient) newEngine() circuitBreaker {
+	s := b.settings
+	onStateChange := s.OnStateChange
+	s.OnStateChange = func(name string, from, to gobreaker.State) {
+		atomic.StoreInt64(&b.stateSince, time.Now().UnixNano())
+		if onStateChange != nil {
+			onStateChange(name, from, to)
+		}
+	}
+	return newCircuitBreaker(s)
+}
+
+// newCircuitBreaker builds the breaker engine selected by s.Type.
+func newCircuitBreaker(s BreakerSettings) circuitBreaker {
+	if s.Store != nil {
+		return newDistributedBreaker(s)
+	}
+	if s.Type == BreakerTypeSliding {
+		return newSlidingWindowBreaker(s)
+	}
+
+	settings := gobreaker.Settings{
+		Name:          s.Name,
+		MaxRequests:   s.MaxRequests, // when half-open allow a couple requests
+		Interval:      s.Interval,
+		Timeout:       s.Timeout,
+		OnStateChange: s.OnStateChange,
+		// ignored calls are neither successes nor failures and give back
+		// the half-open slot they took
+		IsExcluded: isIgnoredOutcome,
+		ReadyToTrip: func(counts gobreaker.Counts) bool {
+			// open the circuit if minRequests reached and error ratio >= failureRatio
+			failures := counts.TotalFailures
+			total := counts.Requests - counts.TotalExclusions
+			if total >= s.MinRequests && float64(failures)/float64(total) >= s.FailureRatio {
+				return true
+			}
+			if counts.ConsecutiveFailures >= s.ConsecutiveFailures {
+				return true
+			}
+			return false
+		},
+	}
+
+	return gobreaker.NewCircuitBreaker[interface{}](settings)
 }
 
 func (b *breakerHTTPClient) Do(req *http.Request) (*http.Response, error) {
-    // capture context so we can cancel if needed
-    ctx := req.Context()
-    atomic.AddUint64(&b.reqs, 1)
-
-    // Execute the HTTP call inside the circuit breaker. We adapt to gobreaker's Execute signature.
-    result, err := b.cb.Execute(func() (interface{}, error) {
-        // Respect context deadlines by using the underlying client's Do directly
-        resp, err := b.client.Do(req.WithContext(ctx))
-        if err != nil {
-            return nil, err
-        }
-        // Treat 5xx responses as errors so the breaker counts them
-        if resp.StatusCode >= 500 {
-            // close body to avoid leaks since we are returning an error
-            if resp.Body != nil {
-                resp.Body.Close()
-            }
-            return nil, fmt.Errorf("server error: %d", resp.StatusCode)
-        }
-        return resp, nil
-    })
-
-    if err != nil {
-        atomic.AddUint64(&b.fail, 1)
-        atomic.AddUint64(&b.consFail, 1)
-        atomic.StoreUint64(&b.consSucc, 0)
-        return nil, err
-    }
-
-    // type assert the result
-    resp, _ := result.(*http.Response)
-    if resp.StatusCode >= 500 {
-        atomic.AddUint64(&b.fail, 1)
-        atomic.AddUint64(&b.consFail, 1)
-        atomic.StoreUint64(&b.consSucc, 0)
-        return nil, fmt.Errorf("server error: %d", resp.StatusCode)
-    }
-
-    // success
-    atomic.AddUint64(&b.succ, 1)
-    atomic.AddUint64(&b.consSucc, 1)
-    atomic.StoreUint64(&b.consFail, 0)
-    return resp, nil
+	atomic.AddUint64(&b.reqs, 1)
+
+	// child span showing the time spent behind the breaker; the state is
+	// passed down so the client span can be tagged with it as well
+	state, _ := b.Status()
+	ctx, span := otel.Tracer(tracerName).Start(req.Context(), "circuit_breaker", trace.WithAttributes(
+		attribute.String("breaker.name", b.Name()),
+		attribute.String("breaker.state", state.String()),
+	))
+	defer span.End()
+	req = req.WithContext(withBreakerState(ctx, state.String()))
+
+	// Operator overrides win over the breaker: forced open rejects the call,
+	// forced closed sends it without consulting (or feeding) the breaker.
+	// The call keeps the engine and settings it started with across a reconfiguration.
+	cb := b.engine()
+	b.mu.RLock()
+	execute, classifier := cb.Execute, b.settings.Classifier
+	b.mu.RUnlock()
+	switch b.Override().Mode {
+	case BreakerModeForcedOpen:
+		return b.rejected(req, gobreaker.ErrOpenState)
+	case BreakerModeForcedClosed:
+		execute = func(req func() (interface{}, error)) (interface{}, error) { return req() }
+	}
+
+	if classifier == nil {
+		classifier = DefaultBreakerClassifier()
+	}
+
+	// Execute the HTTP call inside the circuit breaker. We adapt to gobreaker's Execute signature.
+	result, err := execute(func() (interface{}, error) {
+		resp, err := b.client.Do(req)
+		switch outcome, ferr := classifier.Classify(req, resp, err); outcome {
+		case OutcomeIgnored:
+			return nil, &ignoredOutcome{resp: resp, err: err}
+		case OutcomeFailure:
+			// close body to avoid leaks since we are returning an error
+			if resp != nil && resp.Body != nil {
+				resp.Body.Close()
+			}
+			return nil, ferr
+		}
+		return resp, nil
+	})
+
+	var ignored *ignoredOutcome
+	if errors.As(err, &ignored) {
+		atomic.AddUint64(&b.ignored, 1)
+		span.SetAttributes(attribute.String("breaker.outcome", "ignored"))
+		return ignored.resp, ignored.err
+	}
+	if errors.Is(err, gobreaker.ErrOpenState) || errors.Is(err, gobreaker.ErrTooManyRequests) {
+		return b.rejected(req, err)
+	}
+	if err != nil {
+		b.recordFailure(err)
+		span.SetAttributes(attribute.String("breaker.outcome", "failure"))
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	atomic.AddUint64(&b.succ, 1)
+	span.SetAttributes(attribute.String("breaker.outcome", "success"))
+	return result.(*http.Response), nil
+}
+
+// ServerError is returned for responses whose status the classifier counts as a failure.
+type ServerError struct {
+	StatusCode int
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("unexpected status %d", e.StatusCode)
+}
+
+// rejected hands a call refused by the breaker to the fallback, if any.
+func (b *breakerHTTPClient) rejected(req *http.Request, cause error) (*http.Response, error) {
+	atomic.AddUint64(&b.rejects, 1)
+	span := trace.SpanFromContext(req.Context())
+	span.SetAttributes(attribute.String("breaker.outcome", "rejected"))
+
+	b.mu.RLock()
+	fallback := b.fallback
+	b.mu.RUnlock()
+	if fallback == nil {
+		return nil, cause
+	}
+
+	resp, path, err := fallback(req, cause)
+	span.SetAttributes(attribute.String("breaker.fallback", path))
+	if path != "" {
+		b.mu.Lock()
+		b.fallbacks[path]++
+		b.mu.Unlock()
+	}
+	return resp, err
+}
+
+// SetFallback installs the hook used when the breaker rejects a call.
+func (b *breakerHTTPClient) SetFallback(f BreakerFallback) {
+	b.mu.Lock()
+	b.fallback = f
+	b.mu.Unlock()
+}
+
+type breakerStateKey struct{}
+
+func withBreakerState(ctx context.Context, state string) context.Context {
+	return context.WithValue(ctx, breakerStateKey{}, state)
+}
+
+// breakerStateFromContext returns the breaker state a call was admitted in, if any.
+func breakerStateFromContext(ctx context.Context) string {
+	state, _ := ctx.Value(breakerStateKey{}).(string)
+	return state
 }
 
 // ensure breakerHTTPClient implements HTTPDoer
 var _ HTTPDoer = (*breakerHTTPClient)(nil)
 
+// engine returns the breaker engine, replacing it by one of the current
+// settings once a reconfigured engine is closed again.
+func (b *breakerHTTPClient) engine() circuitBreaker {
+	b.mu.RLock()
+	cb, reconfigured := b.cb, b.reconfigured
+	b.mu.RUnlock()
+	// State runs outside b.mu: the engine calls OnStateChange under its own lock
+	if !reconfigured || cb.State() != gobreaker.StateClosed {
+		return cb
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.reconfigured && b.cb == cb {
+		b.cb = b.newEngine()
+		b.reconfigured = false
+	}
+	return b.cb
+}
+
+// Name returns the name of the breaker.
+func (b *breakerHTTPClient) Name() string {
+	return b.engine().Name()
+}
+
 // Status returns the current state and counts of the internal circuit breaker.
+// A forced mode is reported as the state it forces.
 func (b *breakerHTTPClient) Status() (gobreaker.State, gobreaker.Counts) {
-    return b.cb.State(), b.cb.Counts()
-}
-
-// LocalCounts returns a stable snapshot of wrapper counters for debugging.
-func (b *breakerHTTPClient) LocalCounts() map[string]uint64 {
-    return map[string]uint64{
-        "Requests":             atomic.LoadUint64(&b.reqs),
-        "TotalSuccesses":       atomic.LoadUint64(&b.succ),
-        "TotalFailures":        atomic.LoadUint64(&b.fail),
-        "ConsecutiveSuccesses": atomic.LoadUint64(&b.consSucc),
-        "ConsecutiveFailures":  atomic.LoadUint64(&b.consFail),
-    }
+	cb := b.engine()
+	state := cb.State()
+	switch b.Override().Mode {
+	case BreakerModeForcedOpen:
+		state = gobreaker.StateOpen
+	case BreakerModeForcedClosed:
+		state = gobreaker.StateClosed
+	}
+	return state, cb.Counts()
+}
+
+// Override returns the current operator override, reverting an expired one to auto.
+func (b *breakerHTTPClient) Override() BreakerOverride {
+	b.mu.RLock()
+	o := b.override
+	b.mu.RUnlock()
+	if o.ExpiresAt == nil || time.Now().Before(*o.ExpiresAt) {
+		return o
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.override.ExpiresAt != nil && !time.Now().Before(*b.override.ExpiresAt) {
+		b.override = BreakerOverride{Mode: BreakerModeAuto, Reason: "override expired", Since: *b.override.ExpiresAt}
+		atomic.StoreInt64(&b.stateSince, b.override.Since.UnixNano())
+	}
+	return b.override
+}
+
+// SetOverride forces the breaker into mode until ttl elapses (zero ttl never expires).
+func (b *breakerHTTPClient) SetOverride(mode, by, reason string, ttl time.Duration) (BreakerOverride, error) {
+	switch mode {
+	case BreakerModeAuto, BreakerModeForcedOpen, BreakerModeForcedClosed:
+	default:
+		return BreakerOverride{}, fmt.Errorf("unknown breaker mode %q", mode)
+	}
+
+	o := BreakerOverride{Mode: mode, Reason: reason, By: by, Since: time.Now()}
+	if ttl > 0 && mode != BreakerModeAuto {
+		expires := o.Since.Add(ttl)
+		o.ExpiresAt = &expires
+	}
+
+	b.mu.Lock()
+	b.override = o
+	b.mu.Unlock()
+	atomic.StoreInt64(&b.stateSince, o.Since.UnixNano())
+	return o, nil
+}
+
+// withTunablesOf returns t with the identity, hooks and store of s, so new
+// thresholds can be applied to an existing breaker.
+func (s BreakerSettings) withTunablesOf(t BreakerSettings) BreakerSettings {
+	t.Name = s.Name
+	t.Store = s.Store
+	t.Replica = s.Replica
+	t.OnStateChange = s.OnStateChange
+	return t
+}
+
+// Reconfigure applies the tunables of t through a fresh engine with empty
+// counts. A closed breaker moves to it at once; an open or half-open one keeps
+// its engine, and its state, until it closes again. The classifier applies at
+// once. Calls already admitted finish on the old engine; the lifetime totals
+// and the override are kept.
+func (b *breakerHTTPClient) Reconfigure(t BreakerSettings) {
+	closed := b.engine().State() == gobreaker.StateClosed
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.settings = b.settings.withTunablesOf(t)
+	if !closed {
+		b.reconfigured = true
+		return
+	}
+	b.cb = b.newEngine()
+	b.reconfigured = false
+}
+
+// Reset discards the breaker state and counts by building a fresh engine with
+// the same settings. The override, if any, is kept. A shared breaker clears
+// its state in the store as well; the error tells when that failed, in which
+// case only the local state was reset.
+func (b *breakerHTTPClient) Reset() error {
+	b.mu.Lock()
+	old := b.cb
+	b.cb = b.newEngine()
+	b.reconfigured = false
+	b.fallbacks = map[string]uint64{}
+	b.lastFailure = nil
+	b.mu.Unlock()
+
+	// clear the shared state outside the lock, so calls are not held up by
+	// the Redis round trip
+	var err error
+	if d, ok := old.(*distributedBreaker); ok {
+		err = d.clear()
+	}
+
+	atomic.StoreUint64(&b.reqs, 0)
+	atomic.StoreUint64(&b.succ, 0)
+	atomic.StoreUint64(&b.fail, 0)
+	atomic.StoreUint64(&b.rejects, 0)
+	atomic.StoreUint64(&b.ignored, 0)
+	atomic.StoreInt64(&b.stateSince, time.Now().UnixNano())
+	return err
 }
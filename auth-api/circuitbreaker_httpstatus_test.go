@@ -4,7 +4,7 @@ import (
     "net/http"
     "testing"
 
-    "github.com/sony/gobreaker"
+    "github.com/sony/gobreaker/v2"
 )
 
 // client that returns a 500 response
@@ -0,0 +1,614 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+	"gopkg.in/yaml.v3"
+)
+
+// Groups of settings that can be reloaded without a restart.
+const (
+	ReloadLog         = "log"
+	ReloadBreaker     = "breaker"
+	ReloadRetry       = "retry"
+	ReloadCredentials = "credentials"
+	ReloadCORS        = "cors"
+	ReloadTLS         = "tls"
+	ReloadTransport   = "transport"
+)
+
+// Sources of a setting, from the lowest to the highest precedence.
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+	// SourceSecretFile is the content of the file named by a _FILE setting.
+	SourceSecretFile = "secretFile"
+)
+
+// configLookup returns the raw value of a setting by its environment variable
+// name, and whether it is set. os.LookupEnv is one.
+type configLookup func(key string) (string, bool)
+
+func (l configLookup) get(key string) string {
+	v, _ := l(key)
+	return v
+}
+
+// configSetting is one setting of auth-api. Env names it everywhere: it is
+// the environment variable, the flag is derived from it and Path is its key
+// in the config file.
+type configSetting struct {
+	Env  string
+	Path string
+	// Default is shown by /debug/config when the setting is not set.
+	Default string
+	// Secret values are never shown; URL values are shown without password.
+	Secret bool
+	URL    bool
+	// FileFor names the secret setting whose value is read from the file
+	// this setting points to, e.g. a Docker or Kubernetes secret mount.
+	FileFor string
+	// WatchFile settings name a file read at load time, e.g. a certificate:
+	// a change of its content counts as a change of the setting.
+	WatchFile bool
+	// Reload names the group of settings applied together on a reload;
+	// empty means a change needs a restart.
+	Reload string
+}
+
+// Flag is the command-line flag of the setting, e.g. -cb-max-requests.
+func (s configSetting) Flag() string {
+	return strings.ToLower(strings.ReplaceAll(s.Env, "_", "-"))
+}
+
+var configSettings = []configSetting{
+	{Env: "AUTH_API_MODE", Path: "server.mode", Default: ModeDevelopment},
+	{Env: "AUTH_API_PORT", Path: "server.port", Default: "8000"},
+	{Env: "USERS_API_ADDRESS", Path: "usersApi.address", URL: true},
+	{Env: "USERS_API_DIAL_TIMEOUT_MS", Path: "usersApi.dialTimeoutMs", Default: "2000", Reload: ReloadTransport},
+	{Env: "USERS_API_TLS_HANDSHAKE_TIMEOUT_MS", Path: "usersApi.tlsHandshakeTimeoutMs", Default: "3000", Reload: ReloadTransport},
+	{Env: "USERS_API_RESPONSE_HEADER_TIMEOUT_MS", Path: "usersApi.responseHeaderTimeoutMs", Default: "5000", Reload: ReloadTransport},
+	{Env: "USERS_API_TIMEOUT_MS", Path: "usersApi.timeoutMs", Default: "10000", Reload: ReloadTransport},
+	{Env: "USERS_API_MAX_IDLE_CONNS", Path: "usersApi.maxIdleConns", Default: "100", Reload: ReloadTransport},
+	{Env: "USERS_API_MAX_IDLE_CONNS_PER_HOST", Path: "usersApi.maxIdleConnsPerHost", Default: "10", Reload: ReloadTransport},
+	{Env: "USERS_API_MAX_CONNS_PER_HOST", Path: "usersApi.maxConnsPerHost", Default: "0", Reload: ReloadTransport},
+	{Env: "USERS_API_IDLE_CONN_TIMEOUT_SECONDS", Path: "usersApi.idleConnTimeoutSeconds", Default: "90", Reload: ReloadTransport},
+	{Env: "USERS_API_KEEP_ALIVE", Path: "usersApi.keepAlive", Default: "true", Reload: ReloadTransport},
+	{Env: "USERS_API_TCP_KEEP_ALIVE_SECONDS", Path: "usersApi.tcpKeepAliveSeconds", Default: "30", Reload: ReloadTransport},
+	{Env: "USERS_API_HTTP2", Path: "usersApi.http2", Default: "true", Reload: ReloadTransport},
+	{Env: "USERS_API_PROXY", Path: "usersApi.proxy", Default: "$HTTP_PROXY", URL: true, Reload: ReloadTransport},
+	{Env: "USERS_API_CA_FILE", Path: "usersApi.caFile", WatchFile: true, Reload: ReloadTransport},
+	{Env: "USERS_API_CERT_FILE", Path: "usersApi.certFile", WatchFile: true, Reload: ReloadTransport},
+	{Env: "USERS_API_KEY_FILE", Path: "usersApi.keyFile", WatchFile: true, Reload: ReloadTransport},
+	{Env: "JWT_SECRET", Path: "jwt.secret", Secret: true, Reload: ReloadCredentials},
+	{Env: "JWT_SECRET_FILE", Path: "jwt.secretFile", FileFor: "JWT_SECRET", Reload: ReloadCredentials},
+	{Env: "JWT_SECRET_MIN_BITS", Path: "jwt.secretMinBits", Default: strconv.Itoa(DefaultJWTSecretMinBits)},
+	{Env: "ALLOWED_USERS", Path: "credentials.allowedUsers", Default: defaultAllowedUsers, Secret: true, Reload: ReloadCredentials},
+	{Env: "ALLOWED_USERS_FILE", Path: "credentials.allowedUsersFile", FileFor: "ALLOWED_USERS", Reload: ReloadCredentials},
+	{Env: "CORS_ALLOW_ORIGINS", Path: "cors.allowOrigins", Default: "*", Reload: ReloadCORS},
+
+	{Env: "TLS_CERT_FILE", Path: "tls.certFile", WatchFile: true, Reload: ReloadTLS},
+	{Env: "TLS_KEY_FILE", Path: "tls.keyFile", WatchFile: true, Reload: ReloadTLS},
+	{Env: "TLS_MIN_VERSION", Path: "tls.minVersion", Default: "1.2", Reload: ReloadTLS},
+	{Env: "TLS_CIPHER_SUITES", Path: "tls.cipherSuites", Reload: ReloadTLS},
+	{Env: "TLS_CLIENT_AUTH", Path: "tls.clientAuth", Default: TLSClientAuthNone, Reload: ReloadTLS},
+	{Env: "TLS_CLIENT_CA_FILE", Path: "tls.clientCaFile", WatchFile: true, Reload: ReloadTLS},
+	{Env: "TLS_REDIRECT_PORT", Path: "tls.redirectPort"},
+
+	{Env: "LOG_FORMAT", Path: "log.format", Default: LogFormatJSON},
+	{Env: "LOG_LEVEL", Path: "log.level", Default: "info", Reload: ReloadLog},
+	{Env: "LOG_LEVELS", Path: "log.levels", Reload: ReloadLog},
+
+	{Env: "TRACING_EXPORTER", Path: "tracing.exporter", Default: ExporterNone},
+	{Env: "TRACING_ENDPOINT", Path: "tracing.endpoint", URL: true},
+	{Env: "ZIPKIN_URL", Path: "tracing.zipkinUrl", URL: true},
+	{Env: "OTEL_SERVICE_NAME", Path: "tracing.serviceName", Default: "auth-api"},
+	{Env: "SERVICE_VERSION", Path: "tracing.serviceVersion", Default: "unknown"},
+	{Env: "TRACING_SAMPLER", Path: "tracing.sampler", Default: SamplerAlways},
+	{Env: "TRACING_SAMPLER_RATIO", Path: "tracing.samplerRatio", Default: "0.1"},
+	{Env: "TRACING_SAMPLER_RATE", Path: "tracing.samplerRate", Default: "10"},
+	{Env: "TRACING_SAMPLER_PARENT_BASED", Path: "tracing.samplerParentBased", Default: "true"},
+	{Env: "TRACING_SAMPLE_ERRORS", Path: "tracing.sampleErrors", Default: "false"},
+	{Env: "TRACING_DEBUG_HEADER", Path: "tracing.debugHeader", Default: "disabled"},
+	{Env: "TRACING_QUEUE_SIZE", Path: "tracing.queueSize", Default: "2048"},
+	{Env: "TRACING_BATCH_SIZE", Path: "tracing.batchSize", Default: "512"},
+	{Env: "TRACING_SPOOL_FILE", Path: "tracing.spoolFile", Default: "$TMPDIR/auth-api-spans.jsonl"},
+	{Env: "TRACING_SPOOL_MAX_BYTES", Path: "tracing.spoolMaxBytes", Default: "10485760"},
+
+	{Env: "CB_TYPE", Path: "breaker.type", Default: BreakerTypeGobreaker, Reload: ReloadBreaker},
+	{Env: "CB_MAX_REQUESTS", Path: "breaker.maxRequests", Default: "2", Reload: ReloadBreaker},
+	{Env: "CB_INTERVAL_SECONDS", Path: "breaker.intervalSeconds", Default: "30", Reload: ReloadBreaker},
+	{Env: "CB_TIMEOUT_SECONDS", Path: "breaker.timeoutSeconds", Default: "2", Reload: ReloadBreaker},
+	{Env: "CB_MIN_REQUESTS", Path: "breaker.minRequests", Default: "5", Reload: ReloadBreaker},
+	{Env: "CB_FAILURE_RATIO", Path: "breaker.failureRatio", Default: "0.5", Reload: ReloadBreaker},
+	{Env: "CB_CONSECUTIVE_FAILURES", Path: "breaker.consecutiveFailures", Default: "5", Reload: ReloadBreaker},
+	{Env: "CB_WINDOW_SECONDS", Path: "breaker.windowSeconds", Default: "60", Reload: ReloadBreaker},
+	{Env: "CB_WINDOW_BUCKETS", Path: "breaker.windowBuckets", Default: "10", Reload: ReloadBreaker},
+	{Env: "CB_SLOW_CALL_MS", Path: "breaker.slowCallMs", Default: "0", Reload: ReloadBreaker},
+	{Env: "CB_SLOW_CALL_RATIO", Path: "breaker.slowCallRatio", Default: "0.5", Reload: ReloadBreaker},
+	{Env: "CB_HALF_OPEN_SUCCESSES", Path: "breaker.halfOpenSuccesses", Default: "CB_MAX_REQUESTS", Reload: ReloadBreaker},
+	{Env: "CB_FAILURE_STATUS_CODES", Path: "breaker.failureStatusCodes", Default: "5xx", Reload: ReloadBreaker},
+	{Env: "CB_FAILURE_ERROR_CLASSES", Path: "breaker.failureErrorClasses", Default: "all", Reload: ReloadBreaker},
+	{Env: "CB_IGNORE_CANCELED", Path: "breaker.ignoreCanceled", Default: "true", Reload: ReloadBreaker},
+	{Env: "CB_FAILURE_BODY_REGEX", Path: "breaker.failureBodyRegex", Reload: ReloadBreaker},
+	{Env: "CB_KEY_BY_ROUTE", Path: "breaker.keyByRoute", Default: "false"},
+	{Env: "CB_WEBHOOK_URLS", Path: "breaker.webhookUrls", URL: true},
+	{Env: "CB_DISTRIBUTED", Path: "breaker.distributed", Default: "false"},
+	{Env: "CB_REDIS_ADDR", Path: "breaker.redisAddr", Default: "redis-todo:6379"},
+	{Env: "CB_REDIS_PREFIX", Path: "breaker.redisPrefix", Default: "auth-api:cb"},
+	{Env: "BREAK_GLASS_ACCOUNTS", Path: "breakGlass.accounts", Secret: true, Reload: ReloadCredentials},
+	{Env: "BREAK_GLASS_ACCOUNTS_FILE", Path: "breakGlass.accountsFile", FileFor: "BREAK_GLASS_ACCOUNTS", Reload: ReloadCredentials},
+
+	{Env: "RETRY_MAX_RETRIES", Path: "retry.maxRetries", Default: "3", Reload: ReloadRetry},
+	{Env: "RETRY_BASE_DELAY_MS", Path: "retry.baseDelayMs", Default: "200", Reload: ReloadRetry},
+	{Env: "RETRY_MAX_DELAY_MS", Path: "retry.maxDelayMs", Default: "2000", Reload: ReloadRetry},
+	{Env: "RETRY_STATUS_CODES", Path: "retry.statusCodes", Default: "429,5xx", Reload: ReloadRetry},
+	{Env: "RETRY_ERROR_CLASSES", Path: "retry.errorClasses", Default: "all", Reload: ReloadRetry},
+	{Env: "RETRY_ON_BREAKER_OPEN", Path: "retry.onBreakerOpen", Default: "false", Reload: ReloadRetry},
+
+	{Env: "HEDGE_DELAY_MS", Path: "hedge.delayMs", Default: "disabled"},
+	{Env: "HEDGE_ADAPTIVE", Path: "hedge.adaptive", Default: "false"},
+	{Env: "HEDGE_BUDGET_RATIO", Path: "hedge.budgetRatio", Default: "0.1"},
+
+	{Env: "HEALTH_CACHE_SECONDS", Path: "health.cacheSeconds", Default: "5"},
+	{Env: "HEALTH_CHECK_TIMEOUT_MS", Path: "health.checkTimeoutMs", Default: "2000"},
+
+	{Env: "SHUTDOWN_DRAIN_SECONDS", Path: "shutdown.drainSeconds", Default: "5"},
+	{Env: "SHUTDOWN_TIMEOUT_SECONDS", Path: "shutdown.timeoutSeconds", Default: "20"},
+
+	{Env: "CONFIG_WATCH_SECONDS", Path: "config.watchSeconds", Default: "5"},
+}
+
+// Config is the typed configuration of auth-api.
+type Config struct {
+	Mode              string
+	Port              string
+	UsersAPIAddress   string
+	UsersAPITransport TransportConfig
+	JWTSecret         Secret
+	AllowedUsers      map[string]string
+	CORS              CORSConfig
+	TLS               TLSConfig
+	Log               LogConfig
+	Tracing           TracingConfig
+	Breaker           BreakerSettings
+	BreakerKeyByRoute bool
+	BreakerWebhooks   []string
+	BreakerStore      BreakerStoreConfig
+	BreakGlass        map[string]breakGlassAccount
+	Retry             RetryConfig
+	Hedge             HedgeConfig
+	HedgeEnabled      bool
+	Health            HealthConfig
+	Shutdown          ShutdownConfig
+	// WatchInterval is how often the config file is checked for changes;
+	// zero disables the check.
+	WatchInterval time.Duration
+	// Warnings are the settings accepted in development that production
+	// would refuse.
+	Warnings []string
+
+	// File is the config file read, if any.
+	File string
+	// digest of the config and secret files, taken before reading them
+	digest []byte
+	values map[string]configValue
+}
+
+// configValue is the raw value of a setting and where it came from.
+type configValue struct {
+	Value  string
+	Source string
+	// digest of the file named by a WatchFile setting
+	digest []byte
+}
+
+// ConfigError lists every invalid setting found while loading the config.
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return "invalid configuration: " + strings.Join(e.Problems, "; ")
+}
+
+// loadConfig reads the settings from, by increasing precedence, their
+// defaults, the config file (-config or CONFIG_FILE), the environment and
+// the command-line flags, and validates them. Secrets can be read from the
+// file named by their _FILE setting instead. Every invalid setting is
+// reported in a *ConfigError; -h returns flag.ErrHelp.
+func loadConfig(args []string, env configLookup, usage io.Writer) (*Config, error) {
+	fs := flag.NewFlagSet("auth-api", flag.ContinueOnError)
+	fs.SetOutput(usage)
+	file := fs.String("config", env.get("CONFIG_FILE"), "YAML or JSON config file")
+	flags := make(map[string]*string, len(configSettings))
+	for _, s := range configSettings {
+		flags[s.Env] = fs.String(s.Flag(), "", "overrides "+s.Env)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, &ConfigError{Problems: []string{fmt.Sprintf("unexpected argument %q", fs.Arg(0))}}
+	}
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	fromFile := map[string]string{}
+	digests := [][]byte{fileDigest(*file)}
+	if *file != "" {
+		var err error
+		if fromFile, err = readConfigFile(*file); err != nil {
+			return nil, &ConfigError{Problems: []string{err.Error()}}
+		}
+	}
+
+	cfg := &Config{File: *file, values: map[string]configValue{}}
+	for _, s := range configSettings {
+		if set[s.Flag()] {
+			cfg.values[s.Env] = configValue{Value: *flags[s.Env], Source: SourceFlag}
+		} else if v, ok := env(s.Env); ok {
+			cfg.values[s.Env] = configValue{Value: v, Source: SourceEnv}
+		} else if v, ok := fromFile[s.Env]; ok {
+			cfg.values[s.Env] = configValue{Value: v, Source: SourceFile}
+		}
+	}
+	var problems []string
+	for _, s := range configSettings {
+		path, _ := cfg.lookup(s.Env)
+		if (s.FileFor == "" && !s.WatchFile) || path == "" {
+			continue
+		}
+		if _, ok := cfg.values[s.FileFor]; ok {
+			msg := fmt.Sprintf("set only one of %s and %s", s.FileFor, s.Env)
+			problems = append(problems, msg+cfg.origin(msg))
+			continue
+		}
+		digest := fileDigest(path)
+		digests = append(digests, digest)
+		if s.WatchFile {
+			v := cfg.values[s.Env]
+			v.digest = digest
+			cfg.values[s.Env] = v
+			continue
+		}
+		v, err := readSecretFile(s.Env, path)
+		if err != nil {
+			problems = append(problems, err.Error()+cfg.origin(err.Error()))
+			continue
+		}
+		cfg.values[s.FileFor] = configValue{Value: v, Source: SourceSecretFile}
+	}
+	if len(problems) > 0 {
+		return nil, &ConfigError{Problems: problems}
+	}
+	cfg.digest = joinDigests(digests)
+	if err := cfg.parse(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// watchedFiles are the config file, the secret files and the other files
+// read by a setting, whose changes trigger a reload.
+func (c *Config) watchedFiles() []string {
+	files := []string{c.File}
+	for _, s := range configSettings {
+		if s.FileFor == "" && !s.WatchFile {
+			continue
+		}
+		if path, _ := c.lookup(s.Env); path != "" {
+			files = append(files, path)
+		}
+	}
+	return files
+}
+
+// lookup reads the merged settings.
+func (c *Config) lookup(key string) (string, bool) {
+	v, ok := c.values[key]
+	return v.Value, ok
+}
+
+// parse fills the typed fields from the merged settings, collecting the
+// errors of every section.
+func (c *Config) parse() error {
+	env := configLookup(c.lookup)
+	var problems []string
+	check := func(err error) {
+		if err != nil {
+			problems = append(problems, err.Error()+c.origin(err.Error()))
+		}
+	}
+	c.Warnings = nil
+
+	var err error
+	c.Mode, err = modeFromEnv(env)
+	check(err)
+	c.Port = env.get("AUTH_API_PORT")
+	if c.Port == "" {
+		c.Port = "8000"
+	}
+	check(validatePort(c.Port))
+	c.UsersAPIAddress = env.get("USERS_API_ADDRESS")
+	check(validateUsersAPIAddress(c.UsersAPIAddress))
+	c.UsersAPITransport, err = transportConfigFromEnv(env)
+	check(err)
+	var warning string
+	if c.JWTSecret, warning, err = jwtSecretFromEnv(env, c.Mode); warning != "" {
+		c.Warnings = append(c.Warnings, warning+c.origin(warning))
+	}
+	check(err)
+	c.AllowedUsers, err = allowedUsersFromEnv(env)
+	check(err)
+	c.CORS, err = corsConfigFromEnv(env)
+	check(err)
+
+	c.TLS, err = tlsConfigFromEnv(env)
+	check(err)
+	if c.TLS.RedirectPort == c.Port {
+		check(errors.New("TLS_REDIRECT_PORT must differ from AUTH_API_PORT"))
+	}
+
+	c.Log, err = logConfigFromEnv(env)
+	check(err)
+	c.Tracing, err = tracingConfigFromEnv(env)
+	check(err)
+	c.Breaker, err = breakerSettingsFromEnv(env)
+	check(err)
+	if v := env.get("CB_KEY_BY_ROUTE"); v != "" {
+		if c.BreakerKeyByRoute, err = strconv.ParseBool(v); err != nil {
+			check(fmt.Errorf("CB_KEY_BY_ROUTE must be true or false, got %q", v))
+		}
+	}
+	c.BreakerWebhooks, err = breakerWebhooksFromEnv(env)
+	check(err)
+	c.BreakerStore, err = breakerStoreConfigFromEnv(env)
+	check(err)
+	c.BreakGlass, err = breakGlassAccountsFromEnv(env)
+	check(err)
+	c.Retry, err = retryConfigFromEnv(env)
+	check(err)
+	c.Hedge, c.HedgeEnabled, err = hedgeConfigFromEnv(env)
+	check(err)
+	c.Health, err = healthConfigFromEnv(env)
+	check(err)
+	c.Shutdown, err = shutdownConfigFromEnv(env)
+	check(err)
+	c.WatchInterval = 5 * time.Second
+	if v := env.get("CONFIG_WATCH_SECONDS"); v != "" {
+		if n, err := strconv.Atoi(v); err != nil || n < 0 {
+			check(fmt.Errorf("CONFIG_WATCH_SECONDS must be a non-negative integer, got %q", v))
+		} else {
+			c.WatchInterval = time.Duration(n) * time.Second
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ConfigError{Problems: problems}
+	}
+	return nil
+}
+
+// origin tells where the settings named in an error message were set, so a
+// bad value can be found whichever source it came from.
+func (c *Config) origin(msg string) string {
+	var where []string
+	for _, s := range configSettings {
+		v, ok := c.values[s.Env]
+		if !ok || !regexp.MustCompile(`\b`+s.Env+`\b`).MatchString(msg) {
+			continue
+		}
+		switch v.Source {
+		case SourceFlag:
+			where = append(where, fmt.Sprintf("%s from flag -%s", s.Env, s.Flag()))
+		case SourceEnv:
+			where = append(where, fmt.Sprintf("%s from the environment", s.Env))
+		case SourceFile:
+			where = append(where, fmt.Sprintf("%s from %s in %s", s.Env, s.Path, c.File))
+		case SourceSecretFile:
+			where = append(where, fmt.Sprintf("%s from the file named by %s_FILE", s.Env, s.Env))
+		}
+	}
+	if len(where) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(where, ", ") + ")"
+}
+
+func validatePort(port string) error {
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("AUTH_API_PORT must be a port number, got %q", port)
+	}
+	return nil
+}
+
+func validateUsersAPIAddress(address string) error {
+	if address == "" {
+		return errors.New("USERS_API_ADDRESS is required")
+	}
+	u, err := url.Parse(address)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("USERS_API_ADDRESS must be an http(s) URL, got %q", redactURL(address))
+	}
+	return nil
+}
+
+// readConfigFile reads a YAML (or JSON) file whose keys are the Path of the
+// settings, nested or dotted, e.g. breaker: {maxRequests: 5}. Lists are
+// joined with commas and maps become key=value lists, as in the environment.
+// It returns the values by environment variable name.
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("config file: %w", err)
+	}
+	var doc map[string]any
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+	byPath := make(map[string]string, len(configSettings))
+	for _, s := range configSettings {
+		byPath[s.Path] = s.Env
+	}
+	values := map[string]string{}
+	var problems []string
+	var walk func(prefix string, node map[string]any)
+	walk = func(prefix string, node map[string]any) {
+		for k, v := range node {
+			p := k
+			if prefix != "" {
+				p = prefix + "." + k
+			}
+			if env, ok := byPath[p]; ok {
+				s, err := configFileValue(v)
+				if err != nil {
+					problems = append(problems, fmt.Sprintf("%s in %s: %v", p, path, err))
+				} else if v != nil {
+					values[env] = s
+				}
+				continue
+			}
+			if child, ok := v.(map[string]any); ok {
+				walk(p, child)
+				continue
+			}
+			problems = append(problems, fmt.Sprintf("unknown setting %q in %s", p, path))
+		}
+	}
+	walk("", doc)
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return nil, errors.New(strings.Join(problems, "; "))
+	}
+	return values, nil
+}
+
+func configFileValue(v any) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case []any:
+		items := make([]string, len(v))
+		for i, item := range v {
+			s, err := configFileValue(item)
+			if err != nil {
+				return "", err
+			}
+			items[i] = s
+		}
+		return strings.Join(items, ","), nil
+	case map[string]any:
+		pairs := make([]string, 0, len(v))
+		for k, item := range v {
+			s, err := configFileValue(item)
+			if err != nil {
+				return "", err
+			}
+			pairs = append(pairs, k+"="+s)
+		}
+		sort.Strings(pairs)
+		return strings.Join(pairs, ","), nil
+	case time.Time:
+		return "", errors.New("expected a number or a string, got a timestamp")
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
+
+// ConfigSettingStatus is one row of /debug/config.
+type ConfigSettingStatus struct {
+	Key        string `json:"key"`
+	Path       string `json:"path"`
+	Flag       string `json:"flag"`
+	Value      string `json:"value"`
+	Source     string `json:"source"`
+	Reloadable bool   `json:"reloadable"`
+}
+
+// Settings lists every setting with its effective value, redacted, and its
+// source. Settings left to their default show the documented default.
+func (c *Config) Settings() []ConfigSettingStatus {
+	out := make([]ConfigSettingStatus, 0, len(configSettings))
+	for _, s := range configSettings {
+		st := ConfigSettingStatus{Key: s.Env, Path: s.Path, Flag: "-" + s.Flag(), Value: s.Default, Source: SourceDefault, Reloadable: s.Reload != ""}
+		if v, ok := c.values[s.Env]; ok {
+			st.Value, st.Source = v.Value, v.Source
+		}
+		switch {
+		case s.Secret && st.Value != "":
+			st.Value = redacted
+		case s.URL:
+			urls := strings.Split(st.Value, ",")
+			for i, u := range urls {
+				urls[i] = redactURL(strings.TrimSpace(u))
+			}
+			st.Value = strings.Join(urls, ",")
+		}
+		out = append(out, st)
+	}
+	return out
+}
+
+// String lists the settings as /debug/config shows them, so printing a
+// Config never reveals its secrets.
+func (c Config) String() string {
+	settings := c.Settings()
+	pairs := make([]string, len(settings))
+	for i, s := range settings {
+		pairs[i] = s.Key + "=" + s.Value
+	}
+	return strings.Join(pairs, " ")
+}
+
+func (c Config) GoString() string {
+	return "Config{" + c.String() + "}"
+}
+
+// redactURL hides the password of a URL, and the whole value when it does
+// not parse.
+func redactURL(v string) string {
+	if v == "" {
+		return v
+	}
+	u, err := url.Parse(v)
+	if err != nil {
+		return redacted
+	}
+	// webhooks and collectors often carry their token in the path or query
+	if (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+		u.Path, u.RawPath, u.RawQuery, u.Fragment = "", "", "", ""
+		return u.Redacted() + "/" + redacted
+	}
+	return u.Redacted()
+}
+
+// registerConfigRoutes mounts /debug/config, showing the configuration in
+// effect and the outcome of the reloads, behind the given middleware.
+func registerConfigRoutes(e *echo.Echo, r *configReloader, m ...echo.MiddlewareFunc) {
+	e.GET("/debug/config", func(c echo.Context) error {
+		cfg := r.Current()
+		return c.JSON(http.StatusOK, map[string]any{
+			"service":   "auth-api",
+			"file":      cfg.File,
+			"settings":  cfg.Settings(),
+			"reload":    r.Status(),
+			"timestamp": time.Now().Format(time.RFC3339),
+		})
+	}, m...)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"sync/atomic"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/middleware"
+)
+
+// CORSConfig is the cross-origin policy of the API.
+type CORSConfig struct {
+	// AllowOrigins are the origins, e.g. https://todos.example.com, allowed
+	// to call auth-api from a browser; "*" allows any.
+	AllowOrigins []string
+}
+
+// corsConfigFromEnv reads CORS_ALLOW_ORIGINS, comma separated, defaulting to
+// any origin.
+func corsConfigFromEnv(env configLookup) (CORSConfig, error) {
+	c := CORSConfig{AllowOrigins: []string{"*"}}
+	v := env.get("CORS_ALLOW_ORIGINS")
+	if v == "" {
+		return c, nil
+	}
+	c.AllowOrigins = nil
+	for _, origin := range strings.Split(v, ",") {
+		origin = strings.TrimSpace(origin)
+		if origin == "" {
+			continue
+		}
+		if origin != "*" {
+			u, err := url.Parse(origin)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" || u.RawQuery != "" {
+				return c, fmt.Errorf("CORS_ALLOW_ORIGINS must list * or origins like https://example.com, got %q", origin)
+			}
+		}
+		c.AllowOrigins = append(c.AllowOrigins, origin)
+	}
+	if len(c.AllowOrigins) == 0 {
+		return c, fmt.Errorf("CORS_ALLOW_ORIGINS lists no origin, got %q", v)
+	}
+	return c, nil
+}
+
+// corsMiddleware applies the CORS policy last set by Apply, so the allowed
+// origins can be reloaded.
+type corsMiddleware struct {
+	current atomic.Pointer[echo.MiddlewareFunc]
+}
+
+func newCORSMiddleware(cfg CORSConfig) *corsMiddleware {
+	m := &corsMiddleware{}
+	m.Apply(cfg)
+	return m
+}
+
+// Apply serves cfg from the next request.
+func (m *corsMiddleware) Apply(cfg CORSConfig) {
+	// Let the frontend read the request id of its calls
+	mw := middleware.CORSWithConfig(middleware.CORSConfig{
+		AllowOrigins:  cfg.AllowOrigins,
+		ExposeHeaders: []string{RequestIDHeader},
+	})
+	m.current.Store(&mw)
+}
+
+func (m *corsMiddleware) Middleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return (*m.current.Load())(next)(c)
+	}
+}
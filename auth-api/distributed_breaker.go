@@ -0,0 +1,505 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/sony/gobreaker/v2"
+)
+
+// sharedBreakerStore shares breaker state and windowed counts between replicas.
+type sharedBreakerStore interface {
+	// Record adds a batch of call outcomes to the shared window and returns the
+	// window totals together with how long the shared breaker stays open.
+	Record(ctx context.Context, name string, calls, failures uint32, window time.Duration, buckets int) (sharedWindow, error)
+	// Trip marks the breaker open for every replica during ttl and empties its
+	// window, so the breaker does not trip again on the same failures.
+	Trip(ctx context.Context, name, replica string, ttl time.Duration) error
+	// Watch registers the callback run when a peer trips the named breaker.
+	Watch(name string, onTrip func(replica string, ttl time.Duration))
+	// Clear forgets the shared open state and window of the named breaker.
+	Clear(ctx context.Context, name string) error
+}
+
+// sharedWindow is what a replica reads back from the store.
+type sharedWindow struct {
+	Calls    uint32
+	Failures uint32
+	// OpenFor is how long the shared breaker stays open, zero when closed.
+	OpenFor time.Duration
+}
+
+// distributedBreaker shares its state through a sharedBreakerStore while a
+// local engine keeps protecting calls when the store is unreachable.
+//
+// The shared state goes from closed to open when the shared window trips, and
+// from open to half-open once the open period ends. While half-open each
+// replica lets at most MaxRequests probes through; a failed probe opens the
+// breaker again for everyone, and MaxRequests successful ones close it here.
+// Calls are recorded in the shared window in the background, in batches, so
+// a slow store never delays them.
+type distributedBreaker struct {
+	local   circuitBreaker
+	store   sharedBreakerStore
+	s       BreakerSettings
+	replica string
+
+	opTimeout    time.Duration
+	syncInterval time.Duration
+	retryAfter   time.Duration
+
+	mu            sync.Mutex
+	shared        gobreaker.State
+	openUntil     time.Time
+	probes        uint32
+	successes     uint32
+	reported      gobreaker.State
+	pendingCalls  uint32
+	pendingFails  uint32
+	flushing      bool
+	lastSync      time.Time
+	degradedUntil time.Time
+}
+
+func newDistributedBreaker(s BreakerSettings) *distributedBreaker {
+	if s.Window <= 0 {
+		s.Window = 60 * time.Second
+	}
+	if s.WindowBuckets <= 0 {
+		s.WindowBuckets = 10
+	}
+	if s.MaxRequests == 0 {
+		s.MaxRequests = 1
+	}
+	d := &distributedBreaker{
+		store:        s.Store,
+		s:            s,
+		replica:      s.Replica,
+		opTimeout:    100 * time.Millisecond,
+		syncInterval: time.Second,
+		retryAfter:   5 * time.Second,
+		// a breaker built by a reset must not read the open state back
+		// before the old one has cleared it
+		lastSync: time.Now(),
+	}
+
+	// the transitions notified are those of the combined state, see observe
+	local := s
+	local.Store = nil
+	local.OnStateChange = func(name string, from, to gobreaker.State) {
+		if to == gobreaker.StateOpen {
+			// runs under the local breaker lock, so propagate asynchronously
+			go d.trip()
+		}
+	}
+	d.local = newCircuitBreaker(local)
+
+	d.store.Watch(s.Name, func(replica string, ttl time.Duration) {
+		if replica == d.replica {
+			return
+		}
+		d.markOpen(ttl)
+		d.observe()
+	})
+	return d
+}
+
+func (d *distributedBreaker) Name() string { return d.s.Name }
+
+// State reports the shared state while it is open or half-open, the local one
+// otherwise. It never reads the store.
+func (d *distributedBreaker) State() gobreaker.State {
+	return d.observe()
+}
+
+func (d *distributedBreaker) Counts() gobreaker.Counts {
+	return d.local.Counts()
+}
+
+func (d *distributedBreaker) Execute(req func() (interface{}, error)) (interface{}, error) {
+	probe, err := d.admit()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := d.local.Execute(req)
+	skipped := errors.Is(err, gobreaker.ErrOpenState) || errors.Is(err, gobreaker.ErrTooManyRequests) || isIgnoredOutcome(err)
+	if probe {
+		d.probeDone(err, skipped)
+	} else if !skipped {
+		d.record(err != nil)
+	}
+	d.observe()
+	return result, err
+}
+
+// admit rejects calls while the shared breaker is open and lets at most
+// MaxRequests probes through while it is half-open.
+func (d *distributedBreaker) admit() (probe bool, err error) {
+	d.observe()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.advance(time.Now())
+	switch d.shared {
+	case gobreaker.StateOpen:
+		return false, gobreaker.ErrOpenState
+	case gobreaker.StateHalfOpen:
+		if d.probes >= d.s.MaxRequests {
+			return false, gobreaker.ErrTooManyRequests
+		}
+		d.probes++
+		return true, nil
+	}
+	if time.Since(d.lastSync) >= d.syncInterval {
+		// catch up with trips this replica missed
+		d.startFlush()
+	}
+	return false, nil
+}
+
+// probeDone settles a half-open probe: a failure opens the breaker again for
+// every replica, MaxRequests successes close it.
+func (d *distributedBreaker) probeDone(err error, skipped bool) {
+	d.mu.Lock()
+	if d.shared != gobreaker.StateHalfOpen {
+		d.mu.Unlock()
+		return
+	}
+	d.probes--
+	if skipped {
+		d.mu.Unlock()
+		return
+	}
+	if err != nil {
+		d.mu.Unlock()
+		d.trip()
+		return
+	}
+	d.successes++
+	if d.successes >= d.s.MaxRequests {
+		d.shared = gobreaker.StateClosed
+	}
+	d.mu.Unlock()
+}
+
+// advance moves an open breaker whose open period ended to half-open. It runs
+// under d.mu.
+func (d *distributedBreaker) advance(now time.Time) {
+	if d.shared == gobreaker.StateOpen && !now.Before(d.openUntil) {
+		d.shared = gobreaker.StateHalfOpen
+		d.probes, d.successes = 0, 0
+	}
+}
+
+// observe returns the combined state and notifies OnStateChange when it
+// differs from the last one notified.
+func (d *distributedBreaker) observe() gobreaker.State {
+	d.mu.Lock()
+	d.advance(time.Now())
+	state := d.shared
+	if state == gobreaker.StateClosed {
+		state = d.local.State()
+	}
+	from := d.reported
+	d.reported = state
+	d.mu.Unlock()
+
+	if from != state && d.s.OnStateChange != nil {
+		d.s.OnStateChange(d.s.Name, from, state)
+	}
+	return state
+}
+
+// record queues one outcome for the shared window.
+func (d *distributedBreaker) record(failed bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.shared != gobreaker.StateClosed || !time.Now().After(d.degradedUntil) {
+		return
+	}
+	d.pendingCalls++
+	if failed {
+		d.pendingFails++
+	}
+	d.startFlush()
+}
+
+// startFlush starts flush unless it is already running. It runs under d.mu.
+func (d *distributedBreaker) startFlush() {
+	if d.flushing || !time.Now().After(d.degradedUntil) {
+		return
+	}
+	d.flushing = true
+	go d.flush()
+}
+
+// flush sends the queued outcomes to the store until none are left, tripping
+// the breaker when the shared window crosses the thresholds and catching up
+// with a shared open state. Outcomes queued while a batch is in flight go in
+// the next one.
+func (d *distributedBreaker) flush() {
+	catchUp := true
+	for {
+		d.mu.Lock()
+		calls, failures := d.pendingCalls, d.pendingFails
+		d.pendingCalls, d.pendingFails = 0, 0
+		if (calls == 0 && !catchUp) || d.shared != gobreaker.StateClosed || !time.Now().After(d.degradedUntil) {
+			d.flushing = false
+			d.mu.Unlock()
+			return
+		}
+		d.lastSync = time.Now()
+		d.mu.Unlock()
+		catchUp = false
+
+		ctx, cancel := context.WithTimeout(context.Background(), d.opTimeout)
+		w, err := d.store.Record(ctx, d.s.Name, calls, failures, d.s.Window, d.s.WindowBuckets)
+		cancel()
+		switch {
+		case err != nil:
+			d.degrade()
+		case w.OpenFor > 0:
+			d.markOpen(w.OpenFor)
+			d.observe()
+		case w.Calls >= d.s.MinRequests && w.Calls > 0 && float64(w.Failures)/float64(w.Calls) >= d.s.FailureRatio:
+			d.trip()
+		}
+	}
+}
+
+// clear forgets the shared state, so the engine replacing this one after a
+// reset does not pick the open state up from the store again.
+func (d *distributedBreaker) clear() error {
+	ctx, cancel := context.WithTimeout(context.Background(), d.opTimeout)
+	defer cancel()
+	return d.store.Clear(ctx, d.s.Name)
+}
+
+// trip opens the breaker here and, when the store is reachable, for every peer.
+func (d *distributedBreaker) trip() {
+	d.markOpen(d.s.Timeout)
+	d.observe()
+	if !d.available() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), d.opTimeout)
+	defer cancel()
+	if err := d.store.Trip(ctx, d.s.Name, d.replica, d.s.Timeout); err != nil {
+		d.degrade()
+	}
+}
+
+// markOpen opens the shared breaker, extending the open period to ttl from now.
+func (d *distributedBreaker) markOpen(ttl time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.shared = gobreaker.StateOpen
+	if until := time.Now().Add(ttl); until.After(d.openUntil) {
+		d.openUntil = until
+	}
+	d.probes, d.successes = 0, 0
+	// the window restarts empty with the open period
+	d.pendingCalls, d.pendingFails = 0, 0
+}
+
+func (d *distributedBreaker) available() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return time.Now().After(d.degradedUntil)
+}
+
+// degrade stops using the store for a while; the local engine keeps working.
+func (d *distributedBreaker) degrade() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.degradedUntil = time.Now().Add(d.retryAfter)
+	d.pendingCalls, d.pendingFails = 0, 0
+}
+
+var _ circuitBreaker = (*distributedBreaker)(nil)
+
+// redisBreakerStore implements sharedBreakerStore on Redis: open state is a key
+// expiring with the breaker timeout, window buckets are hashes and trips are
+// announced on a pub/sub channel.
+type redisBreakerStore struct {
+	rdb    *redis.Client
+	prefix string
+
+	mu       sync.RWMutex
+	watchers map[string]func(replica string, ttl time.Duration)
+}
+
+type breakerTripMessage struct {
+	Breaker string `json:"breaker"`
+	Replica string `json:"replica"`
+	TTLms   int64  `json:"ttlMs"`
+}
+
+func newRedisBreakerStore(addr, prefix string) *redisBreakerStore {
+	s := &redisBreakerStore{
+		rdb:      redis.NewClient(&redis.Options{Addr: addr}),
+		prefix:   prefix,
+		watchers: map[string]func(string, time.Duration){},
+	}
+	go s.listen()
+	return s
+}
+
+func (s *redisBreakerStore) channel() string { return s.prefix + ":events" }
+
+// listen dispatches trip announcements; go-redis resubscribes after reconnects.
+func (s *redisBreakerStore) listen() {
+	sub := s.rdb.Subscribe(context.Background(), s.channel())
+	for msg := range sub.Channel() {
+		var m breakerTripMessage
+		if err := json.Unmarshal([]byte(msg.Payload), &m); err != nil {
+			continue
+		}
+		s.mu.RLock()
+		watch := s.watchers[m.Breaker]
+		s.mu.RUnlock()
+		if watch != nil {
+			watch(m.Replica, time.Duration(m.TTLms)*time.Millisecond)
+		}
+	}
+}
+
+func (s *redisBreakerStore) Watch(name string, onTrip func(replica string, ttl time.Duration)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.watchers[name] = onTrip
+}
+
+func (s *redisBreakerStore) Record(ctx context.Context, name string, calls, failures uint32, window time.Duration, buckets int) (sharedWindow, error) {
+	width := window / time.Duration(buckets)
+	current := time.Now().UnixNano() / int64(width)
+	bucketKey := func(epoch int64) string {
+		return s.bucketPrefix(name) + strconv.FormatInt(epoch, 10)
+	}
+
+	pipe := s.rdb.TxPipeline()
+	if calls > 0 {
+		pipe.HIncrBy(ctx, bucketKey(current), "calls", int64(calls))
+		pipe.HIncrBy(ctx, bucketKey(current), "failures", int64(failures))
+		pipe.PExpire(ctx, bucketKey(current), window+width)
+	}
+	results := make([]*redis.SliceCmd, buckets)
+	for i := 0; i < buckets; i++ {
+		results[i] = pipe.HMGet(ctx, bucketKey(current-int64(i)), "calls", "failures")
+	}
+	openFor := pipe.PTTL(ctx, s.openKey(name))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return sharedWindow{}, err
+	}
+
+	var w sharedWindow
+	for _, r := range results {
+		vals := r.Val()
+		w.Calls += redisUint32(vals[0])
+		w.Failures += redisUint32(vals[1])
+	}
+	if ttl := openFor.Val(); ttl > 0 {
+		w.OpenFor = ttl
+	}
+	return w, nil
+}
+
+func redisUint32(v interface{}) uint32 {
+	str, ok := v.(string)
+	if !ok {
+		return 0
+	}
+	n, _ := strconv.ParseUint(str, 10, 32)
+	return uint32(n)
+}
+
+func (s *redisBreakerStore) openKey(name string) string { return s.prefix + ":" + name + ":open" }
+
+func (s *redisBreakerStore) bucketPrefix(name string) string { return s.prefix + ":" + name + ":w:" }
+
+// bucketKeys lists the window buckets of the breaker.
+func (s *redisBreakerStore) bucketKeys(ctx context.Context, name string) ([]string, error) {
+	var keys []string
+	iter := s.rdb.Scan(ctx, 0, s.bucketPrefix(name)+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	return keys, iter.Err()
+}
+
+func (s *redisBreakerStore) Trip(ctx context.Context, name, replica string, ttl time.Duration) error {
+	buckets, err := s.bucketKeys(ctx, name)
+	if err != nil {
+		return err
+	}
+	pipe := s.rdb.TxPipeline()
+	pipe.Set(ctx, s.openKey(name), replica, ttl)
+	if len(buckets) > 0 {
+		pipe.Del(ctx, buckets...)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	payload, _ := json.Marshal(breakerTripMessage{Breaker: name, Replica: replica, TTLms: ttl.Milliseconds()})
+	return s.rdb.Publish(ctx, s.channel(), payload).Err()
+}
+
+// Clear deletes the open key and the window buckets of the breaker.
+func (s *redisBreakerStore) Clear(ctx context.Context, name string) error {
+	buckets, err := s.bucketKeys(ctx, name)
+	if err != nil {
+		return err
+	}
+	return s.rdb.Del(ctx, append(buckets, s.openKey(name))...).Err()
+}
+
+// Ping checks that Redis answers.
+func (s *redisBreakerStore) Ping(ctx context.Context) error {
+	return s.rdb.Ping(ctx).Err()
+}
+
+// BreakerStoreConfig locates the Redis store that shares breaker state
+// between the replicas.
+type BreakerStoreConfig struct {
+	Enabled bool
+	Addr    string
+	Prefix  string
+}
+
+// breakerStoreConfigFromEnv reads CB_DISTRIBUTED, CB_REDIS_ADDR and CB_REDIS_PREFIX.
+func breakerStoreConfigFromEnv(env configLookup) (BreakerStoreConfig, error) {
+	c := BreakerStoreConfig{Addr: "redis-todo:6379", Prefix: "auth-api:cb"}
+	if v := env.get("CB_DISTRIBUTED"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return c, fmt.Errorf("CB_DISTRIBUTED must be true or false, got %q", v)
+		}
+		c.Enabled = b
+	}
+	if v := env.get("CB_REDIS_ADDR"); v != "" {
+		if _, _, err := net.SplitHostPort(v); err != nil {
+			return c, fmt.Errorf("CB_REDIS_ADDR must be host:port, got %q", v)
+		}
+		c.Addr = v
+	}
+	if v := env.get("CB_REDIS_PREFIX"); v != "" {
+		c.Prefix = v
+	}
+	return c, nil
+}
+
+// Store returns the Redis store when sharing is enabled, together with this
+// replica's identity.
+func (c BreakerStoreConfig) Store() (sharedBreakerStore, string) {
+	if !c.Enabled {
+		return nil, ""
+	}
+	return newRedisBreakerStore(c.Addr, c.Prefix), instanceID()
+}
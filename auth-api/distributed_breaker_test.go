@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sony/gobreaker/v2"
+)
+
+// memoryBreakerStore is an in-process sharedBreakerStore shared by test replicas.
+type memoryBreakerStore struct {
+	mu        sync.Mutex
+	calls     uint32
+	failures  uint32
+	openUntil time.Time
+	watchers  []func(replica string, ttl time.Duration)
+	down      bool
+	// delay, when set, holds every Record for that long
+	delay time.Duration
+}
+
+func (m *memoryBreakerStore) Record(ctx context.Context, name string, calls, failures uint32, window time.Duration, buckets int) (sharedWindow, error) {
+	time.Sleep(m.delay)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.down {
+		return sharedWindow{}, errors.New("store unreachable")
+	}
+	m.calls += calls
+	m.failures += failures
+	return sharedWindow{Calls: m.calls, Failures: m.failures, OpenFor: time.Until(m.openUntil)}, nil
+}
+
+func (m *memoryBreakerStore) Trip(ctx context.Context, name, replica string, ttl time.Duration) error {
+	m.mu.Lock()
+	if m.down {
+		m.mu.Unlock()
+		return errors.New("store unreachable")
+	}
+	m.openUntil = time.Now().Add(ttl)
+	m.calls, m.failures = 0, 0
+	watchers := append([]func(string, time.Duration){}, m.watchers...)
+	m.mu.Unlock()
+	for _, w := range watchers {
+		w(replica, ttl)
+	}
+	return nil
+}
+
+func (m *memoryBreakerStore) isOpen() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return time.Now().Before(m.openUntil)
+}
+
+func (m *memoryBreakerStore) window() (calls, failures uint32) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.calls, m.failures
+}
+
+func (m *memoryBreakerStore) Watch(name string, onTrip func(replica string, ttl time.Duration)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.watchers = append(m.watchers, onTrip)
+}
+
+func (m *memoryBreakerStore) Clear(ctx context.Context, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.down {
+		return errors.New("store unreachable")
+	}
+	m.calls, m.failures, m.openUntil = 0, 0, time.Time{}
+	return nil
+}
+
+func newReplicaBreaker(client HTTPDoer, store sharedBreakerStore, replica string) *breakerHTTPClient {
+	s := DefaultBreakerSettings()
+	s.Name = "users-api-breaker"
+	s.ConsecutiveFailures = 100 // trip on the shared failure ratio only
+	s.Store = store
+	s.Replica = replica
+	return newBreakerHTTPClientWithSettings(client, s)
+}
+
+func TestDistributedBreaker_SharesWindowAndPropagatesTrip(t *testing.T) {
+	store := &memoryBreakerStore{}
+	a := newReplicaBreaker(&failingClient{}, store, "replica-a")
+	b := newReplicaBreaker(&failingClient{}, store, "replica-b")
+
+	req, _ := http.NewRequest("GET", "http://users-api:8083/users/admin", nil)
+	// neither replica reaches the minimum volume on its own
+	for i := 0; i < 3; i++ {
+		a.Do(req)
+	}
+	for i := 0; i < 2; i++ {
+		b.Do(req)
+	}
+
+	for name, replica := range map[string]*breakerHTTPClient{"a": a, "b": b} {
+		waitFor(t, "replica "+name+" to open", func() bool {
+			state, _ := replica.Status()
+			return state == gobreaker.StateOpen
+		})
+	}
+	if _, err := a.Do(req); err != gobreaker.ErrOpenState {
+		t.Fatalf("expected peer-propagated open state, got %v", err)
+	}
+}
+
+func TestDistributedBreaker_FallsBackToLocalWhenStoreDown(t *testing.T) {
+	store := &memoryBreakerStore{down: true}
+	b := newReplicaBreaker(&okClient{}, store, "replica-a")
+
+	req, _ := http.NewRequest("GET", "http://users-api:8083/users/admin", nil)
+	if _, err := b.Do(req); err != nil {
+		t.Fatalf("expected call to succeed without the store, got %v", err)
+	}
+
+	b.client = &failingClient{}
+	for i := 0; i < 6; i++ {
+		b.Do(req)
+	}
+	if state, _ := b.Status(); state != gobreaker.StateOpen {
+		t.Fatalf("expected local breaker to trip without the store, got %s", state)
+	}
+}
+
+func TestDistributedBreaker_ResetClearsSharedState(t *testing.T) {
+	store := &memoryBreakerStore{}
+	a := newReplicaBreaker(&failingClient{}, store, "replica-a")
+	req, _ := http.NewRequest("GET", "http://users-api:8083/users/admin", nil)
+	for i := 0; i < 5; i++ {
+		a.Do(req)
+	}
+	waitFor(t, "the breaker to trip", store.isOpen)
+	if state, _ := a.Status(); state != gobreaker.StateOpen {
+		t.Fatalf("expected the breaker to trip, got %s", state)
+	}
+
+	if err := a.Reset(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	a.client = &okClient{}
+	if _, err := a.Do(req); err != nil {
+		t.Fatalf("expected the reset breaker to let calls through, got %v", err)
+	}
+	waitFor(t, "the call to be recorded", func() bool {
+		calls, failures := store.window()
+		return calls == 1 && failures == 0
+	})
+
+	store.mu.Lock()
+	store.down = true
+	store.mu.Unlock()
+	if err := a.Reset(); err == nil {
+		t.Fatal("expected an unreachable store to be reported")
+	}
+}
+
+func TestDistributedBreaker_RecoversThroughHalfOpenProbes(t *testing.T) {
+	store := &memoryBreakerStore{}
+	var mu sync.Mutex
+	var transitions []string
+	s := DefaultBreakerSettings()
+	s.Name = "users-api-breaker"
+	s.ConsecutiveFailures = 100
+	s.Timeout = 30 * time.Millisecond
+	s.MaxRequests = 1
+	s.Store = store
+	s.Replica = "replica-b"
+	s.OnStateChange = func(name string, from, to gobreaker.State) {
+		mu.Lock()
+		defer mu.Unlock()
+		transitions = append(transitions, from.String()+"->"+to.String())
+	}
+	b := newBreakerHTTPClientWithSettings(&okClient{}, s)
+	seen := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), transitions...)
+	}
+
+	// a peer trips the shared window
+	peer := s
+	peer.Replica = "replica-a"
+	peer.OnStateChange = nil
+	a := newBreakerHTTPClientWithSettings(&failingClient{}, peer)
+	req, _ := http.NewRequest("GET", "http://users-api:8083/users/admin", nil)
+	for i := 0; i < 5; i++ {
+		a.Do(req)
+	}
+	waitFor(t, "the peer trip to reach replica b", func() bool { return len(seen()) == 1 })
+	if calls, _ := store.window(); calls != 0 {
+		t.Fatalf("expected the trip to empty the shared window, got %d calls", calls)
+	}
+
+	time.Sleep(s.Timeout + 10*time.Millisecond)
+	if st := b.Stats(); st.State != "half-open" {
+		t.Fatalf("expected the breaker to be half-open once the open period ends, got %s", st.State)
+	}
+	if _, err := b.Do(req); err != nil {
+		t.Fatalf("expected the probe to be let through, got %v", err)
+	}
+	if st := b.Stats(); st.State != "closed" {
+		t.Fatalf("expected the successful probe to close the breaker, got %s", st.State)
+	}
+	want := []string{"closed->open", "open->half-open", "half-open->closed"}
+	if got := seen(); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("expected transitions %v, got %v", want, got)
+	}
+}
+
+func TestDistributedBreaker_HalfOpenLimitsProbes(t *testing.T) {
+	store := &memoryBreakerStore{}
+	s := DefaultBreakerSettings()
+	s.Name = "users-api-breaker"
+	s.ConsecutiveFailures = 100
+	s.MaxRequests = 1
+	s.Store = store
+	s.Replica = "replica-a"
+	d := newDistributedBreaker(s)
+	d.markOpen(0)
+
+	release := make(chan struct{})
+	go d.Execute(func() (interface{}, error) { <-release; return nil, nil })
+	waitFor(t, "the probe to start", func() bool {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return d.probes == 1
+	})
+	if _, err := d.Execute(func() (interface{}, error) { return nil, nil }); err != gobreaker.ErrTooManyRequests {
+		t.Fatalf("expected a second probe to be rejected, got %v", err)
+	}
+	close(release)
+
+	d.markOpen(0)
+	d.Execute(func() (interface{}, error) { return nil, errors.New("users-api down") })
+	if state := d.State(); state != gobreaker.StateOpen {
+		t.Fatalf("expected a failed probe to open the breaker again, got %s", state)
+	}
+}
+
+func TestDistributedBreaker_DoesNotWaitForStore(t *testing.T) {
+	store := &memoryBreakerStore{delay: 200 * time.Millisecond}
+	b := newReplicaBreaker(&okClient{}, store, "replica-a")
+	req, _ := http.NewRequest("GET", "http://users-api:8083/users/admin", nil)
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if _, err := b.Do(req); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		b.Status()
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("expected calls not to wait for the store, took %s", elapsed)
+	}
+	waitFor(t, "the calls to be recorded", func() bool {
+		calls, _ := store.window()
+		return calls == 5
+	})
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// AuthEvent is a notable occurrence inside auth-api, e.g. a breaker transition.
+type AuthEvent struct {
+	Type string      `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data"`
+}
+
+// eventStream fans auth events out to in-process subscribers. Publishing never
+// blocks: a subscriber that does not keep up loses events.
+type eventStream struct {
+	mu     sync.RWMutex
+	subs   map[chan AuthEvent]struct{}
+	closed bool
+}
+
+func newEventStream() *eventStream {
+	return &eventStream{subs: map[chan AuthEvent]struct{}{}}
+}
+
+func (s *eventStream) Publish(ev AuthEvent) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for ch := range s.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel receiving every published event and a function
+// that cancels the subscription.
+func (s *eventStream) Subscribe(buffer int) (<-chan AuthEvent, func()) {
+	ch := make(chan AuthEvent, buffer)
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		close(ch)
+		return ch, func() {}
+	}
+	s.subs[ch] = struct{}{}
+	s.mu.Unlock()
+
+	return ch, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.subs[ch]; ok {
+			delete(s.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// Close ends every subscription, so long-lived streams return, and drops the
+// events published afterwards.
+func (s *eventStream) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for ch := range s.subs {
+		delete(s.subs, ch)
+		close(ch)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fallback paths taken by UserService when the users-api breaker is open.
+const (
+	FallbackCache       = "Cache"
+	FallbackBreakGlass  = "BreakGlass"
+	FallbackUnavailable = "Unavailable"
+)
+
+// fallbackRecord receives the path of the breaker fallback that answered a
+// call. It travels in the request context rather than in a response header,
+// which users-api could send as well.
+type fallbackRecord struct {
+	mu   sync.Mutex
+	path string
+}
+
+type fallbackRecordKey struct{}
+
+// withFallbackRecord returns a context in which the fallback taken by a call,
+// if any, is recorded in the returned fallbackRecord.
+func withFallbackRecord(ctx context.Context) (context.Context, *fallbackRecord) {
+	r := &fallbackRecord{}
+	return context.WithValue(ctx, fallbackRecordKey{}, r), r
+}
+
+// Path returns the fallback path taken, empty when users-api answered.
+func (r *fallbackRecord) Path() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.path
+}
+
+func recordFallback(ctx context.Context, path string) {
+	if r, ok := ctx.Value(fallbackRecordKey{}).(*fallbackRecord); ok {
+		r.mu.Lock()
+		r.path = path
+		r.mu.Unlock()
+	}
+}
+
+// DependencyUnavailableError reports that a dependency could not be reached
+// and no fallback was able to stand in for it.
+type DependencyUnavailableError struct {
+	Dependency string
+	Cause      error
+}
+
+func (e *DependencyUnavailableError) Error() string {
+	return fmt.Sprintf("%s unavailable: %s", e.Dependency, e.Cause)
+}
+
+func (e *DependencyUnavailableError) Unwrap() error { return e.Cause }
+
+// profileCache keeps recently fetched users to serve logins while users-api is down.
+type profileCache struct {
+	ttl   time.Duration
+	mu    sync.RWMutex
+	users map[string]cachedProfile
+}
+
+type cachedProfile struct {
+	user    User
+	expires time.Time
+}
+
+func newProfileCache(ttl time.Duration) *profileCache {
+	return &profileCache{ttl: ttl, users: map[string]cachedProfile{}}
+}
+
+func (c *profileCache) Put(user User) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.users[user.Username] = cachedProfile{user: user, expires: time.Now().Add(c.ttl)}
+}
+
+func (c *profileCache) Get(username string) (User, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	p, ok := c.users[username]
+	if !ok || time.Now().After(p.expires) {
+		return User{}, false
+	}
+	return p.user, true
+}
+
+// breakGlassAccount can log in while users-api is unavailable.
+type breakGlassAccount struct {
+	User         User
+	PasswordHash []byte
+}
+
+func (a breakGlassAccount) checkPassword(password string) bool {
+	sum := sha256.Sum256([]byte(password))
+	return subtle.ConstantTimeCompare(sum[:], a.PasswordHash) == 1
+}
+
+// breakGlassStore holds the break-glass accounts, replaced as a whole when
+// the configuration is reloaded. A nil store has no accounts.
+type breakGlassStore struct {
+	mu       sync.RWMutex
+	accounts map[string]breakGlassAccount
+}
+
+func newBreakGlassStore(accounts map[string]breakGlassAccount) *breakGlassStore {
+	return &breakGlassStore{accounts: accounts}
+}
+
+// Get returns the account of username.
+func (s *breakGlassStore) Get(username string) (breakGlassAccount, bool) {
+	if s == nil {
+		return breakGlassAccount{}, false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	a, ok := s.accounts[username]
+	return a, ok
+}
+
+// Set replaces every account.
+func (s *breakGlassStore) Set(accounts map[string]breakGlassAccount) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.accounts = accounts
+}
+
+// parseBreakGlassAccounts parses "username:sha256hex:role" entries separated by commas.
+func parseBreakGlassAccounts(v string) (map[string]breakGlassAccount, error) {
+	accounts := map[string]breakGlassAccount{}
+	for _, item := range strings.Split(v, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.Split(item, ":")
+		if len(parts) != 3 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid break-glass account %q, expected username:sha256hex:role", parts[0])
+		}
+		hash, err := hex.DecodeString(parts[1])
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("invalid password hash for break-glass account %q", parts[0])
+		}
+		accounts[parts[0]] = breakGlassAccount{
+			User:         User{Username: parts[0], Role: parts[2]},
+			PasswordHash: hash,
+		}
+	}
+	return accounts, nil
+}
+
+// breakGlassAccountsFromEnv reads BREAK_GLASS_ACCOUNTS.
+func breakGlassAccountsFromEnv(env configLookup) (map[string]breakGlassAccount, error) {
+	accounts, err := parseBreakGlassAccounts(env.get("BREAK_GLASS_ACCOUNTS"))
+	if err != nil {
+		return nil, fmt.Errorf("BREAK_GLASS_ACCOUNTS: %w", err)
+	}
+	return accounts, nil
+}
+
+// BreakerFallback serves a user lookup rejected by the users-api breaker from
+// the profile cache, then from the break-glass accounts, and otherwise fails
+// with a DependencyUnavailableError.
+func (h *UserService) BreakerFallback(req *http.Request, cause error) (*http.Response, string, error) {
+	username := path.Base(req.URL.Path)
+
+	if h.Profiles != nil {
+		if user, ok := h.Profiles.Get(username); ok {
+			return fallbackResponse(req, user, FallbackCache), FallbackCache, nil
+		}
+	}
+	if account, ok := h.BreakGlass.Get(username); ok {
+		return fallbackResponse(req, account.User, FallbackBreakGlass), FallbackBreakGlass, nil
+	}
+	return nil, FallbackUnavailable, &DependencyUnavailableError{Dependency: "users-api", Cause: cause}
+}
+
+func fallbackResponse(req *http.Request, user User, path string) *http.Response {
+	recordFallback(req.Context(), path)
+	body, _ := json.Marshal(user)
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       ioutil.NopCloser(bytes.NewReader(body)),
+		Request:    req,
+	}
+}
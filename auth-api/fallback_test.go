@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// userAPIClient answers every lookup with the given body.
+type userAPIClient struct {
+	body string
+}
+
+func (u *userAPIClient) Do(req *http.Request) (*http.Response, error) {
+	return &http.Response{StatusCode: 200, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(u.body))}, nil
+}
+
+func newFallbackTestService(t *testing.T) (*UserService, *breakerHTTPClient) {
+	sum := sha256.Sum256([]byte("s3cret"))
+	accounts, err := parseBreakGlassAccounts("ops:" + hex.EncodeToString(sum[:]) + ":ADMIN")
+	if err != nil {
+		t.Fatalf("could not parse break-glass accounts: %v", err)
+	}
+
+	breaker := newBreakerHTTPClient(&userAPIClient{body: `{"username":"admin","role":"ADMIN"}`}, "fallback-breaker")
+	svc := &UserService{
+		Client:         breaker,
+		UserAPIAddress: "http://users-api:8083",
+		AllowedUsers:   newAllowedUserStore(map[string]string{"admin": "admin"}),
+		Profiles:       newProfileCache(time.Minute),
+		BreakGlass:     newBreakGlassStore(accounts),
+	}
+	breaker.SetFallback(svc.BreakerFallback)
+	return svc, breaker
+}
+
+func TestFallback_CachedProfileAndBreakGlass(t *testing.T) {
+	svc, breaker := newFallbackTestService(t)
+	ctx := context.Background()
+
+	// warm the cache while users-api is healthy, then isolate it
+	if _, err := svc.Login(ctx, "admin", "admin"); err != nil {
+		t.Fatalf("unexpected login error: %v", err)
+	}
+	breaker.SetOverride(BreakerModeForcedOpen, "test", "", 0)
+
+	if user, err := svc.Login(ctx, "admin", "admin"); err != nil || user.Username != "admin" {
+		t.Fatalf("expected cached profile login, got user=%+v err=%v", user, err)
+	}
+	if _, err := svc.Login(ctx, "ops", "s3cret"); err != nil {
+		t.Fatalf("expected break-glass login, got %v", err)
+	}
+	if _, err := svc.Login(ctx, "ops", "wrong"); err != ErrWrongCredentials {
+		t.Fatalf("expected wrong credentials for bad break-glass password, got %v", err)
+	}
+
+	_, err := svc.Login(ctx, "johnd", "foo")
+	var unavailable *DependencyUnavailableError
+	if !errors.As(err, &unavailable) {
+		t.Fatalf("expected dependency unavailable error, got %v", err)
+	}
+
+	counts := breaker.Stats().Lifetime.Fallbacks
+	if counts[FallbackCache] != 1 || counts[FallbackBreakGlass] != 2 || counts[FallbackUnavailable] != 1 {
+		t.Fatalf("unexpected fallback counts: %v", counts)
+	}
+}
+
+// forgedFallbackClient answers like users-api, adding the header that used to
+// mark fallback responses.
+type forgedFallbackClient struct{}
+
+func (forgedFallbackClient) Do(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: 200,
+		Header:     http.Header{"X-Auth-Fallback": []string{FallbackBreakGlass}},
+		Body:       io.NopCloser(strings.NewReader(`{"username":"admin","role":"ADMIN"}`)),
+	}, nil
+}
+
+func TestFallback_PathIsNotReadFromUsersAPI(t *testing.T) {
+	svc, breaker := newFallbackTestService(t)
+	breaker.client = forgedFallbackClient{}
+
+	_, path, err := svc.getUser(context.Background(), "admin")
+	if err != nil || path != "" {
+		t.Fatalf("expected a users-api answer, got path=%q err=%v", path, err)
+	}
+	if _, ok := svc.Profiles.Get("admin"); !ok {
+		t.Fatal("expected the users-api answer to be cached")
+	}
+
+	breaker.SetOverride(BreakerModeForcedOpen, "test", "", 0)
+	if _, path, err = svc.getUser(context.Background(), "admin"); err != nil || path != FallbackCache {
+		t.Fatalf("expected the cache fallback, got path=%q err=%v", path, err)
+	}
+}
+
+func TestParseBreakGlassAccounts_Invalid(t *testing.T) {
+	for _, v := range []string{"ops:nothex:ADMIN", "ops:abcd", ":" + hex.EncodeToString(make([]byte, 32)) + ":ADMIN"} {
+		if _, err := parseBreakGlassAccounts(v); err == nil {
+			t.Errorf("expected error for %q", v)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+// Readiness states of the /health/ready report.
+const (
+	HealthReady    = "ready"
+	HealthDegraded = "degraded"
+	HealthNotReady = "not_ready"
+)
+
+// HealthCheck probes one dependency of the service.
+type HealthCheck struct {
+	Name string
+	// Critical checks make the service not ready when they fail; the others
+	// only degrade it.
+	Critical bool
+	Check    func(ctx context.Context) error
+}
+
+// HealthCheckResult is the last outcome of a check.
+type HealthCheckResult struct {
+	Name      string    `json:"name"`
+	Status    string    `json:"status"`
+	Critical  bool      `json:"critical"`
+	LatencyMs float64   `json:"latencyMs"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+// HealthReport is the body of /health/ready.
+type HealthReport struct {
+	Service   string              `json:"service"`
+	Status    string              `json:"status"`
+	Checks    []HealthCheckResult `json:"checks"`
+	Timestamp string              `json:"timestamp"`
+}
+
+// HealthConfig bounds how often and how long dependencies are probed.
+type HealthConfig struct {
+	// CacheTTL is how long a check result is reused.
+	CacheTTL time.Duration
+	// Timeout of each check.
+	Timeout time.Duration
+}
+
+// healthConfigFromEnv reads HEALTH_CACHE_SECONDS and HEALTH_CHECK_TIMEOUT_MS.
+func healthConfigFromEnv(env configLookup) (HealthConfig, error) {
+	c := HealthConfig{CacheTTL: 5 * time.Second, Timeout: 2 * time.Second}
+	if v := env.get("HEALTH_CACHE_SECONDS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return c, fmt.Errorf("HEALTH_CACHE_SECONDS must be a non-negative integer, got %q", v)
+		}
+		c.CacheTTL = time.Duration(n) * time.Second
+	}
+	if v := env.get("HEALTH_CHECK_TIMEOUT_MS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return c, fmt.Errorf("HEALTH_CHECK_TIMEOUT_MS must be a positive integer, got %q", v)
+		}
+		c.Timeout = time.Duration(n) * time.Millisecond
+	}
+	return c, nil
+}
+
+// healthChecker runs the readiness checks, caching their results for
+// CacheTTL so frequent probes do not hammer the dependencies.
+type healthChecker struct {
+	cfg    HealthConfig
+	checks []HealthCheck
+	now    func() time.Time
+
+	draining int32
+
+	mu      sync.Mutex
+	results map[string]HealthCheckResult
+}
+
+func newHealthChecker(cfg HealthConfig, checks ...HealthCheck) *healthChecker {
+	return &healthChecker{cfg: cfg, checks: checks, now: time.Now, results: map[string]HealthCheckResult{}}
+}
+
+// Add registers another check.
+func (h *healthChecker) Add(check HealthCheck) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checks = append(h.checks, check)
+}
+
+// SetDraining makes the service report not ready from now on, so load
+// balancers stop routing to it before it shuts down.
+func (h *healthChecker) SetDraining() {
+	atomic.StoreInt32(&h.draining, 1)
+}
+
+// Report runs the checks whose cached result expired, concurrently, and
+// returns the readiness of the service.
+func (h *healthChecker) Report(ctx context.Context) HealthReport {
+	// one caller refreshes at a time, the others wait for its results
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// the results are cached for every caller, so a caller going away must
+	// not cancel the checks
+	ctx = context.WithoutCancel(ctx)
+	now := h.now()
+	var wg sync.WaitGroup
+	fresh := make([]HealthCheckResult, len(h.checks))
+	for i, check := range h.checks {
+		if r, ok := h.results[check.Name]; ok && now.Sub(r.CheckedAt) < h.cfg.CacheTTL {
+			fresh[i] = r
+			continue
+		}
+		wg.Add(1)
+		go func(i int, check HealthCheck) {
+			defer wg.Done()
+			fresh[i] = h.run(ctx, check)
+		}(i, check)
+	}
+	wg.Wait()
+
+	report := HealthReport{Service: "auth-api", Status: HealthReady, Checks: fresh, Timestamp: now.Format(time.RFC3339)}
+	if atomic.LoadInt32(&h.draining) == 1 {
+		report.Status = HealthNotReady
+		report.Checks = append(report.Checks, HealthCheckResult{
+			Name: "shutdown", Status: "down", Critical: true, Error: "shutting down", CheckedAt: now,
+		})
+	}
+	for _, r := range fresh {
+		h.results[r.Name] = r
+		if r.Status == "up" {
+			continue
+		}
+		if r.Critical {
+			report.Status = HealthNotReady
+		} else if report.Status == HealthReady {
+			report.Status = HealthDegraded
+		}
+	}
+	return report
+}
+
+func (h *healthChecker) run(ctx context.Context, check HealthCheck) HealthCheckResult {
+	ctx, cancel := context.WithTimeout(ctx, h.cfg.Timeout)
+	defer cancel()
+
+	start := h.now()
+	err := check.Check(ctx)
+	r := HealthCheckResult{
+		Name:      check.Name,
+		Status:    "up",
+		Critical:  check.Critical,
+		LatencyMs: float64(h.now().Sub(start).Microseconds()) / 1000,
+		CheckedAt: start,
+	}
+	if err != nil {
+		r.Status = "down"
+		r.Error = err.Error()
+	}
+	return r
+}
+
+// registerHealthRoutes mounts /health/live and /health/ready.
+func registerHealthRoutes(e *echo.Echo, checker *healthChecker) {
+	e.GET("/health/live", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, map[string]string{
+			"service":   "auth-api",
+			"status":    "up",
+			"timestamp": time.Now().Format(time.RFC3339),
+		})
+	})
+	e.GET("/health/ready", func(c echo.Context) error {
+		report := checker.Report(c.Request().Context())
+		code := http.StatusOK
+		if report.Status == HealthNotReady {
+			code = http.StatusServiceUnavailable
+		}
+		return c.JSON(code, report)
+	})
+}
+
+// httpHealthCheck expects a 2xx answer to GET url. It uses its own client so
+// probes never count against the circuit breakers or trigger retries.
+func httpHealthCheck(client *http.Client, url string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return fmt.Errorf("unexpected status %d", resp.StatusCode)
+		}
+		return nil
+	}
+}
+
+// signingKeyHealthCheck fails when no JWT signing key is loaded.
+func signingKeyHealthCheck(key func() string) func(ctx context.Context) error {
+	return func(context.Context) error {
+		if key() == "" {
+			return errors.New("no JWT signing key loaded")
+		}
+		return nil
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+func TestHealth_ReadinessByCriticality(t *testing.T) {
+	redisErr := errors.New("connection refused")
+	checker := newHealthChecker(HealthConfig{Timeout: time.Second},
+		HealthCheck{Name: "signing-key", Critical: true, Check: func(context.Context) error { return nil }},
+		HealthCheck{Name: "redis", Check: func(context.Context) error { return redisErr }},
+	)
+	e := echo.New()
+	registerHealthRoutes(e, checker)
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health/ready", nil))
+	var report HealthReport
+	json.Unmarshal(rec.Body.Bytes(), &report)
+	if rec.Code != http.StatusOK || report.Status != HealthDegraded || len(report.Checks) != 2 {
+		t.Fatalf("expected a degraded but ready service, got %d %+v", rec.Code, report)
+	}
+	if r := report.Checks[1]; r.Name != "redis" || r.Status != "down" || r.Critical || r.Error != "connection refused" {
+		t.Fatalf("unexpected redis result: %+v", r)
+	}
+
+	checker.Add(HealthCheck{Name: "users-api", Critical: true, Check: func(context.Context) error { return errors.New("timeout") }})
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health/ready", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 when a critical check fails, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health/live", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected liveness regardless of dependencies, got %d", rec.Code)
+	}
+}
+
+func TestHealth_CachesResults(t *testing.T) {
+	var probes int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&probes, 1)
+		if r.URL.Path != "/health" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer upstream.Close()
+
+	checker := newHealthChecker(HealthConfig{CacheTTL: time.Minute, Timeout: time.Second},
+		HealthCheck{Name: "users-api", Critical: true, Check: httpHealthCheck(upstream.Client(), upstream.URL+"/health")},
+	)
+	now := time.Now()
+	checker.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if r := checker.Report(context.Background()); r.Status != HealthReady {
+			t.Fatalf("expected ready, got %+v", r)
+		}
+	}
+	if n := atomic.LoadInt32(&probes); n != 1 {
+		t.Fatalf("expected one probe within the cache TTL, got %d", n)
+	}
+
+	now = now.Add(time.Minute)
+	checker.Report(context.Background())
+	if n := atomic.LoadInt32(&probes); n != 2 {
+		t.Fatalf("expected a new probe once the result expired, got %d", n)
+	}
+}
+
+func TestHealth_CheckTimeout(t *testing.T) {
+	checker := newHealthChecker(HealthConfig{Timeout: 10 * time.Millisecond},
+		HealthCheck{Name: "slow", Critical: true, Check: func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}},
+	)
+	if r := checker.Report(context.Background()); r.Status != HealthNotReady || r.Checks[0].Error != context.DeadlineExceeded.Error() {
+		t.Fatalf("expected the slow check to time out, got %+v", r)
+	}
+}
+
+func TestHealth_CallerCancellationIsNotCached(t *testing.T) {
+	checker := newHealthChecker(HealthConfig{CacheTTL: time.Minute, Timeout: time.Second},
+		HealthCheck{Name: "users-api", Critical: true, Check: func(ctx context.Context) error {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(20 * time.Millisecond):
+				return nil
+			}
+		}},
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	checker.Report(ctx)
+	if r := checker.Report(context.Background()); r.Status != HealthReady {
+		t.Fatalf("expected a probe that went away not to leave a cached failure, got %+v", r)
+	}
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HedgeConfig controls when a second, hedged attempt is sent.
+type HedgeConfig struct {
+	// Delay before the hedged attempt is fired. With Adaptive it is only used
+	// until MinSamples latencies have been observed.
+	Delay time.Duration
+	// Adaptive uses the observed p95 latency as the hedge delay.
+	Adaptive bool
+	// MinDelay is the lower bound for the adaptive delay.
+	MinDelay time.Duration
+	// MinSamples needed before the adaptive delay is trusted.
+	MinSamples int
+	// BudgetRatio is the fraction of requests that may be hedged (0.1 = 10%).
+	BudgetRatio float64
+}
+
+// hedgingHTTPClient sends a second attempt for slow idempotent requests and
+// returns whichever attempt answers successfully first.
+type hedgingHTTPClient struct {
+	base    HTTPDoer
+	cfg     HedgeConfig
+	budget  *hedgeBudget
+	latency *latencyWindow
+
+	reqs   uint64
+	hedged uint64
+	wins   uint64
+	denied uint64
+}
+
+func newHedgingHTTPClient(base HTTPDoer, cfg HedgeConfig) *hedgingHTTPClient {
+	if cfg.Delay <= 0 {
+		cfg.Delay = 100 * time.Millisecond
+	}
+	if cfg.MinDelay <= 0 {
+		cfg.MinDelay = 10 * time.Millisecond
+	}
+	if cfg.MinSamples <= 0 {
+		cfg.MinSamples = 20
+	}
+	if cfg.BudgetRatio <= 0 {
+		cfg.BudgetRatio = 0.1
+	}
+	return &hedgingHTTPClient{
+		base:    base,
+		cfg:     cfg,
+		budget:  newHedgeBudget(cfg.BudgetRatio, 10),
+		latency: newLatencyWindow(200),
+	}
+}
+
+type hedgeResult struct {
+	attempt int
+	resp    *http.Response
+	err     error
+	latency time.Duration
+	cancel  context.CancelFunc
+}
+
+func (r hedgeResult) ok() bool {
+	return r.err == nil && r.resp != nil && r.resp.StatusCode < 500
+}
+
+// discard releases everything held by a result that will not be returned.
+func (r hedgeResult) discard() {
+	if r.resp != nil && r.resp.Body != nil {
+		io.Copy(io.Discard, r.resp.Body)
+		r.resp.Body.Close()
+	}
+	r.cancel()
+}
+
+// deliver hands the result to the caller, cancelling its context once the body is closed.
+func (r hedgeResult) deliver() (*http.Response, error) {
+	if r.resp == nil || r.resp.Body == nil {
+		r.cancel()
+		return r.resp, r.err
+	}
+	r.resp.Body = &cancelOnClose{ReadCloser: r.resp.Body, cancel: r.cancel}
+	return r.resp, r.err
+}
+
+func (c *hedgingHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	if !isIdempotent(req.Method) || (req.Body != nil && req.Body != http.NoBody && req.GetBody == nil) {
+		return c.base.Do(req)
+	}
+	atomic.AddUint64(&c.reqs, 1)
+	c.budget.deposit()
+
+	ctx := req.Context()
+	results := make(chan hedgeResult, 2)
+	launch := func(attempt int) error {
+		actx, cancel := context.WithCancel(ctx)
+		r := req.Clone(actx)
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				cancel()
+				return err
+			}
+			r.Body = body
+		}
+		go func() {
+			start := time.Now()
+			resp, err := c.base.Do(r)
+			results <- hedgeResult{attempt: attempt, resp: resp, err: err, latency: time.Since(start), cancel: cancel}
+		}()
+		return nil
+	}
+
+	start := time.Now()
+	launch(0)
+	inflight, hedged := 1, false
+	timer := time.NewTimer(c.delay())
+	defer timer.Stop()
+
+	var failed *hedgeResult
+	for {
+		select {
+		case r := <-results:
+			inflight--
+			if r.ok() {
+				if r.attempt == 0 {
+					c.latency.observe(r.latency)
+				} else {
+					// the primary is cancelled below; record how long it has
+					// run so far so slow primaries still raise the p95
+					c.latency.observe(time.Since(start))
+					atomic.AddUint64(&c.wins, 1)
+				}
+				if failed != nil {
+					failed.discard()
+				}
+				c.drain(results, inflight)
+				return r.deliver()
+			}
+			if inflight > 0 {
+				// the other attempt may still succeed
+				if failed != nil {
+					failed.discard()
+				}
+				failed = &r
+				continue
+			}
+			if failed != nil {
+				r.discard()
+				return failed.deliver()
+			}
+			return r.deliver()
+
+		case <-timer.C:
+			if hedged || inflight == 0 {
+				continue
+			}
+			if !c.budget.withdraw() {
+				atomic.AddUint64(&c.denied, 1)
+				continue
+			}
+			if err := launch(1); err == nil {
+				hedged = true
+				inflight++
+				atomic.AddUint64(&c.hedged, 1)
+			}
+		}
+	}
+}
+
+// drain cancels and cleans up attempts still in flight once a winner is chosen.
+func (c *hedgingHTTPClient) drain(results chan hedgeResult, inflight int) {
+	if inflight == 0 {
+		return
+	}
+	go func() {
+		for i := 0; i < inflight; i++ {
+			r := <-results
+			r.discard()
+		}
+	}()
+}
+
+// delay returns the wait before hedging, the observed p95 when adaptive.
+func (c *hedgingHTTPClient) delay() time.Duration {
+	if !c.cfg.Adaptive {
+		return c.cfg.Delay
+	}
+	p95, n := c.latency.percentile(0.95)
+	if n < c.cfg.MinSamples {
+		return c.cfg.Delay
+	}
+	if p95 < c.cfg.MinDelay {
+		return c.cfg.MinDelay
+	}
+	return p95
+}
+
+// Stats returns counters describing hedging activity.
+func (c *hedgingHTTPClient) Stats() map[string]uint64 {
+	return map[string]uint64{
+		"Requests":     atomic.LoadUint64(&c.reqs),
+		"Hedged":       atomic.LoadUint64(&c.hedged),
+		"HedgeWins":    atomic.LoadUint64(&c.wins),
+		"BudgetDenied": atomic.LoadUint64(&c.denied),
+	}
+}
+
+var _ HTTPDoer = (*hedgingHTTPClient)(nil)
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+// hedgeBudget is a token bucket refilled by a fraction of a token per request,
+// so hedges can never exceed BudgetRatio of the traffic. It starts with
+// ratio*max tokens, not full, so a burst right after startup cannot hedge
+// more than its share.
+type hedgeBudget struct {
+	mu     sync.Mutex
+	ratio  float64
+	max    float64
+	tokens float64
+}
+
+func newHedgeBudget(ratio, max float64) *hedgeBudget {
+	return &hedgeBudget{ratio: ratio, max: max, tokens: ratio * max}
+}
+
+func (b *hedgeBudget) deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens += b.ratio
+	if b.tokens > b.max {
+		b.tokens = b.max
+	}
+}
+
+func (b *hedgeBudget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// latencyWindow keeps the most recent latencies in a ring buffer.
+type latencyWindow struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+	full    bool
+}
+
+func newLatencyWindow(size int) *latencyWindow {
+	return &latencyWindow{samples: make([]time.Duration, size)}
+}
+
+func (w *latencyWindow) observe(d time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.samples[w.next] = d
+	w.next = (w.next + 1) % len(w.samples)
+	if w.next == 0 {
+		w.full = true
+	}
+}
+
+// percentile returns the q-th percentile and the number of samples it was computed from.
+func (w *latencyWindow) percentile(q float64) (time.Duration, int) {
+	w.mu.Lock()
+	n := w.next
+	if w.full {
+		n = len(w.samples)
+	}
+	sorted := make([]time.Duration, n)
+	copy(sorted, w.samples[:n])
+	w.mu.Unlock()
+
+	if n == 0 {
+		return 0, 0
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[int(q*float64(n-1))], n
+}
+
+// hedgeConfigFromEnv reads HEDGE_DELAY_MS, HEDGE_ADAPTIVE and HEDGE_BUDGET_RATIO.
+// Hedging is enabled only when HEDGE_DELAY_MS is set.
+func hedgeConfigFromEnv(env configLookup) (HedgeConfig, bool, error) {
+	var cfg HedgeConfig
+	v := env.get("HEDGE_DELAY_MS")
+	if v == "" {
+		return cfg, false, nil
+	}
+	ms, err := strconv.Atoi(v)
+	if err != nil || ms <= 0 {
+		return cfg, false, fmt.Errorf("HEDGE_DELAY_MS: invalid value %q", v)
+	}
+	cfg.Delay = time.Duration(ms) * time.Millisecond
+
+	if v := env.get("HEDGE_ADAPTIVE"); v != "" {
+		if cfg.Adaptive, err = strconv.ParseBool(v); err != nil {
+			return cfg, false, fmt.Errorf("HEDGE_ADAPTIVE: %w", err)
+		}
+	}
+	if v := env.get("HEDGE_BUDGET_RATIO"); v != "" {
+		if cfg.BudgetRatio, err = strconv.ParseFloat(v, 64); err != nil || cfg.BudgetRatio <= 0 || cfg.BudgetRatio > 1 {
+			return cfg, false, fmt.Errorf("HEDGE_BUDGET_RATIO: invalid value %q", v)
+		}
+	}
+	return cfg, true, nil
+}
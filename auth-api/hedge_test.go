@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// slowClient answers each call after the delay configured for that call number.
+type slowClient struct {
+	calls  int32
+	delays []time.Duration
+}
+
+func (s *slowClient) Do(req *http.Request) (*http.Response, error) {
+	n := int(atomic.AddInt32(&s.calls, 1)) - 1
+	var d time.Duration
+	if n < len(s.delays) {
+		d = s.delays[n]
+	}
+	select {
+	case <-time.After(d):
+		return &http.Response{StatusCode: 200 + n, Body: http.NoBody}, nil
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+}
+
+func TestHedge_SecondAttemptWins(t *testing.T) {
+	sc := &slowClient{delays: []time.Duration{time.Second, 0}}
+	hc := newHedgingHTTPClient(sc, HedgeConfig{Delay: 10 * time.Millisecond})
+
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com/users/admin", nil)
+	start := time.Now()
+	resp, err := hc.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 201 {
+		t.Fatalf("expected hedged response (201), got %d", resp.StatusCode)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("hedge did not cut latency, took %s", elapsed)
+	}
+	if s := hc.Stats(); s["Hedged"] != 1 || s["HedgeWins"] != 1 {
+		t.Fatalf("unexpected stats: %v", s)
+	}
+}
+
+func TestHedge_BudgetExhausted(t *testing.T) {
+	sc := &slowClient{delays: []time.Duration{50 * time.Millisecond, 0}}
+	hc := newHedgingHTTPClient(sc, HedgeConfig{Delay: 5 * time.Millisecond})
+	hc.budget = &hedgeBudget{ratio: 0.1, max: 10}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com/users/admin", nil)
+	resp, err := hc.Do(req)
+	if err != nil || resp.StatusCode != 200 {
+		t.Fatalf("expected first attempt response, got resp=%v err=%v", resp, err)
+	}
+	if calls := atomic.LoadInt32(&sc.calls); calls != 1 {
+		t.Fatalf("expected 1 call without budget, got %d", calls)
+	}
+	if s := hc.Stats(); s["BudgetDenied"] != 1 {
+		t.Fatalf("expected denied hedge, got %v", s)
+	}
+}
+
+func TestHedge_NonIdempotentNotHedged(t *testing.T) {
+	sc := &slowClient{delays: []time.Duration{50 * time.Millisecond, 0}}
+	hc := newHedgingHTTPClient(sc, HedgeConfig{Delay: 5 * time.Millisecond})
+
+	req, _ := http.NewRequest(http.MethodPost, "http://example.com/login", nil)
+	if _, err := hc.Do(req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls := atomic.LoadInt32(&sc.calls); calls != 1 {
+		t.Fatalf("expected POST not to be hedged, got %d calls", calls)
+	}
+}
+
+func TestHedge_LosingPrimaryLatencyObserved(t *testing.T) {
+	sc := &slowClient{delays: []time.Duration{time.Second, 0}}
+	hc := newHedgingHTTPClient(sc, HedgeConfig{Delay: 20 * time.Millisecond})
+
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com/users/admin", nil)
+	resp, err := hc.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	p95, n := hc.latency.percentile(0.95)
+	if n != 1 || p95 < 20*time.Millisecond {
+		t.Fatalf("expected the cancelled primary observed at >= hedge delay, got p95=%s from %d samples", p95, n)
+	}
+}
+
+func TestHedgeBudget_StartsWithItsShare(t *testing.T) {
+	b := newHedgeBudget(0.1, 10)
+	if !b.withdraw() {
+		t.Fatal("expected one hedge available at startup")
+	}
+	if b.withdraw() {
+		t.Fatal("expected the startup budget to hold a single hedge")
+	}
+	for i := 0; i < 11; i++ {
+		b.deposit()
+	}
+	if !b.withdraw() {
+		t.Fatal("expected about ten requests to earn a hedge")
+	}
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo"
+	gommonlog "github.com/labstack/gommon/log"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Log formats selectable with LOG_FORMAT.
+const (
+	LogFormatJSON   = "json"
+	LogFormatLogfmt = "logfmt"
+)
+
+// Components of auth-api, used as the component attribute of every log line
+// and as the keys of LOG_LEVELS.
+const (
+	LogMain    = "main"
+	LogHTTP    = "http"
+	LogAuth    = "auth"
+	LogBreaker = "breaker"
+	LogRetry   = "retry"
+	LogTracing = "tracing"
+	LogAudit   = "audit"
+	LogEcho    = "echo"
+)
+
+// LogConfig selects the log format and the level of each component.
+type LogConfig struct {
+	Format string
+	Level  slog.Level
+	// Components override Level for some components.
+	Components map[string]slog.Level
+}
+
+// logConfigFromEnv reads LOG_FORMAT, LOG_LEVEL and LOG_LEVELS, a comma
+// separated list of component=level pairs such as "breaker=debug,retry=warn".
+func logConfigFromEnv(env configLookup) (LogConfig, error) {
+	c := LogConfig{Format: LogFormatJSON, Level: slog.LevelInfo, Components: map[string]slog.Level{}}
+	if v := env.get("LOG_FORMAT"); v != "" {
+		c.Format = strings.ToLower(v)
+	}
+	switch c.Format {
+	case LogFormatJSON, LogFormatLogfmt:
+	default:
+		return c, fmt.Errorf("unknown LOG_FORMAT %q", c.Format)
+	}
+	if v := env.get("LOG_LEVEL"); v != "" {
+		l, err := parseLogLevel(v)
+		if err != nil {
+			return c, fmt.Errorf("LOG_LEVEL: %w", err)
+		}
+		c.Level = l
+	}
+	for _, pair := range strings.Split(env.get("LOG_LEVELS"), ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		component, level, ok := strings.Cut(pair, "=")
+		if !ok {
+			return c, fmt.Errorf("LOG_LEVELS: expected component=level, got %q", pair)
+		}
+		l, err := parseLogLevel(level)
+		if err != nil {
+			return c, fmt.Errorf("LOG_LEVELS: %w", err)
+		}
+		c.Components[strings.TrimSpace(component)] = l
+	}
+	return c, nil
+}
+
+func parseLogLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	if strings.EqualFold(strings.TrimSpace(s), "warning") {
+		return slog.LevelWarn, nil
+	}
+	if err := l.UnmarshalText([]byte(strings.TrimSpace(s))); err != nil {
+		return l, fmt.Errorf("unknown log level %q", s)
+	}
+	return l, nil
+}
+
+// logLevels holds the levels of every component and can change them while
+// the service runs.
+type logLevels struct {
+	mu         sync.RWMutex
+	level      slog.Level
+	components map[string]slog.Level
+}
+
+func newLogLevels(cfg LogConfig) *logLevels {
+	l := &logLevels{level: cfg.Level, components: map[string]slog.Level{}}
+	for c, lvl := range cfg.Components {
+		l.components[c] = lvl
+	}
+	return l
+}
+
+// Level returns the level of component, or the default level.
+func (l *logLevels) Level(component string) slog.Level {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if lvl, ok := l.components[component]; ok {
+		return lvl
+	}
+	return l.level
+}
+
+// Set changes the level of component, or the default level when component is
+// empty.
+func (l *logLevels) Set(component string, level slog.Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if component == "" {
+		l.level = level
+		return
+	}
+	l.components[component] = level
+}
+
+// Reset makes component follow the default level again.
+func (l *logLevels) Reset(component string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.components, component)
+}
+
+// Replace sets the default and component levels from cfg, dropping the
+// changes made since.
+func (l *logLevels) Replace(cfg LogConfig) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.level = cfg.Level
+	l.components = make(map[string]slog.Level, len(cfg.Components))
+	for c, lvl := range cfg.Components {
+		l.components[c] = lvl
+	}
+}
+
+// LogLevelsSnapshot is the JSON view of the log levels.
+type LogLevelsSnapshot struct {
+	Level      string            `json:"level"`
+	Components map[string]string `json:"components"`
+}
+
+func (l *logLevels) Snapshot() LogLevelsSnapshot {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	s := LogLevelsSnapshot{Level: l.level.String(), Components: map[string]string{}}
+	for c, lvl := range l.components {
+		s.Components[c] = lvl.String()
+	}
+	return s
+}
+
+// newLogger builds the logger of the service writing cfg.Format lines to w.
+// Use logger.With("component", ...) to get the logger of a component.
+func newLogger(cfg LogConfig, w io.Writer) (*slog.Logger, *logLevels) {
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+	var h slog.Handler
+	if cfg.Format == LogFormatLogfmt {
+		h = slog.NewTextHandler(w, opts)
+	} else {
+		h = slog.NewJSONHandler(w, opts)
+	}
+	levels := newLogLevels(cfg)
+	return slog.New(&contextHandler{next: h, levels: levels, component: LogMain}), levels
+}
+
+// contextHandler filters records by the level of their component and adds
+// the request and trace fields found in the context.
+type contextHandler struct {
+	next      slog.Handler
+	levels    *logLevels
+	component string
+}
+
+func (h *contextHandler) Enabled(_ context.Context, l slog.Level) bool {
+	return l >= h.levels.Level(h.component)
+}
+
+func (h *contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if ctx != nil {
+		if id := requestIDFromContext(ctx); id != "" {
+			r.AddAttrs(slog.String("request_id", id))
+		}
+		if f := logFieldsFromContext(ctx); f != nil {
+			f.mu.Lock()
+			if f.route != "" {
+				r.AddAttrs(slog.String("route", f.route))
+			}
+			if f.username != "" {
+				r.AddAttrs(slog.String("username", f.username))
+			}
+			f.mu.Unlock()
+		}
+		if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+			r.AddAttrs(slog.String("trace_id", sc.TraceID().String()), slog.String("span_id", sc.SpanID().String()))
+		}
+	}
+	return h.next.Handle(ctx, r)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	for _, a := range attrs {
+		if a.Key == "component" {
+			c.component = a.Value.String()
+		}
+	}
+	c.next = h.next.WithAttrs(attrs)
+	return &c
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	c := *h
+	c.next = h.next.WithGroup(name)
+	return &c
+}
+
+// logFields are the request fields added to every line logged with its context.
+type logFields struct {
+	mu       sync.Mutex
+	route    string
+	username string
+}
+
+type logFieldsKey struct{}
+
+func withLogFields(ctx context.Context, f *logFields) context.Context {
+	return context.WithValue(ctx, logFieldsKey{}, f)
+}
+
+func logFieldsFromContext(ctx context.Context) *logFields {
+	f, _ := ctx.Value(logFieldsKey{}).(*logFields)
+	return f
+}
+
+// setLogUsername adds the username to the lines logged for the request of ctx.
+func setLogUsername(ctx context.Context, username string) {
+	if f := logFieldsFromContext(ctx); f != nil {
+		f.mu.Lock()
+		f.username = username
+		f.mu.Unlock()
+	}
+}
+
+// requestLogger adds the route and username fields to the request context and writes one
+// access log line per request, replacing echo's middleware.Logger.
+func requestLogger(logger *slog.Logger) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			ctx := withLogFields(req.Context(), &logFields{route: c.Path()})
+			c.SetRequest(req.WithContext(ctx))
+
+			start := time.Now()
+			err := next(c)
+			if err != nil {
+				// let the error handler write the response so its status is logged
+				c.Error(err)
+			}
+
+			res := c.Response()
+			level := slog.LevelInfo
+			if res.Status >= http.StatusInternalServerError {
+				level = slog.LevelWarn
+			}
+			logger.Log(ctx, level, "request",
+				"method", req.Method,
+				"uri", req.RequestURI,
+				"status", res.Status,
+				"latency_ms", float64(time.Since(start).Microseconds())/1000,
+				"bytes_out", res.Size,
+				"remote_ip", c.RealIP(),
+				"user_agent", req.UserAgent(),
+			)
+			return nil
+		}
+	}
+}
+
+// echoLogger lets echo log through the structured logger.
+type echoLogger struct {
+	logger *slog.Logger
+	levels *logLevels
+	out    io.Writer
+	prefix string
+}
+
+func newEchoLogger(logger *slog.Logger, levels *logLevels, out io.Writer) *echoLogger {
+	return &echoLogger{logger: logger.With("component", LogEcho), levels: levels, out: out}
+}
+
+func (l *echoLogger) Output() io.Writer     { return l.out }
+func (l *echoLogger) SetOutput(w io.Writer) { l.out = w }
+func (l *echoLogger) Prefix() string        { return l.prefix }
+func (l *echoLogger) SetPrefix(p string)    { l.prefix = p }
+func (l *echoLogger) SetHeader(string)      {}
+
+func (l *echoLogger) Level() gommonlog.Lvl {
+	switch lvl := l.levels.Level(LogEcho); {
+	case lvl < slog.LevelInfo:
+		return gommonlog.DEBUG
+	case lvl < slog.LevelWarn:
+		return gommonlog.INFO
+	case lvl < slog.LevelError:
+		return gommonlog.WARN
+	}
+	return gommonlog.ERROR
+}
+
+func (l *echoLogger) SetLevel(v gommonlog.Lvl) {
+	switch v {
+	case gommonlog.DEBUG:
+		l.levels.Set(LogEcho, slog.LevelDebug)
+	case gommonlog.INFO:
+		l.levels.Set(LogEcho, slog.LevelInfo)
+	case gommonlog.WARN:
+		l.levels.Set(LogEcho, slog.LevelWarn)
+	default:
+		l.levels.Set(LogEcho, slog.LevelError)
+	}
+}
+
+func (l *echoLogger) log(level slog.Level, msg string) {
+	l.logger.Log(context.Background(), level, msg)
+}
+
+func (l *echoLogger) logj(level slog.Level, j gommonlog.JSON) {
+	keys := make([]string, 0, len(j))
+	for k := range j {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	args := make([]any, 0, 2*len(j))
+	for _, k := range keys {
+		args = append(args, k, j[k])
+	}
+	l.logger.Log(context.Background(), level, "", args...)
+}
+
+func (l *echoLogger) Print(i ...interface{})            { l.log(slog.LevelInfo, fmt.Sprint(i...)) }
+func (l *echoLogger) Printf(f string, a ...interface{}) { l.log(slog.LevelInfo, fmt.Sprintf(f, a...)) }
+func (l *echoLogger) Printj(j gommonlog.JSON)           { l.logj(slog.LevelInfo, j) }
+func (l *echoLogger) Debug(i ...interface{})            { l.log(slog.LevelDebug, fmt.Sprint(i...)) }
+func (l *echoLogger) Debugf(f string, a ...interface{}) { l.log(slog.LevelDebug, fmt.Sprintf(f, a...)) }
+func (l *echoLogger) Debugj(j gommonlog.JSON)           { l.logj(slog.LevelDebug, j) }
+func (l *echoLogger) Info(i ...interface{})             { l.log(slog.LevelInfo, fmt.Sprint(i...)) }
+func (l *echoLogger) Infof(f string, a ...interface{})  { l.log(slog.LevelInfo, fmt.Sprintf(f, a...)) }
+func (l *echoLogger) Infoj(j gommonlog.JSON)            { l.logj(slog.LevelInfo, j) }
+func (l *echoLogger) Warn(i ...interface{})             { l.log(slog.LevelWarn, fmt.Sprint(i...)) }
+func (l *echoLogger) Warnf(f string, a ...interface{})  { l.log(slog.LevelWarn, fmt.Sprintf(f, a...)) }
+func (l *echoLogger) Warnj(j gommonlog.JSON)            { l.logj(slog.LevelWarn, j) }
+func (l *echoLogger) Error(i ...interface{})            { l.log(slog.LevelError, fmt.Sprint(i...)) }
+func (l *echoLogger) Errorf(f string, a ...interface{}) { l.log(slog.LevelError, fmt.Sprintf(f, a...)) }
+func (l *echoLogger) Errorj(j gommonlog.JSON)           { l.logj(slog.LevelError, j) }
+
+func (l *echoLogger) Fatal(i ...interface{}) {
+	l.log(slog.LevelError, fmt.Sprint(i...))
+	os.Exit(1)
+}
+
+func (l *echoLogger) Fatalf(f string, a ...interface{}) {
+	l.log(slog.LevelError, fmt.Sprintf(f, a...))
+	os.Exit(1)
+}
+
+func (l *echoLogger) Fatalj(j gommonlog.JSON) {
+	l.logj(slog.LevelError, j)
+	os.Exit(1)
+}
+
+func (l *echoLogger) Panic(i ...interface{}) {
+	msg := fmt.Sprint(i...)
+	l.log(slog.LevelError, msg)
+	panic(msg)
+}
+
+func (l *echoLogger) Panicf(f string, a ...interface{}) {
+	msg := fmt.Sprintf(f, a...)
+	l.log(slog.LevelError, msg)
+	panic(msg)
+}
+
+func (l *echoLogger) Panicj(j gommonlog.JSON) {
+	l.logj(slog.LevelError, j)
+	panic(j)
+}
+
+var _ echo.Logger = (*echoLogger)(nil)
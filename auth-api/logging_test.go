@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo"
+)
+
+func logLines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var lines []map[string]any
+	for _, l := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if l == "" {
+			continue
+		}
+		var m map[string]any
+		if err := json.Unmarshal([]byte(l), &m); err != nil {
+			t.Fatalf("log line is not JSON: %q", l)
+		}
+		lines = append(lines, m)
+	}
+	return lines
+}
+
+func TestLogging_RequestLinesCarryRequestTraceAndUser(t *testing.T) {
+	var buf bytes.Buffer
+	logger, _ := newLogger(LogConfig{Format: LogFormatJSON}, &buf)
+	tr, _ := newTestTracing()
+
+	e := echo.New()
+	e.Pre(requestIDMiddleware)
+	e.Use(echo.WrapMiddleware(tr.Middleware))
+	e.Use(requestLogger(logger.With("component", LogHTTP)))
+	e.POST("/login", func(c echo.Context) error {
+		ctx := c.Request().Context()
+		setLogUsername(ctx, "johnd")
+		logger.With("component", LogAuth).WarnContext(ctx, "could not authorize user")
+		return ErrWrongCredentials
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/login", nil)
+	req.Header.Set(RequestIDHeader, "req-1")
+	e.ServeHTTP(httptest.NewRecorder(), req)
+
+	lines := logLines(t, &buf)
+	if len(lines) != 2 {
+		t.Fatalf("expected handler and access lines, got %v", lines)
+	}
+	for _, l := range lines {
+		if l["request_id"] != "req-1" || l["username"] != "johnd" || l["route"] != "/login" {
+			t.Fatalf("missing request fields: %v", l)
+		}
+		if id, _ := l["trace_id"].(string); len(id) != 32 {
+			t.Fatalf("missing trace id: %v", l)
+		}
+		if l["span_id"] == nil {
+			t.Fatalf("missing span id: %v", l)
+		}
+	}
+	if lines[0]["component"] != LogAuth || lines[1]["component"] != LogHTTP || lines[1]["status"] != float64(http.StatusUnauthorized) {
+		t.Fatalf("unexpected lines: %v", lines)
+	}
+}
+
+func TestLogging_ComponentLevels(t *testing.T) {
+	var buf bytes.Buffer
+	logger, levels := newLogger(LogConfig{Format: LogFormatLogfmt, Components: map[string]slog.Level{LogBreaker: slog.LevelDebug}}, &buf)
+
+	logger.With("component", LogBreaker).Debug("probe")
+	logger.With("component", LogRetry).Debug("hidden")
+	levels.Set(LogRetry, slog.LevelDebug)
+	logger.With("component", LogRetry).Debug("shown")
+
+	out := buf.String()
+	if !strings.Contains(out, "msg=probe") || strings.Contains(out, "hidden") || !strings.Contains(out, "component=retry") {
+		t.Fatalf("unexpected output: %s", out)
+	}
+}
+
+func TestAdmin_ChangesLogLevels(t *testing.T) {
+	_, levels := newLogger(LogConfig{}, &bytes.Buffer{})
+	audit := newAuditLog(nil, nil)
+	e := echo.New()
+	admin := e.Group("/admin", jwtSecret.Middleware, requireAdmin)
+	registerLogAdminRoutes(admin, levels, audit)
+	token := signedToken(t, "admin", "admin")
+
+	put := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/admin/log-levels", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := put(`{"component":"breaker","level":"debug"}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if levels.Level(LogBreaker) != slog.LevelDebug {
+		t.Fatal("expected breaker debug logs to be enabled")
+	}
+	if rec := put(`{"level":"loud"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unknown level, got %d", rec.Code)
+	}
+	if rec := put(`{"component":"breaker"}`); rec.Code != http.StatusOK || levels.Snapshot().Components[LogBreaker] != "" {
+		t.Fatalf("expected the breaker level to be reset, got %d %+v", rec.Code, levels.Snapshot())
+	}
+	if entries := audit.Recent(); len(entries) != 2 || entries[0].Action != "log.level" || entries[0].Target != LogBreaker {
+		t.Fatalf("unexpected audit entries: %+v", entries)
+	}
+}
+
+func TestLogConfigFromEnv(t *testing.T) {
+	t.Setenv("LOG_FORMAT", "logfmt")
+	t.Setenv("LOG_LEVELS", "breaker=debug, retry=warning")
+
+	c, err := logConfigFromEnv(os.LookupEnv)
+	if err != nil || c.Format != LogFormatLogfmt || c.Components[LogBreaker] != slog.LevelDebug || c.Components[LogRetry] != slog.LevelWarn {
+		t.Fatalf("unexpected config %+v err=%v", c, err)
+	}
+
+	t.Setenv("LOG_LEVELS", "breaker")
+	if _, err := logConfigFromEnv(os.LookupEnv); err == nil {
+		t.Fatal("expected an error for a pair without level")
+	}
+}
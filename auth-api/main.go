@@ -1,16 +1,25 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
-	"log"
+	"errors"
+	"flag"
+	"io"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/labstack/echo"
 	"github.com/labstack/echo/middleware"
-	gommonlog "github.com/labstack/gommon/log"
+	"github.com/sony/gobreaker/v2"
+	"go.opentelemetry.io/otel"
 )
 
 var (
@@ -20,78 +29,248 @@ var (
 	// ErrWrongCredentials indicates that login attempt failed because of incorrect login or password
 	ErrWrongCredentials = echo.NewHTTPError(http.StatusUnauthorized, "username or password is invalid")
 
-	jwtSecret = "myfancysecret"
+	// ErrDependencyUnavailable indicates that users could not be looked up because a dependency is down
+	ErrDependencyUnavailable = echo.NewHTTPError(http.StatusServiceUnavailable, "user service is temporarily unavailable, please try again later")
+
+	jwtSecret = newSigningKey(defaultJWTSecret)
 )
 
 func main() {
-	hostport := ":" + os.Getenv("AUTH_API_PORT")
-	userAPIAddress := os.Getenv("USERS_API_ADDRESS")
+	os.Exit(run())
+}
 
-	envJwtSecret := os.Getenv("JWT_SECRET")
-	if len(envJwtSecret) != 0 {
-		jwtSecret = envJwtSecret
+// run serves until SIGINT or SIGTERM, shuts down gracefully and returns the
+// exit code.
+func run() int {
+	// Defaults, then the config file, the environment and the flags
+	cfg, err := loadConfig(os.Args[1:], os.LookupEnv, os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return ExitOK
 	}
+	if err != nil {
+		slog.Error("refusing to start", "error", err)
+		return ExitInvalidConfig
+	}
+
+	hostport := ":" + cfg.Port
+	userAPIAddress := cfg.UsersAPIAddress
+	jwtSecret.Set(cfg.JWTSecret.Reveal())
 
+	// Bounded dial, handshake, header and request timeouts for users-api
+	usersAPI := newUsersAPIClient(cfg.UsersAPITransport)
 	userService := UserService{
-		Client:         http.DefaultClient,
+		Client:         usersAPI,
 		UserAPIAddress: userAPIAddress,
-		AllowedUserHashes: map[string]interface{}{
-			"admin_admin": nil,
-			"johnd_foo":   nil,
-			"janed_ddd":   nil,
-		},
+		AllowedUsers:   newAllowedUserStore(cfg.AllowedUsers),
+	}
+
+	// One structured logger for echo, the standard log package and every component
+	logger, logLevels := newLogger(cfg.Log, os.Stdout)
+	slog.SetDefault(logger)
+	mainLog := logger.With("component", LogMain)
+	if cfg.File != "" {
+		mainLog.Info("loaded config file", "file", cfg.File)
+	}
+	for _, w := range cfg.Warnings {
+		mainLog.Warn("insecure setting, refused when AUTH_API_MODE=production", "problem", w, "mode", cfg.Mode)
 	}
+	userService.Logger = logger.With("component", LogAuth)
 
 	e := echo.New()
-	e.Logger.SetLevel(gommonlog.INFO)
+	// Correlate every request across services, including unrouted ones
+	e.Pre(requestIDMiddleware)
+	e.HTTPErrorHandler = requestIDErrorHandler(e)
+	e.HideBanner = true
+	e.HidePort = true
+	e.Logger = newEchoLogger(logger, logLevels, os.Stdout)
+	e.StdLogger = slog.NewLogLogger(logger.With("component", LogEcho).Handler(), slog.LevelError)
 
-	if zipkinURL := os.Getenv("ZIPKIN_URL"); len(zipkinURL) != 0 {
-		e.Logger.Infof("init tracing to Zipkit at %s", zipkinURL)
+	metrics := newAuthMetrics()
+	userService.Metrics = metrics
 
-		if tracedMiddleware, tracedClient, err := initTracing(zipkinURL); err == nil {
-			e.Use(echo.WrapMiddleware(tracedMiddleware))
-			userService.Client = tracedClient
+	userService.Profiles = newProfileCache(10 * time.Minute)
+	userService.BreakGlass = newBreakGlassStore(cfg.BreakGlass)
+
+	var tracer *tracing
+	tracingLog := logger.With("component", LogTracing)
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		tracingLog.Warn("opentelemetry error", "error", err)
+	}))
+	if tracingCfg := cfg.Tracing; tracingCfg.Exporter == ExporterNone {
+		tracingLog.Info("no tracing exporter was configured, tracing is not initialised")
+	} else {
+		tracingLog.Info("init tracing", "exporter", tracingCfg.Exporter, "endpoint", redactURL(tracingCfg.Endpoint))
+
+		var err error
+		if tracer, err = initTracing(context.Background(), tracingCfg); err == nil {
+			e.Use(echo.WrapMiddleware(tracer.Middleware))
+			userService.Client = tracer.Client(usersAPI, "users-api")
+			metrics.WatchTracing(tracer)
 		} else {
-			e.Logger.Infof("Zipkin tracer init failed: %s", err.Error())
+			tracingLog.Warn("tracer init failed", "error", err)
 		}
-	} else {
-		e.Logger.Infof("Zipkin URL was not provided, tracing is not initialised")
 	}
 
-    // Wrap HTTP client with circuit breaker to protect outbound calls to Users API
-    breakerClient := newBreakerHTTPClient(userService.Client, "users-api-breaker")
-    userService.Client = breakerClient
+	// Measure every attempt to Users API, below retries and breakers
+	userService.Client = newMetricsHTTPClient(userService.Client, metrics)
 
-    // Wrap with retry client (idempotent methods) after the circuit breaker
-    userService.Client = newRetryHTTPClient(userService.Client, RetryConfig{
-        MaxRetries: 3,
-        BaseDelay:  200 * time.Millisecond,
-        MaxDelay:   2 * time.Second,
-    })
+	// Wrap HTTP client with a per-host circuit breaker registry so each backend
+	// (Users API today, identity providers or webhooks later) trips independently
+	events := newEventStream()
+	breakerLog := logger.With("component", LogBreaker)
+	breakerNotifier := newBreakerNotifier(breakerLog, events, cfg.BreakerWebhooks)
+	breakerDefaults := cfg.Breaker
+	breakerDefaults.OnStateChange = func(name string, from, to gobreaker.State) {
+		metrics.OnStateChange(name, from, to)
+		breakerNotifier.OnStateChange(name, from, to)
+	}
+	// Optionally share breaker state with the other replicas through Redis
+	if store, replica := cfg.BreakerStore.Store(); store != nil {
+		breakerLog.Info("sharing circuit breaker state through Redis", "replica", replica)
+		breakerDefaults.Store = store
+		breakerDefaults.Replica = replica
+	}
+	breakers := newBreakerRegistry(userService.Client, breakerDefaults, cfg.BreakerKeyByRoute)
+	metrics.WatchBreakers(breakers)
+	usersAPIHost := ""
+	if u, err := url.Parse(userAPIAddress); err == nil {
+		usersAPIHost = u.Host
+	}
+	usersAPISettings := breakerDefaults
+	usersAPISettings.Name = "users-api-breaker"
+	breakers.Configure(usersAPIHost, usersAPISettings)
+	breakerClient := breakers.Breaker(usersAPIHost, "/users/{username}")
+	// Serve cached profiles or break-glass accounts while the breaker is open
+	breakerClient.SetFallback(userService.BreakerFallback)
+	userService.Client = breakers
+
+	// Optionally hedge slow users-api lookups before they reach the retry layer
+	if cfg.HedgeEnabled {
+		hedger := newHedgingHTTPClient(userService.Client, cfg.Hedge)
+		userService.Client = hedger
+		e.GET("/debug/hedge", func(c echo.Context) error {
+			return c.JSON(http.StatusOK, map[string]any{
+				"service":   "auth-api",
+				"hedge":     hedger.Stats(),
+				"timestamp": time.Now().Format(time.RFC3339),
+			})
+		})
+	}
+
+	// Wrap with retry client (idempotent methods) after the circuit breaker
+	retryLog := logger.With("component", LogRetry)
+	onRetry := func(req *http.Request, ev RetryEvent) {
+		retryLog.InfoContext(req.Context(), "retrying users-api call",
+			"method", req.Method, "path", req.URL.Path, "attempt", ev.Attempt, "reason", ev.Reason, "delay", ev.Delay.String())
+		metrics.Retried(ev.Reason)
+	}
+	onAttempt := func(*http.Request, int) { metrics.Attempted() }
+	onGiveUp := func(_ *http.Request, ev RetryEvent) { metrics.GaveUp(ev.Reason) }
+	retryCfg := cfg.Retry
+	retryCfg.OnRetry, retryCfg.OnAttempt, retryCfg.OnGiveUp = onRetry, onAttempt, onGiveUp
+	retryClient := newRetryHTTPClient(userService.Client, retryCfg)
+	userService.Client = retryClient
 
 	// Expose breaker status for debugging and compatibility paths
 	breakerHandler := func(c echo.Context) error {
-		state, gbCounts := breakerClient.Status()
-		local := breakerClient.LocalCounts()
+		stats := breakerClient.Stats()
 		code := http.StatusOK
-		if state.String() == "open" { code = http.StatusServiceUnavailable }
-		return c.JSON(code, map[string]any{
-			"service":"auth-api",
-			"state": state.String(),
-			"counts": gbCounts,
-			"totals": local,                 // <- ESTO
-			"timestamp": time.Now().Format(time.RFC3339),
-		})
+		if stats.State == "open" {
+			code = http.StatusServiceUnavailable
+		}
+		return c.JSON(code, struct {
+			Service string `json:"service"`
+			BreakerStats
+			Timestamp string `json:"timestamp"`
+		}{"auth-api", stats, time.Now().Format(time.RFC3339)})
 	}
 
-
 	e.GET("/debug/breaker", breakerHandler)
 	e.GET("/status/circuit-breaker", breakerHandler)
 	e.GET("/health/circuit-breaker", breakerHandler)
 
-	e.Use(middleware.Logger())
+	e.GET("/debug/breaker/events", breakerEventsHandler(events))
+	e.GET("/debug/breakers", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, map[string]any{
+			"service":       "auth-api",
+			"schemaVersion": BreakerStatsSchemaVersion,
+			"breakers":      breakers.Status(),
+			"timestamp":     time.Now().Format(time.RFC3339),
+		})
+	})
+
+	// Operator controls, restricted to admin tokens issued by this service
+	audit := newAuditLog(logger.With("component", LogAudit), events)
+	adminAuth := []echo.MiddlewareFunc{jwtSecret.Middleware, requireAdmin}
+	admin := e.Group("/admin", adminAuth...)
+	registerBreakerAdminRoutes(admin, breakers, audit)
+	registerLogAdminRoutes(admin, logLevels, audit)
+
+	// Expose retry counters so slow logins can be attributed to retries
+	e.GET("/debug/retry", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, map[string]any{
+			"service":   "auth-api",
+			"retry":     retryClient.Stats(),
+			"timestamp": time.Now().Format(time.RFC3339),
+		})
+	})
+
+	// Apply the reloadable settings on SIGHUP or when the config file changes;
+	// in-flight requests finish with the settings they started with
+	reloader := newConfigReloader(cfg, func() (*Config, error) {
+		return loadConfig(os.Args[1:], os.LookupEnv, io.Discard)
+	}, mainLog, metrics)
+	reloader.OnReload(ReloadLog, func(c *Config) { logLevels.Replace(c.Log) })
+	reloader.OnReload(ReloadBreaker, func(c *Config) { breakers.Reconfigure(c.Breaker) })
+	reloader.OnReload(ReloadRetry, func(c *Config) {
+		retryCfg := c.Retry
+		retryCfg.OnRetry, retryCfg.OnAttempt, retryCfg.OnGiveUp = onRetry, onAttempt, onGiveUp
+		retryClient.SetConfig(retryCfg)
+	})
+	reloader.OnReload(ReloadTransport, func(c *Config) { usersAPI.Reconfigure(c.UsersAPITransport) })
+	reloader.OnReload(ReloadCredentials, func(c *Config) {
+		jwtSecret.Set(c.JWTSecret.Reveal())
+		userService.AllowedUsers.Set(c.AllowedUsers)
+		userService.BreakGlass.Set(c.BreakGlass)
+	})
+	cors := newCORSMiddleware(cfg.CORS)
+	reloader.OnReload(ReloadCORS, func(c *Config) { cors.Apply(c.CORS) })
+	// Certificates and TLS settings apply from the next handshake
+	tlsServer := newTLSServer(cfg.TLS, logger.With("component", LogMain))
+	reloader.OnReload(ReloadTLS, func(c *Config) { tlsServer.Apply(c.TLS) })
+
+	// Expose the effective configuration, without secrets, to admins
+	registerConfigRoutes(e, reloader, adminAuth...)
+
+	// Expose span reporter counters to tell a collector outage from no traffic
+	e.GET("/debug/tracing", func(c echo.Context) error {
+		if tracer == nil {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "tracing is not enabled"})
+		}
+		return c.JSON(http.StatusOK, map[string]any{
+			"service":   "auth-api",
+			"tracing":   tracer.Stats(),
+			"timestamp": time.Now().Format(time.RFC3339),
+		})
+	})
+
+	e.Use(requestLogger(logger.With("component", LogHTTP)))
 	e.Use(middleware.Recover())
-	e.Use(middleware.CORS())
+	e.Use(cors.Middleware)
+
+	// Liveness and readiness probes, with cached dependency checks
+	healthCfg := cfg.Health
+	health := newHealthChecker(healthCfg,
+		HealthCheck{Name: "users-api", Critical: true, Check: httpHealthCheck(&http.Client{Transport: usersAPI, Timeout: healthCfg.Timeout}, userAPIAddress+"/health")},
+		HealthCheck{Name: "signing-key", Critical: true, Check: signingKeyHealthCheck(jwtSecret.Get)},
+	)
+	if pinger, ok := breakerDefaults.Store.(interface{ Ping(context.Context) error }); ok {
+		health.Add(HealthCheck{Name: "redis", Check: pinger.Ping})
+	}
+	if tracer != nil {
+		health.Add(HealthCheck{Name: "tracing", Check: tracer.Check})
+	}
+	registerHealthRoutes(e, health)
 
 	// Route => handler
 	e.GET("/version", func(c echo.Context) error {
@@ -99,9 +278,76 @@ func main() {
 	})
 
 	e.POST("/login", getLoginHandler(userService))
+	e.GET("/metrics", metrics.Handler())
 
 	// Start server
-	e.Logger.Fatal(e.Start(hostport))
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	stopWatch := make(chan struct{})
+	defer close(stopWatch)
+	go reloader.Watch(hup, cfg.WatchInterval, stopWatch)
+	serverErr := make(chan error, 2)
+	var redirect *http.Server
+	if cfg.TLS.Enabled() {
+		e.TLSServer.Addr = hostport
+		e.TLSServer.TLSConfig = tlsServer.Config()
+		go func() { serverErr <- e.StartServer(e.TLSServer) }()
+		mainLog.Info("https server started", "address", hostport, "min_version", tls.VersionName(cfg.TLS.MinVersion), "client_auth", cfg.TLS.ClientAuth)
+		if cfg.TLS.RedirectPort != "" {
+			// Plain HTTP only redirects to HTTPS
+			redirect = newRedirectServer(cfg.TLS, cfg.Port, logger.With("component", LogHTTP))
+			go func() {
+				if err := redirect.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+					serverErr <- err
+				}
+			}()
+			mainLog.Info("https redirect started", "address", redirect.Addr)
+		}
+	} else {
+		go func() { serverErr <- e.Start(hostport) }()
+		mainLog.Info("http server started", "address", hostport)
+	}
+
+	select {
+	case err := <-serverErr:
+		mainLog.Error("http server failed", "error", err)
+		return ExitServerError
+	case sig := <-signals:
+		mainLog.Info("shutting down", "signal", sig.String())
+	}
+
+	// A second signal cuts the drain period short
+	abort := make(chan struct{})
+	go func() {
+		<-signals
+		close(abort)
+	}()
+	return gracefulShutdown(cfg.Shutdown, mainLog, health.SetDraining, abort,
+		// end the event streams first: the server waits for them otherwise
+		shutdownStep{"event streams", func(context.Context) error { events.Close(); return nil }},
+		shutdownStep{"http server", func(ctx context.Context) error {
+			if err := e.Shutdown(ctx); err != nil {
+				e.Close()
+				return err
+			}
+			return nil
+		}},
+		shutdownStep{"https redirect", func(ctx context.Context) error {
+			if redirect == nil {
+				return nil
+			}
+			return redirect.Shutdown(ctx)
+		}},
+		shutdownStep{"breaker webhooks", breakerNotifier.Close},
+		shutdownStep{"tracing", func(ctx context.Context) error {
+			if tracer == nil {
+				return nil
+			}
+			return tracer.Shutdown(ctx)
+		}},
+	)
 }
 
 type LoginRequest struct {
@@ -111,21 +357,34 @@ type LoginRequest struct {
 
 func getLoginHandler(userService UserService) echo.HandlerFunc {
 	f := func(c echo.Context) error {
+		start := time.Now()
+		outcome := LoginError
+		defer func() { userService.Metrics.ObserveLogin(outcome, time.Since(start)) }()
+
 		requestData := LoginRequest{}
 		decoder := json.NewDecoder(c.Request().Body)
 		if err := decoder.Decode(&requestData); err != nil {
-			log.Printf("could not read credentials from POST body: %s", err.Error())
+			userService.logger().WarnContext(c.Request().Context(), "could not read credentials from POST body", "error", err)
+			outcome = LoginBadRequest
 			return ErrHttpGenericMessage
 		}
 
 		ctx := c.Request().Context()
+		setLogUsername(ctx, requestData.Username)
 		user, err := userService.Login(ctx, requestData.Username, requestData.Password)
 		if err != nil {
+			var unavailable *DependencyUnavailableError
+			if errors.As(err, &unavailable) {
+				userService.logger().WarnContext(ctx, "could not authorize user", "error", err)
+				outcome = LoginUnavailable
+				return ErrDependencyUnavailable
+			}
 			if err != ErrWrongCredentials {
-				log.Printf("could not authorize user '%s': %s", requestData.Username, err.Error())
+				userService.logger().ErrorContext(ctx, "could not authorize user", "error", err)
 				return ErrHttpGenericMessage
 			}
 
+			outcome = LoginInvalidCredentials
 			return ErrWrongCredentials
 		}
 		token := jwt.New(jwt.SigningMethodHS256)
@@ -139,12 +398,14 @@ func getLoginHandler(userService UserService) echo.HandlerFunc {
 		claims["exp"] = time.Now().Add(time.Hour * 72).Unix()
 
 		// Generate encoded token and send it as response.
-		t, err := token.SignedString([]byte(jwtSecret))
+		t, err := token.SignedString([]byte(jwtSecret.Get()))
 		if err != nil {
-			log.Printf("could not generate a JWT token: %s", err.Error())
+			userService.logger().ErrorContext(ctx, "could not generate a JWT token", "error", err)
 			return ErrHttpGenericMessage
 		}
 
+		userService.Metrics.TokenIssued(TokenAccess)
+		outcome = LoginSuccess
 		return c.JSON(http.StatusOK, map[string]string{
 			"accessToken": t,
 		})
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sony/gobreaker/v2"
+)
+
+const metricsNamespace = "auth_api"
+
+// Login outcomes, used as the outcome label of the login metrics.
+const (
+	LoginSuccess            = "success"
+	LoginInvalidCredentials = "invalid_credentials"
+	LoginUnavailable        = "unavailable"
+	LoginBadRequest         = "bad_request"
+	LoginError              = "error"
+)
+
+// Token types, used as the type label of auth_api_tokens_issued_total.
+const (
+	TokenAccess  = "access"
+	TokenService = "service"
+)
+
+// authMetrics holds the Prometheus metrics served on /metrics. Every method is
+// a no-op on a nil *authMetrics so components work without metrics.
+type authMetrics struct {
+	registry *prometheus.Registry
+
+	logins             *prometheus.CounterVec
+	loginDuration      *prometheus.HistogramVec
+	usersAPIDuration   *prometheus.HistogramVec
+	attempts           prometheus.Counter
+	retries            *prometheus.CounterVec
+	retryGaveUp        *prometheus.CounterVec
+	breakerTransitions *prometheus.CounterVec
+	tokens             *prometheus.CounterVec
+	configReloads      *prometheus.CounterVec
+}
+
+func newAuthMetrics() *authMetrics {
+	m := &authMetrics{
+		registry: prometheus.NewRegistry(),
+		logins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "login_attempts_total",
+			Help:      "Login attempts by outcome.",
+		}, []string{"outcome"}),
+		loginDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "login_duration_seconds",
+			Help:      "Time to answer a login request, by outcome.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"outcome"}),
+		usersAPIDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "users_api_request_duration_seconds",
+			Help:      "Latency of each attempt to Users API, by response status (\"error\" for transport errors).",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"status"}),
+		attempts: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "users_api_attempts_total",
+			Help:      "Users API attempts made by the retry layer, first attempts included.",
+		}),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "users_api_retries_total",
+			Help:      "Retried Users API attempts, by reason.",
+		}, []string{"reason"}),
+		retryGaveUp: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "users_api_retry_gave_up_total",
+			Help:      "Users API calls that failed after exhausting their retries, by reason of the last attempt.",
+		}, []string{"reason"}),
+		breakerTransitions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "circuit_breaker_transitions_total",
+			Help:      "Circuit breaker state transitions.",
+		}, []string{"breaker", "from", "to"}),
+		tokens: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "tokens_issued_total",
+			Help:      "JWT tokens issued, by type (access for users, service for Users API calls).",
+		}, []string{"type"}),
+		configReloads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "config_reloads_total",
+			Help:      "Configuration reloads, by result (success or rejected).",
+		}, []string{"result"}),
+	}
+	m.registry.MustRegister(
+		m.logins, m.loginDuration, m.usersAPIDuration, m.attempts, m.retries, m.retryGaveUp, m.breakerTransitions, m.tokens, m.configReloads,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// ObserveLogin records a login attempt and how long it took.
+func (m *authMetrics) ObserveLogin(outcome string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.logins.WithLabelValues(outcome).Inc()
+	m.loginDuration.WithLabelValues(outcome).Observe(d.Seconds())
+}
+
+// ConfigReloaded counts a configuration reload, applied or rejected.
+func (m *authMetrics) ConfigReloaded(applied bool) {
+	if m == nil {
+		return
+	}
+	result := "success"
+	if !applied {
+		result = "rejected"
+	}
+	m.configReloads.WithLabelValues(result).Inc()
+}
+
+// ObserveUsersAPI records one attempt to Users API.
+func (m *authMetrics) ObserveUsersAPI(resp *http.Response, err error, d time.Duration) {
+	if m == nil {
+		return
+	}
+	status := "error"
+	if err == nil && resp != nil {
+		status = strconv.Itoa(resp.StatusCode)
+	}
+	m.usersAPIDuration.WithLabelValues(status).Observe(d.Seconds())
+}
+
+// Attempted records an attempt sent by the retry layer.
+func (m *authMetrics) Attempted() {
+	if m == nil {
+		return
+	}
+	m.attempts.Inc()
+}
+
+// Retried records an attempt scheduled again by the retry layer.
+func (m *authMetrics) Retried(reason string) {
+	if m == nil {
+		return
+	}
+	m.retries.WithLabelValues(reason).Inc()
+}
+
+// GaveUp records a call the retry layer failed after its last attempt.
+func (m *authMetrics) GaveUp(reason string) {
+	if m == nil {
+		return
+	}
+	m.retryGaveUp.WithLabelValues(reason).Inc()
+}
+
+// OnStateChange counts breaker transitions; it has the BreakerSettings.OnStateChange signature.
+func (m *authMetrics) OnStateChange(name string, from, to gobreaker.State) {
+	if m == nil {
+		return
+	}
+	m.breakerTransitions.WithLabelValues(name, from.String(), to.String()).Inc()
+}
+
+// TokenIssued counts a signed JWT of the given type.
+func (m *authMetrics) TokenIssued(kind string) {
+	if m == nil {
+		return
+	}
+	m.tokens.WithLabelValues(kind).Inc()
+}
+
+// WatchBreakers exports the state of every breaker of the registry.
+func (m *authMetrics) WatchBreakers(breakers *breakerRegistry) {
+	if m == nil {
+		return
+	}
+	m.registry.MustRegister(&breakerStateCollector{
+		breakers: breakers,
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "circuit_breaker", "state"),
+			"Circuit breaker state: 0 closed, 1 half-open, 2 open.",
+			[]string{"breaker"}, nil,
+		),
+	})
+}
+
+// WatchTracing exports the span reporter counters of tr.
+func (m *authMetrics) WatchTracing(tr *tracing) {
+	if m == nil || tr == nil {
+		return
+	}
+	m.registry.MustRegister(&spanReporterCollector{
+		tracing: tr,
+		spans: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "tracing", "spans_total"),
+			"Finished spans handled by the span reporter, by result (exported, spooled, replayed, dropped).",
+			[]string{"result"}, nil,
+		),
+		spoolBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "tracing", "spool_bytes"),
+			"Size of the span spool file waiting to be replayed.",
+			nil, nil,
+		),
+	})
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func (m *authMetrics) Handler() echo.HandlerFunc {
+	return echo.WrapHandler(promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+}
+
+// breakerStateCollector reads the breaker states at scrape time.
+type breakerStateCollector struct {
+	breakers *breakerRegistry
+	desc     *prometheus.Desc
+}
+
+func (c *breakerStateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *breakerStateCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, st := range c.breakers.Status() {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, breakerStateValue(st.State), st.Name)
+	}
+}
+
+func breakerStateValue(state string) float64 {
+	switch state {
+	case gobreaker.StateHalfOpen.String():
+		return 1
+	case gobreaker.StateOpen.String():
+		return 2
+	}
+	return 0
+}
+
+// spanReporterCollector reads the span reporter counters at scrape time.
+type spanReporterCollector struct {
+	tracing    *tracing
+	spans      *prometheus.Desc
+	spoolBytes *prometheus.Desc
+}
+
+func (c *spanReporterCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.spans
+	ch <- c.spoolBytes
+}
+
+func (c *spanReporterCollector) Collect(ch chan<- prometheus.Metric) {
+	st := c.tracing.Stats()
+	for result, n := range map[string]uint64{
+		"exported": st.Exported,
+		"spooled":  st.Spooled,
+		"replayed": st.Replayed,
+		"dropped":  st.Dropped,
+	} {
+		ch <- prometheus.MustNewConstMetric(c.spans, prometheus.CounterValue, float64(n), result)
+	}
+	ch <- prometheus.MustNewConstMetric(c.spoolBytes, prometheus.GaugeValue, float64(st.SpoolBytes))
+}
+
+// metricsHTTPClient measures every call to the wrapped client.
+type metricsHTTPClient struct {
+	client  HTTPDoer
+	metrics *authMetrics
+}
+
+func newMetricsHTTPClient(client HTTPDoer, metrics *authMetrics) *metricsHTTPClient {
+	return &metricsHTTPClient{client: client, metrics: metrics}
+}
+
+func (c *metricsHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := c.client.Do(req)
+	c.metrics.ObserveUsersAPI(resp, err, time.Since(start))
+	return resp, err
+}
+
+var _ HTTPDoer = (*metricsHTTPClient)(nil)
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/sony/gobreaker/v2"
+)
+
+func scrape(t *testing.T, m *authMetrics) string {
+	e := echo.New()
+	e.GET("/metrics", m.Handler())
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected /metrics status %d", rec.Code)
+	}
+	return rec.Body.String()
+}
+
+func TestMetrics_Exposition(t *testing.T) {
+	m := newAuthMetrics()
+	m.ObserveLogin(LoginSuccess, 20*time.Millisecond)
+	m.ObserveLogin(LoginInvalidCredentials, 5*time.Millisecond)
+	m.Attempted()
+	m.Attempted()
+	m.Retried("status_503")
+	m.GaveUp("status_503")
+	m.OnStateChange("users-api-breaker", gobreaker.StateClosed, gobreaker.StateOpen)
+	m.TokenIssued(TokenAccess)
+
+	breakers := newBreakerRegistry(&okClient{}, DefaultBreakerSettings(), false)
+	breakers.Breaker("users-api:8083", "").SetOverride(BreakerModeForcedOpen, "test", "", 0)
+	m.WatchBreakers(breakers)
+
+	svc := &UserService{
+		Client:         newMetricsHTTPClient(&userAPIClient{body: `{"username":"admin"}`}, m),
+		UserAPIAddress: "http://users-api:8083",
+		AllowedUsers:   newAllowedUserStore(map[string]string{"admin": "admin"}),
+		Metrics:        m,
+	}
+	if _, err := svc.Login(context.Background(), "admin", "admin"); err != nil {
+		t.Fatalf("unexpected login error: %v", err)
+	}
+
+	out := scrape(t, m)
+	for _, want := range []string{
+		`auth_api_login_attempts_total{outcome="success"} 1`,
+		`auth_api_login_attempts_total{outcome="invalid_credentials"} 1`,
+		`auth_api_login_duration_seconds_count{outcome="success"} 1`,
+		`auth_api_users_api_request_duration_seconds_count{status="200"} 1`,
+		`auth_api_users_api_attempts_total 2`,
+		`auth_api_users_api_retries_total{reason="status_503"} 1`,
+		`auth_api_users_api_retry_gave_up_total{reason="status_503"} 1`,
+		`auth_api_circuit_breaker_transitions_total{breaker="users-api-breaker",from="closed",to="open"} 1`,
+		`auth_api_circuit_breaker_state{breaker="users-api:8083"} 2`,
+		`auth_api_tokens_issued_total{type="access"} 1`,
+		`auth_api_tokens_issued_total{type="service"} 1`,
+		`go_goroutines`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in /metrics output", want)
+		}
+	}
+}
+
+func TestMetrics_NilIsNoop(t *testing.T) {
+	var m *authMetrics
+	m.ObserveLogin(LoginError, time.Second)
+	m.ObserveUsersAPI(nil, context.Canceled, time.Second)
+	m.Attempted()
+	m.Retried("timeout")
+	m.GaveUp("timeout")
+	m.TokenIssued(TokenService)
+	m.WatchBreakers(nil)
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ConfigReloadStatus is the outcome of the configuration reloads.
+type ConfigReloadStatus struct {
+	Reloads  uint64 `json:"reloads"`
+	Failures uint64 `json:"failures"`
+	// LastReload is the time of the last successful reload.
+	LastReload *time.Time `json:"lastReload,omitempty"`
+	// LastError is the reason the last reload was rejected, cleared by a
+	// successful one.
+	LastError string `json:"lastError,omitempty"`
+	// RestartNeeded lists the changed settings that only apply after a restart.
+	RestartNeeded []string `json:"restartNeeded,omitempty"`
+}
+
+// configReloader reloads the configuration on demand, on SIGHUP and when
+// the config file changes. Each group of reloadable settings that changed is
+// applied by its hook; the other settings keep their running values until a
+// restart. An invalid configuration is rejected and the running one kept.
+type configReloader struct {
+	load    func() (*Config, error)
+	logger  *slog.Logger
+	metrics *authMetrics
+
+	current atomic.Pointer[Config]
+
+	mu     sync.Mutex
+	hooks  map[string]func(*Config)
+	status ConfigReloadStatus
+}
+
+func newConfigReloader(cfg *Config, load func() (*Config, error), logger *slog.Logger, metrics *authMetrics) *configReloader {
+	r := &configReloader{load: load, logger: logger, metrics: metrics, hooks: map[string]func(*Config){}}
+	r.current.Store(cfg)
+	return r
+}
+
+// OnReload registers the hook applying the settings of group.
+func (r *configReloader) OnReload(group string, apply func(*Config)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hooks[group] = apply
+}
+
+// Current returns the configuration in effect.
+func (r *configReloader) Current() *Config {
+	return r.current.Load()
+}
+
+// Status returns the outcome of the reloads so far.
+func (r *configReloader) Status() ConfigReloadStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.status
+	s.RestartNeeded = append([]string(nil), s.RestartNeeded...)
+	return s
+}
+
+// Reload loads the configuration again and applies the groups whose
+// settings changed. trigger is logged, e.g. "SIGHUP".
+func (r *configReloader) Reload(trigger string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	running := r.current.Load()
+	var groups, restart []string
+	next, err := r.load()
+	if err == nil {
+		groups, restart = running.changes(next)
+		err = next.keepUnreloadable(running)
+	}
+	if err != nil {
+		r.status.Failures++
+		r.status.LastError = err.Error()
+		r.metrics.ConfigReloaded(false)
+		r.logger.Error("configuration reload rejected, keeping the running configuration", "trigger", trigger, "error", err)
+		return err
+	}
+
+	for _, g := range groups {
+		if apply, ok := r.hooks[g]; ok {
+			apply(next)
+		}
+	}
+	r.current.Store(next)
+
+	now := time.Now()
+	r.status.Reloads++
+	r.status.LastReload = &now
+	r.status.LastError = ""
+	r.status.RestartNeeded = restart
+	r.metrics.ConfigReloaded(true)
+	r.logger.Info("configuration reloaded", "trigger", trigger, "applied", groups)
+	if len(restart) > 0 {
+		r.logger.Warn("changed settings need a restart to apply", "settings", restart)
+	}
+	return nil
+}
+
+// Watch reloads on every signal received from hup and, every interval,
+// when the content of the config file or of a secret file changed, until
+// stop is closed.
+func (r *configReloader) Watch(hup <-chan os.Signal, interval time.Duration, stop <-chan struct{}) {
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	last := r.Current().digest
+	for {
+		select {
+		case <-stop:
+			return
+		case sig := <-hup:
+			last = r.Current().filesDigest()
+			r.Reload(sig.String())
+		case <-tick:
+			if d := r.Current().filesDigest(); !bytes.Equal(d, last) {
+				last = d
+				r.Reload("file change")
+			}
+		}
+	}
+}
+
+// filesDigest hashes the content of the watched files.
+func (c *Config) filesDigest() []byte {
+	files := c.watchedFiles()
+	digests := make([][]byte, len(files))
+	for i, f := range files {
+		digests[i] = fileDigest(f)
+	}
+	return joinDigests(digests)
+}
+
+// joinDigests hashes the digests of several files into one.
+func joinDigests(digests [][]byte) []byte {
+	h := sha256.New()
+	for _, d := range digests {
+		// prefixed with its length so a missing file still shifts the hash
+		h.Write([]byte{byte(len(d))})
+		h.Write(d)
+	}
+	return h.Sum(nil)
+}
+
+// fileDigest hashes the content of path; nil when it cannot be read, so a
+// deleted file counts as a change.
+func fileDigest(path string) []byte {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
+
+// changes returns the reload groups with a changed setting, and the changed
+// settings that need a restart.
+func (c *Config) changes(next *Config) (groups, restart []string) {
+	seen := map[string]bool{}
+	for _, s := range configSettings {
+		if c.sameValue(next, s.Env) {
+			continue
+		}
+		switch {
+		case s.Reload == "":
+			restart = append(restart, s.Env)
+		case !seen[s.Reload]:
+			seen[s.Reload] = true
+			groups = append(groups, s.Reload)
+		}
+	}
+	sort.Strings(groups)
+	return groups, restart
+}
+
+// sameValue tells whether key is set alike in c and other, whatever its
+// source; for a WatchFile setting the file content must match as well.
+func (c *Config) sameValue(other *Config, key string) bool {
+	a, aok := c.values[key]
+	b, bok := other.values[key]
+	return aok == bok && a.Value == b.Value && bytes.Equal(a.digest, b.digest)
+}
+
+// keepUnreloadable puts back the running values of the settings that need a
+// restart, so c describes what is in effect.
+func (c *Config) keepUnreloadable(running *Config) error {
+	kept := false
+	for _, s := range configSettings {
+		if s.Reload != "" || c.sameValue(running, s.Env) {
+			continue
+		}
+		if v, ok := running.values[s.Env]; ok {
+			c.values[s.Env] = v
+		} else {
+			delete(c.values, s.Env)
+		}
+		kept = true
+	}
+	if !kept {
+		return nil
+	}
+	if err := c.parse(); err != nil {
+		return fmt.Errorf("with the settings needing a restart unchanged: %w", err)
+	}
+	return nil
+}
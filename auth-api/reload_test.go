@@ -0,0 +1,210 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/sony/gobreaker/v2"
+)
+
+func newReloadTest(t *testing.T, content string) (*configReloader, string) {
+	t.Helper()
+	file := writeConfigFile(t, content)
+	load := func() (*Config, error) {
+		return loadConfig([]string{"-config", file}, mapLookup(nil), io.Discard)
+	}
+	cfg, err := load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return newConfigReloader(cfg, load, slog.New(slog.NewTextHandler(io.Discard, nil)), nil), file
+}
+
+func TestConfigReloader_AppliesChangedGroups(t *testing.T) {
+	r, file := newReloadTest(t, "usersApi.address: http://users-api:8083\nretry.maxRetries: 3\nlog.level: info\n")
+	var applied []string
+	for _, g := range []string{ReloadLog, ReloadBreaker, ReloadRetry, ReloadCredentials} {
+		g := g
+		r.OnReload(g, func(c *Config) { applied = append(applied, g) })
+	}
+
+	os.WriteFile(file, []byte("usersApi.address: http://users-api:9000\nretry.maxRetries: 5\nlog.level: info\n"), 0o600)
+	if err := r.Reload("test"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(applied) != 1 || applied[0] != ReloadRetry {
+		t.Fatalf("expected only the retry settings to be applied, got %v", applied)
+	}
+	cfg := r.Current()
+	if cfg.Retry.MaxRetries != 5 {
+		t.Fatalf("expected the new retry settings, got %+v", cfg.Retry)
+	}
+	if cfg.UsersAPIAddress != "http://users-api:8083" {
+		t.Fatalf("expected the running users-api address until a restart, got %q", cfg.UsersAPIAddress)
+	}
+	if st := r.Status(); st.Reloads != 1 || len(st.RestartNeeded) != 1 || st.RestartNeeded[0] != "USERS_API_ADDRESS" {
+		t.Fatalf("unexpected status %+v", st)
+	}
+}
+
+func TestConfigReloader_RejectsInvalidConfig(t *testing.T) {
+	r, file := newReloadTest(t, "usersApi.address: http://users-api:8083\nbreaker.maxRequests: 2\n")
+	r.OnReload(ReloadBreaker, func(*Config) { t.Fatal("an invalid config must not be applied") })
+	before := r.Current()
+
+	os.WriteFile(file, []byte("usersApi.address: http://users-api:8083\nbreaker.maxRequests: -1\n"), 0o600)
+	if err := r.Reload("test"); err == nil || !strings.Contains(err.Error(), "CB_MAX_REQUESTS") {
+		t.Fatalf("expected the invalid setting to be reported, got %v", err)
+	}
+	if r.Current() != before {
+		t.Fatal("expected the running configuration to be kept")
+	}
+	if st := r.Status(); st.Failures != 1 || st.LastError == "" || st.Reloads != 0 {
+		t.Fatalf("unexpected status %+v", st)
+	}
+}
+
+func TestConfigReloader_WatchesFileAndSignal(t *testing.T) {
+	r, file := newReloadTest(t, "usersApi.address: http://users-api:8083\nlog.level: info\n")
+	applied := make(chan string, 4)
+	r.OnReload(ReloadLog, func(c *Config) { applied <- c.Log.Level.String() })
+
+	hup := make(chan os.Signal, 1)
+	stop := make(chan struct{})
+	defer close(stop)
+	go r.Watch(hup, 10*time.Millisecond, stop)
+
+	os.WriteFile(file, []byte("usersApi.address: http://users-api:8083\nlog.level: debug\n"), 0o600)
+	select {
+	case lvl := <-applied:
+		if lvl != "DEBUG" {
+			t.Fatalf("expected the new level, got %s", lvl)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected the file change to be applied, status %+v", r.Status())
+	}
+
+	os.WriteFile(file, []byte("usersApi.address: http://users-api:8083\nlog.level: warn\n"), 0o600)
+	hup <- syscall.SIGHUP
+	select {
+	case lvl := <-applied:
+		if lvl != "WARN" {
+			t.Fatalf("expected the new level, got %s", lvl)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected SIGHUP to reload")
+	}
+}
+
+func TestBreakerRegistry_ReconfigureKeepsNames(t *testing.T) {
+	var transitions int
+	defaults := DefaultBreakerSettings()
+	defaults.OnStateChange = func(string, gobreaker.State, gobreaker.State) { transitions++ }
+	reg := newBreakerRegistry(&failingClient{}, defaults, false)
+	custom := defaults
+	custom.Name = "users-api-breaker"
+	reg.Configure("users-api:8083", custom)
+	b := reg.Breaker("users-api:8083", "")
+
+	tunables := DefaultBreakerSettings()
+	tunables.ConsecutiveFailures = 1
+	tunables.MinRequests = 100
+	reg.Reconfigure(tunables)
+
+	req, _ := http.NewRequest(http.MethodGet, "http://users-api:8083/users/admin", nil)
+	b.Do(req)
+	if state, _ := b.Status(); state != gobreaker.StateOpen || b.Name() != "users-api-breaker" {
+		t.Fatalf("expected the renamed breaker to open after one failure, got %s %q", state, b.Name())
+	}
+	if transitions != 1 {
+		t.Fatalf("expected the state change hook to be kept, got %d calls", transitions)
+	}
+	if other := reg.Breaker("other:80", ""); other.settings.ConsecutiveFailures != 1 {
+		t.Fatal("expected breakers created later to use the new tunables")
+	}
+}
+
+func TestBreaker_ReconfigureKeepsOpenState(t *testing.T) {
+	s := DefaultBreakerSettings()
+	s.Name = "users-api-breaker"
+	s.Timeout = 20 * time.Millisecond
+	s.MaxRequests = 1
+	b := newBreakerHTTPClientWithSettings(&failingClient{}, s)
+	req, _ := http.NewRequest(http.MethodGet, "http://users-api:8083/users/admin", nil)
+	for i := 0; i < int(s.ConsecutiveFailures); i++ {
+		b.Do(req)
+	}
+
+	tunables := DefaultBreakerSettings()
+	tunables.Timeout = time.Minute
+	tunables.ConsecutiveFailures = 1
+	tunables.MinRequests = 100
+	b.Reconfigure(tunables)
+	if state, _ := b.Status(); state != gobreaker.StateOpen {
+		t.Fatalf("expected the breaker to stay open across a reload, got %s", state)
+	}
+
+	// the open period it started with runs out, and a good probe closes it
+	time.Sleep(s.Timeout + 10*time.Millisecond)
+	b.client = &okClient{}
+	if _, err := b.Do(req); err != nil {
+		t.Fatalf("expected the half-open probe through, got %v", err)
+	}
+	b.client = &failingClient{}
+	b.Do(req)
+	if state, _ := b.Status(); state != gobreaker.StateOpen {
+		t.Fatalf("expected the new tunables once closed, got %s", state)
+	}
+}
+
+func TestConfigReloader_AppliesUsersAndCORSOrigins(t *testing.T) {
+	r, file := newReloadTest(t, "usersApi.address: http://users-api:8083\ncredentials.allowedUsers: admin:admin\n")
+	users := newAllowedUserStore(r.Current().AllowedUsers)
+	cors := newCORSMiddleware(r.Current().CORS)
+	r.OnReload(ReloadCredentials, func(c *Config) { users.Set(c.AllowedUsers) })
+	r.OnReload(ReloadCORS, func(c *Config) { cors.Apply(c.CORS) })
+
+	e := echo.New()
+	e.Use(cors.Middleware)
+	e.GET("/version", func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+	allowedOrigin := func(origin string) string {
+		req := httptest.NewRequest(http.MethodGet, "/version", nil)
+		req.Header.Set(echo.HeaderOrigin, origin)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Header().Get(echo.HeaderAccessControlAllowOrigin)
+	}
+	if !users.Allowed("admin", "admin") || allowedOrigin("https://todos.example.com") != "*" {
+		t.Fatal("expected the initial users and any origin")
+	}
+
+	os.WriteFile(file, []byte("usersApi.address: http://users-api:8083\ncredentials.allowedUsers: ops:0ps\ncors.allowOrigins: https://todos.example.com\n"), 0o600)
+	if err := r.Reload("test"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if users.Allowed("admin", "admin") || !users.Allowed("ops", "0ps") {
+		t.Fatal("expected the reloaded users to replace the old ones")
+	}
+	if got := allowedOrigin("https://todos.example.com"); got != "https://todos.example.com" {
+		t.Fatalf("expected the listed origin to be allowed, got %q", got)
+	}
+	if got := allowedOrigin("https://evil.example.com"); got != "" {
+		t.Fatalf("expected other origins to be refused, got %q", got)
+	}
+
+	os.WriteFile(file, []byte("usersApi.address: http://users-api:8083\ncors.allowOrigins: todos.example.com/app\n"), 0o600)
+	if err := r.Reload("test"); err == nil || !strings.Contains(err.Error(), "CORS_ALLOW_ORIGINS") {
+		t.Fatalf("expected the invalid origin to be reported, got %v", err)
+	}
+	if !users.Allowed("ops", "0ps") {
+		t.Fatal("expected the running users to be kept")
+	}
+}
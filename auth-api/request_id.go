@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+// RequestIDHeader carries the id correlating a request across the frontend,
+// auth-api and users-api.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds the ids accepted from callers.
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// WithRequestID returns a context carrying the request id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// requestIDFromContext returns the id of the request of ctx, if any.
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// validRequestID accepts ids of printable ASCII without spaces, so a caller
+// cannot inject headers or break log lines.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// requestIDMiddleware keeps the caller's X-Request-ID, or generates one,
+// stores it in the request context and echoes it in the response.
+func requestIDMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		id := req.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		c.SetRequest(req.WithContext(WithRequestID(req.Context(), id)))
+		c.Response().Header().Set(RequestIDHeader, id)
+		return next(c)
+	}
+}
+
+// requestIDErrorHandler adds the request id to the JSON error bodies of
+// echo's default error handler, so users can quote it in bug reports.
+func requestIDErrorHandler(e *echo.Echo) echo.HTTPErrorHandler {
+	return func(err error, c echo.Context) {
+		id := requestIDFromContext(c.Request().Context())
+		if id == "" || c.Response().Committed {
+			e.DefaultHTTPErrorHandler(err, c)
+			return
+		}
+
+		code := http.StatusInternalServerError
+		var msg interface{} = http.StatusText(code)
+		if he, ok := err.(*echo.HTTPError); ok {
+			code, msg = he.Code, he.Message
+		} else if e.Debug {
+			msg = err.Error()
+		}
+
+		if c.Request().Method == http.MethodHead {
+			err = c.NoContent(code)
+		} else {
+			err = c.JSON(code, echo.Map{"message": msg, "requestId": id})
+		}
+		if err != nil {
+			e.Logger.Error(err)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo"
+)
+
+func newRequestIDTestServer(handler echo.HandlerFunc) *echo.Echo {
+	e := echo.New()
+	e.Pre(requestIDMiddleware)
+	e.HTTPErrorHandler = requestIDErrorHandler(e)
+	e.POST("/login", handler)
+	return e
+}
+
+func TestRequestID_KeepsValidAndReplacesInvalidIDs(t *testing.T) {
+	var seen string
+	e := newRequestIDTestServer(func(c echo.Context) error {
+		seen = requestIDFromContext(c.Request().Context())
+		return c.NoContent(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/login", nil)
+	req.Header.Set(RequestIDHeader, "frontend-42")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if seen != "frontend-42" || rec.Header().Get(RequestIDHeader) != "frontend-42" {
+		t.Fatalf("expected the caller's id to be kept, got context=%q header=%q", seen, rec.Header().Get(RequestIDHeader))
+	}
+
+	for _, bad := range []string{"", "has space", strings.Repeat("x", maxRequestIDLength+1)} {
+		req := httptest.NewRequest(http.MethodPost, "/login", nil)
+		req.Header.Set(RequestIDHeader, bad)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if len(seen) != 32 || seen == bad || rec.Header().Get(RequestIDHeader) != seen {
+			t.Fatalf("expected a generated id for %q, got context=%q header=%q", bad, seen, rec.Header().Get(RequestIDHeader))
+		}
+	}
+}
+
+func TestRequestID_InErrorBodies(t *testing.T) {
+	e := newRequestIDTestServer(func(c echo.Context) error { return ErrWrongCredentials })
+
+	req := httptest.NewRequest(http.MethodPost, "/login", nil)
+	req.Header.Set(RequestIDHeader, "abc-123")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	var body map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid error body %q: %v", rec.Body.String(), err)
+	}
+	if rec.Code != http.StatusUnauthorized || body["requestId"] != "abc-123" || body["message"] != "username or password is invalid" {
+		t.Fatalf("unexpected error response %d %v", rec.Code, body)
+	}
+
+	// unrouted requests carry the id too
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/nope", nil))
+	if rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), rec.Header().Get(RequestIDHeader)) {
+		t.Fatalf("expected the 404 body to carry the request id, got %q", rec.Body.String())
+	}
+}
+
+func TestRequestID_ForwardedToUsersAPI(t *testing.T) {
+	var got string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get(RequestIDHeader)
+		w.Write([]byte(`{"username":"admin","role":"ADMIN"}`))
+	}))
+	defer upstream.Close()
+
+	svc := &UserService{
+		Client:         http.DefaultClient,
+		UserAPIAddress: upstream.URL,
+		AllowedUsers:   newAllowedUserStore(map[string]string{"admin": "admin"}),
+	}
+	req := httptest.NewRequest(http.MethodPost, "/login", nil)
+	ctx := WithRequestID(req.Context(), "abc-123")
+	if _, err := svc.Login(ctx, "admin", "admin"); err != nil {
+		t.Fatalf("unexpected login error: %v", err)
+	}
+	if got != "abc-123" {
+		t.Fatalf("expected users-api to receive the request id, got %q", got)
+	}
+}
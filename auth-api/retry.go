@@ -1,13 +1,17 @@
 package main
 
 import (
-    "context"
-    "errors"
     "io"
     "math/rand"
-    "net"
     "net/http"
+    "strconv"
+    "sync"
+    "sync/atomic"
     "time"
+
+    "go.opentelemetry.io/otel"
+    "go.opentelemetry.io/otel/attribute"
+    "go.opentelemetry.io/otel/trace"
 )
 
 // RetryConfig define los parámetros del backoff y número de intentos.
@@ -15,15 +19,60 @@ type RetryConfig struct {
     MaxRetries int
     BaseDelay  time.Duration
     MaxDelay   time.Duration
+    // Policy decide qué respuestas y errores se reintentan; vacío usa DefaultRetryPolicy.
+    Policy RetryPolicy
+    // OnRetry, si no es nil, se invoca antes de esperar cada reintento.
+    OnRetry func(req *http.Request, ev RetryEvent)
+    // OnAttempt, si no es nil, se invoca antes de enviar cada intento.
+    OnAttempt func(req *http.Request, attempt int)
+    // OnGiveUp, si no es nil, se invoca al agotar los reintentos; ev.Delay es cero.
+    OnGiveUp func(req *http.Request, ev RetryEvent)
+}
+
+// RetryEvent describe un reintento que está a punto de ejecutarse o el
+// intento final con el que el cliente se rinde.
+type RetryEvent struct {
+    // Attempt es el número del intento que falló (el primero es 1).
+    Attempt    int
+    Reason     string
+    StatusCode int
+    Err        error
+    Delay      time.Duration
 }
 
+// RetryStats agrupa los contadores acumulados del cliente de reintentos.
+type RetryStats struct {
+    Requests uint64            `json:"requests"`
+    Attempts uint64            `json:"attempts"`
+    Retried  uint64            `json:"retried"`
+    GaveUp   uint64            `json:"gaveUp"`
+    ByReason map[string]uint64 `json:"byReason"`
+}
+
+// RetryAttemptHeader lleva el número de intento en las peticiones salientes.
+const RetryAttemptHeader = "X-Retry-Attempt"
+
 // retryHTTPClient envuelve un HTTPDoer y aplica reintentos controlados.
 type retryHTTPClient struct {
     base HTTPDoer
-    cfg  RetryConfig
+    cfg  atomic.Pointer[RetryConfig]
+
+    reqs     uint64
+    attempts uint64
+    retried  uint64
+    gaveUp   uint64
+    mu       sync.Mutex
+    byReason map[string]uint64
+}
+
+func newRetryHTTPClient(base HTTPDoer, cfg RetryConfig) *retryHTTPClient {
+    c := &retryHTTPClient{base: base, byReason: map[string]uint64{}}
+    c.SetConfig(cfg)
+    return c
 }
 
-func newRetryHTTPClient(base HTTPDoer, cfg RetryConfig) HTTPDoer {
+// SetConfig reemplaza la configuración; las peticiones en curso terminan con la anterior.
+func (c *retryHTTPClient) SetConfig(cfg RetryConfig) {
     if cfg.MaxRetries < 1 {
         cfg.MaxRetries = 1
     }
@@ -33,7 +82,10 @@ func newRetryHTTPClient(base HTTPDoer, cfg RetryConfig) HTTPDoer {
     if cfg.MaxDelay < cfg.BaseDelay {
         cfg.MaxDelay = 2 * time.Second
     }
-    return &retryHTTPClient{base: base, cfg: cfg}
+    if cfg.Policy.isZero() {
+        cfg.Policy = DefaultRetryPolicy()
+    }
+    c.cfg.Store(&cfg)
 }
 
 // Do ejecuta la petición con reintentos para métodos idempotentes y errores transitorios.
@@ -44,19 +96,59 @@ func (c *retryHTTPClient) Do(req *http.Request) (*http.Response, error) {
     default:
         return c.base.Do(req)
     }
+    atomic.AddUint64(&c.reqs, 1)
+    cfg := c.cfg.Load()
+
+    // la política del contexto tiene prioridad sobre la configurada en el cliente
+    policy, ok := retryPolicyFromContext(req.Context())
+    if !ok {
+        policy = cfg.Policy
+    }
+    // span hijo que agrupa todos los intentos; sin trazado activo es no-op
+    ctx, span := otel.Tracer(tracerName).Start(req.Context(), "retry")
+    req = req.WithContext(ctx)
+    attempt := 0
+    defer func() {
+        span.SetAttributes(attribute.Int("retry.attempts", attempt))
+        span.End()
+    }()
 
     var lastErr error
     var resp *http.Response
-    delay := c.cfg.BaseDelay
+    delay := cfg.BaseDelay
 
-    for attempt := 0; attempt <= c.cfg.MaxRetries; attempt++ {
+    for attempt = 1; ; attempt++ {
         // respetar cancelación/timeout de contexto
         if err := req.Context().Err(); err != nil {
             return nil, err
         }
 
-        resp, lastErr = c.base.Do(req)
-        if shouldStopRetry(resp, lastErr) {
+        attemptReq := req.Clone(req.Context())
+        attemptReq.Header.Set(RetryAttemptHeader, strconv.Itoa(attempt))
+        atomic.AddUint64(&c.attempts, 1)
+        if cfg.OnAttempt != nil {
+            cfg.OnAttempt(req, attempt)
+        }
+        span.AddEvent("retry.attempt", trace.WithAttributes(attribute.Int("retry.attempt", attempt)))
+
+        resp, lastErr = c.base.Do(attemptReq)
+        if policy.shouldStop(resp, lastErr) {
+            return resp, lastErr
+        }
+
+        reason := retryReason(resp, lastErr)
+        c.countReason(reason)
+        if attempt > cfg.MaxRetries {
+            atomic.AddUint64(&c.gaveUp, 1)
+            span.AddEvent("retry.gave_up", trace.WithAttributes(attribute.String("retry.reason", reason)))
+            span.SetAttributes(attribute.Bool("retry.gave_up", true))
+            if cfg.OnGiveUp != nil {
+                ev := RetryEvent{Attempt: attempt, Reason: reason, Err: lastErr}
+                if resp != nil {
+                    ev.StatusCode = resp.StatusCode
+                }
+                cfg.OnGiveUp(req, ev)
+            }
             return resp, lastErr
         }
 
@@ -69,47 +161,63 @@ func (c *retryHTTPClient) Do(req *http.Request) (*http.Response, error) {
         // backoff exponencial con jitter
         jitter := time.Duration(rand.Int63n(int64(delay / 2)))
         sleep := delay + jitter
-        if sleep > c.cfg.MaxDelay {
-            sleep = c.cfg.MaxDelay
+        if sleep > cfg.MaxDelay {
+            sleep = cfg.MaxDelay
+        }
+
+        atomic.AddUint64(&c.retried, 1)
+        ev := RetryEvent{Attempt: attempt, Reason: reason, Err: lastErr, Delay: sleep}
+        if resp != nil {
+            ev.StatusCode = resp.StatusCode
+        }
+        span.AddEvent("retry.scheduled", trace.WithAttributes(
+            attribute.String("retry.reason", reason),
+            attribute.Int64("retry.delay_ms", sleep.Milliseconds()),
+        ))
+        if cfg.OnRetry != nil {
+            cfg.OnRetry(req, ev)
         }
         time.Sleep(sleep)
 
         delay *= 2
-        if delay > c.cfg.MaxDelay {
-            delay = c.cfg.MaxDelay
+        if delay > cfg.MaxDelay {
+            delay = cfg.MaxDelay
         }
     }
+}
 
-    return resp, lastErr
+func (c *retryHTTPClient) countReason(reason string) {
+    c.mu.Lock()
+    c.byReason[reason]++
+    c.mu.Unlock()
 }
 
-// shouldStopRetry decide si se debe parar de reintentar según respuesta/errores.
-func shouldStopRetry(resp *http.Response, err error) bool {
-    if err == nil {
-        if resp == nil {
-            return false
-        }
-        // Reintentar 5xx y 429; parar en el resto de códigos
-        if resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
-            return true
-        }
-        return false
+// Stats devuelve una copia de los contadores de reintentos.
+func (c *retryHTTPClient) Stats() RetryStats {
+    c.mu.Lock()
+    byReason := make(map[string]uint64, len(c.byReason))
+    for k, v := range c.byReason {
+        byReason[k] = v
     }
-
-    if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
-        return true
+    c.mu.Unlock()
+    return RetryStats{
+        Requests: atomic.LoadUint64(&c.reqs),
+        Attempts: atomic.LoadUint64(&c.attempts),
+        Retried:  atomic.LoadUint64(&c.retried),
+        GaveUp:   atomic.LoadUint64(&c.gaveUp),
+        ByReason: byReason,
     }
+}
 
-    // errores temporales de red son reintentables
-    var ne net.Error
-    if errors.As(err, &ne) {
-        return false
+// retryReason resume el motivo de un intento fallido, p. ej. "status_503" o "conn_refused".
+func retryReason(resp *http.Response, err error) string {
+    if err != nil {
+        return string(classifyError(err))
     }
-
-    // otros errores: permitir reintento, podría ser transitorio
-    return false
+    if resp == nil {
+        return "no_response"
+    }
+    return "status_" + strconv.Itoa(resp.StatusCode)
 }
 
 var _ HTTPDoer = (*retryHTTPClient)(nil)
-
-
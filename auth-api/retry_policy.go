@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sony/gobreaker/v2"
+)
+
+// RetryErrorClass groups transport errors so the retry policy can decide on them.
+type RetryErrorClass string
+
+const (
+	ErrClassConnRefused  RetryErrorClass = "conn_refused"
+	ErrClassConnReset    RetryErrorClass = "conn_reset"
+	ErrClassDNS          RetryErrorClass = "dns"
+	ErrClassTLSHandshake RetryErrorClass = "tls_handshake"
+	ErrClassTimeout      RetryErrorClass = "timeout"
+	ErrClassBreakerOpen  RetryErrorClass = "breaker_open"
+	ErrClassContext      RetryErrorClass = "context"
+	ErrClassOther        RetryErrorClass = "other"
+)
+
+var knownRetryErrorClasses = map[RetryErrorClass]bool{
+	ErrClassConnRefused:  true,
+	ErrClassConnReset:    true,
+	ErrClassDNS:          true,
+	ErrClassTLSHandshake: true,
+	ErrClassTimeout:      true,
+	ErrClassOther:        true,
+}
+
+// RetryPolicy declares which responses and errors are worth another attempt.
+type RetryPolicy struct {
+	// StatusCodes lists the response codes that are retried.
+	StatusCodes map[int]bool
+	// ErrorClasses lists the transport error classes that are retried.
+	ErrorClasses map[RetryErrorClass]bool
+	// RetryOnBreakerOpen retries calls rejected by an open or half-open breaker.
+	RetryOnBreakerOpen bool
+}
+
+// DefaultRetryPolicy retries 5xx, 429 and every transport error except an open breaker.
+func DefaultRetryPolicy() RetryPolicy {
+	p := RetryPolicy{
+		StatusCodes:  map[int]bool{http.StatusTooManyRequests: true},
+		ErrorClasses: map[RetryErrorClass]bool{},
+	}
+	for code := 500; code < 600; code++ {
+		p.StatusCodes[code] = true
+	}
+	for class := range knownRetryErrorClasses {
+		p.ErrorClasses[class] = true
+	}
+	return p
+}
+
+func (p RetryPolicy) isZero() bool {
+	return p.StatusCodes == nil && p.ErrorClasses == nil
+}
+
+// shouldStop decides whether the retry loop must return the given result.
+func (p RetryPolicy) shouldStop(resp *http.Response, err error) bool {
+	if err == nil {
+		if resp == nil {
+			return false
+		}
+		return !p.StatusCodes[resp.StatusCode]
+	}
+	// the breaker turns failed responses into errors; they are still
+	// retried on their status
+	var serverErr *ServerError
+	if errors.As(err, &serverErr) {
+		return !p.StatusCodes[serverErr.StatusCode]
+	}
+
+	switch class := classifyError(err); class {
+	case ErrClassContext:
+		return true
+	case ErrClassBreakerOpen:
+		return !p.RetryOnBreakerOpen
+	default:
+		return !p.ErrorClasses[class]
+	}
+}
+
+// classifyError maps a transport error to its retry class.
+func classifyError(err error) RetryErrorClass {
+	if errors.Is(err, context.Canceled) || isContextDeadline(err) {
+		return ErrClassContext
+	}
+	if errors.Is(err, gobreaker.ErrOpenState) || errors.Is(err, gobreaker.ErrTooManyRequests) {
+		return ErrClassBreakerOpen
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return ErrClassDNS
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return ErrClassConnRefused
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) {
+		return ErrClassConnReset
+	}
+
+	var recordErr tls.RecordHeaderError
+	var unknownAuthErr x509.UnknownAuthorityError
+	var invalidCertErr x509.CertificateInvalidError
+	var hostnameErr x509.HostnameError
+	if errors.As(err, &recordErr) || errors.As(err, &unknownAuthErr) ||
+		errors.As(err, &invalidCertErr) || errors.As(err, &hostnameErr) ||
+		strings.Contains(err.Error(), "TLS handshake") {
+		return ErrClassTLSHandshake
+	}
+
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return ErrClassTimeout
+	}
+
+	return ErrClassOther
+}
+
+// isContextDeadline tells whether err is the deadline of the request context.
+// The timeouts of net/http, e.g. awaiting response headers, match
+// context.DeadlineExceeded as well but are transport timeouts.
+func isContextDeadline(err error) bool {
+	for ; err != nil; err = errors.Unwrap(err) {
+		if err == context.DeadlineExceeded {
+			return true
+		}
+	}
+	return false
+}
+
+type retryPolicyKey struct{}
+
+// WithRetryPolicy overrides the client retry policy for requests using ctx.
+func WithRetryPolicy(ctx context.Context, p RetryPolicy) context.Context {
+	return context.WithValue(ctx, retryPolicyKey{}, p)
+}
+
+func retryPolicyFromContext(ctx context.Context) (RetryPolicy, bool) {
+	p, ok := ctx.Value(retryPolicyKey{}).(RetryPolicy)
+	return p, ok
+}
+
+// retryPolicyFromEnv builds the retry policy from RETRY_STATUS_CODES,
+// RETRY_ERROR_CLASSES and RETRY_ON_BREAKER_OPEN, starting from the defaults.
+func retryPolicyFromEnv(env configLookup) (RetryPolicy, error) {
+	p := DefaultRetryPolicy()
+
+	if v, ok := env("RETRY_STATUS_CODES"); ok {
+		codes, err := parseStatusCodes(v)
+		if err != nil {
+			return p, fmt.Errorf("RETRY_STATUS_CODES: %w", err)
+		}
+		p.StatusCodes = codes
+	}
+
+	if v, ok := env("RETRY_ERROR_CLASSES"); ok {
+		classes, err := parseErrorClasses(v)
+		if err != nil {
+			return p, fmt.Errorf("RETRY_ERROR_CLASSES: %w", err)
+		}
+		p.ErrorClasses = classes
+	}
+
+	if v := env.get("RETRY_ON_BREAKER_OPEN"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return p, fmt.Errorf("RETRY_ON_BREAKER_OPEN: %w", err)
+		}
+		p.RetryOnBreakerOpen = b
+	}
+
+	return p, nil
+}
+
+// retryConfigFromEnv reads RETRY_MAX_RETRIES, RETRY_BASE_DELAY_MS and
+// RETRY_MAX_DELAY_MS, and the retry policy.
+func retryConfigFromEnv(env configLookup) (RetryConfig, error) {
+	c := RetryConfig{MaxRetries: 3, BaseDelay: 200 * time.Millisecond, MaxDelay: 2 * time.Second}
+	if v := env.get("RETRY_MAX_RETRIES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return c, fmt.Errorf("RETRY_MAX_RETRIES must be a positive integer, got %q", v)
+		}
+		c.MaxRetries = n
+	}
+	for _, d := range []struct {
+		name string
+		dst  *time.Duration
+	}{{"RETRY_BASE_DELAY_MS", &c.BaseDelay}, {"RETRY_MAX_DELAY_MS", &c.MaxDelay}} {
+		if v := env.get(d.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				return c, fmt.Errorf("%s must be a positive integer, got %q", d.name, v)
+			}
+			*d.dst = time.Duration(n) * time.Millisecond
+		}
+	}
+	if c.BaseDelay > c.MaxDelay {
+		return c, fmt.Errorf("RETRY_BASE_DELAY_MS (%d) cannot exceed RETRY_MAX_DELAY_MS (%d)", c.BaseDelay.Milliseconds(), c.MaxDelay.Milliseconds())
+	}
+	policy, err := retryPolicyFromEnv(env)
+	if err != nil {
+		return c, err
+	}
+	c.Policy = policy
+	return c, nil
+}
+
+// parseStatusCodes accepts a comma separated list of codes and classes, e.g. "429,5xx".
+func parseStatusCodes(v string) (map[int]bool, error) {
+	codes := map[int]bool{}
+	for _, item := range strings.Split(v, ",") {
+		item = strings.ToLower(strings.TrimSpace(item))
+		if item == "" {
+			continue
+		}
+		if len(item) == 3 && strings.HasSuffix(item, "xx") {
+			class, err := strconv.Atoi(item[:1])
+			if err != nil || class < 1 || class > 5 {
+				return nil, fmt.Errorf("invalid status class %q", item)
+			}
+			for code := class * 100; code < (class+1)*100; code++ {
+				codes[code] = true
+			}
+			continue
+		}
+		code, err := strconv.Atoi(item)
+		if err != nil || code < 100 || code > 599 {
+			return nil, fmt.Errorf("invalid status code %q", item)
+		}
+		codes[code] = true
+	}
+	return codes, nil
+}
+
+func parseErrorClasses(v string) (map[RetryErrorClass]bool, error) {
+	classes := map[RetryErrorClass]bool{}
+	for _, item := range strings.Split(v, ",") {
+		class := RetryErrorClass(strings.ToLower(strings.TrimSpace(item)))
+		if class == "" {
+			continue
+		}
+		if !knownRetryErrorClasses[class] {
+			return nil, fmt.Errorf("unknown error class %q (known: %s)", class, strings.Join(knownClassNames(), ", "))
+		}
+		classes[class] = true
+	}
+	return classes, nil
+}
+
+func knownClassNames() []string {
+	names := make([]string, 0, len(knownRetryErrorClasses))
+	for class := range knownRetryErrorClasses {
+		names = append(names, string(class))
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/sony/gobreaker/v2"
+)
+
+// timeoutErr satisfies net.Error reporting a timeout.
+type timeoutErr struct{}
+
+func (timeoutErr) Error() string   { return "i/o timeout" }
+func (timeoutErr) Timeout() bool   { return true }
+func (timeoutErr) Temporary() bool { return true }
+
+func TestClassifyError(t *testing.T) {
+	cases := []struct {
+		err  error
+		want RetryErrorClass
+	}{
+		{&net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, ErrClassConnRefused},
+		{&net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, ErrClassConnReset},
+		{&net.DNSError{Err: "no such host", Name: "users-api"}, ErrClassDNS},
+		{fmt.Errorf("get: %w", context.Canceled), ErrClassContext},
+		{fmt.Errorf("get: %w", context.DeadlineExceeded), ErrClassContext},
+		{gobreaker.ErrOpenState, ErrClassBreakerOpen},
+		{&net.OpError{Op: "dial", Err: timeoutErr{}}, ErrClassTimeout},
+		{fmt.Errorf("net/http: TLS handshake timeout"), ErrClassTLSHandshake},
+		{fmt.Errorf("boom"), ErrClassOther},
+	}
+	for _, tc := range cases {
+		if got := classifyError(tc.err); got != tc.want {
+			t.Errorf("classifyError(%v) = %s, want %s", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestRetry_BreakerOpenNotRetriedByDefault(t *testing.T) {
+	fc := &fakeClient{seq: []fakeResp{
+		{err: gobreaker.ErrOpenState},
+		{resp: &http.Response{StatusCode: 200, Body: http.NoBody}},
+	}}
+	rc := newRetryHTTPClient(fc, RetryConfig{MaxRetries: 2, BaseDelay: 1 * time.Millisecond, MaxDelay: 2 * time.Millisecond})
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	if _, err := rc.Do(req); err != gobreaker.ErrOpenState {
+		t.Fatalf("expected open state error, got %v", err)
+	}
+	if fc.calls != 1 {
+		t.Fatalf("expected 1 call, got %d", fc.calls)
+	}
+}
+
+func TestRetry_BreakerServerErrorsFollowStatusCodes(t *testing.T) {
+	for _, tc := range []struct {
+		status int
+		want   int
+	}{{503, 2}, {404, 1}} {
+		fc := &fakeClient{seq: []fakeResp{
+			{err: &ServerError{StatusCode: tc.status}},
+			{resp: &http.Response{StatusCode: 200, Body: http.NoBody}},
+		}}
+		rc := newRetryHTTPClient(fc, RetryConfig{MaxRetries: 2, BaseDelay: 1 * time.Millisecond, MaxDelay: 2 * time.Millisecond})
+
+		policy := DefaultRetryPolicy()
+		policy.StatusCodes = map[int]bool{503: true}
+		req, _ := http.NewRequestWithContext(WithRetryPolicy(context.Background(), policy), http.MethodGet, "http://example.com", nil)
+		rc.Do(req)
+		if fc.calls != tc.want {
+			t.Errorf("status %d: expected %d calls, got %d", tc.status, tc.want, fc.calls)
+		}
+	}
+}
+
+func TestRetry_ContextPolicyOverride(t *testing.T) {
+	fc := &fakeClient{seq: []fakeResp{
+		{resp: &http.Response{StatusCode: 404, Body: http.NoBody}},
+		{resp: &http.Response{StatusCode: 200, Body: http.NoBody}},
+	}}
+	rc := newRetryHTTPClient(fc, RetryConfig{MaxRetries: 2, BaseDelay: 1 * time.Millisecond, MaxDelay: 2 * time.Millisecond})
+
+	policy := DefaultRetryPolicy()
+	policy.StatusCodes = map[int]bool{404: true}
+	ctx := WithRetryPolicy(context.Background(), policy)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://example.com", nil)
+	resp, err := rc.Do(req)
+	if err != nil || resp.StatusCode != 200 {
+		t.Fatalf("expected 404 to be retried, got resp=%v err=%v", resp, err)
+	}
+	if fc.calls != 2 {
+		t.Fatalf("expected 2 calls, got %d", fc.calls)
+	}
+}
+
+func TestRetryPolicyFromEnv(t *testing.T) {
+	t.Setenv("RETRY_STATUS_CODES", "429, 502,503")
+	t.Setenv("RETRY_ERROR_CLASSES", "conn_refused,dns")
+	t.Setenv("RETRY_ON_BREAKER_OPEN", "true")
+
+	p, err := retryPolicyFromEnv(os.LookupEnv)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !p.StatusCodes[502] || p.StatusCodes[500] || len(p.StatusCodes) != 3 {
+		t.Fatalf("unexpected status codes: %v", p.StatusCodes)
+	}
+	if !p.ErrorClasses[ErrClassDNS] || p.ErrorClasses[ErrClassTimeout] {
+		t.Fatalf("unexpected error classes: %v", p.ErrorClasses)
+	}
+	if !p.RetryOnBreakerOpen {
+		t.Fatalf("expected RetryOnBreakerOpen")
+	}
+
+	t.Setenv("RETRY_ERROR_CLASSES", "cosmic_rays")
+	if _, err := retryPolicyFromEnv(os.LookupEnv); err == nil {
+		t.Fatalf("expected error for unknown error class")
+	}
+}
@@ -66,4 +66,54 @@ func TestRetry_NetworkErrorRetried(t *testing.T) {
     }
 }
 
+func TestRetry_HookHeaderAndStats(t *testing.T) {
+    var seen []string
+    fc := &headerRecordingClient{fakeClient: fakeClient{seq: []fakeResp{
+        {resp: &http.Response{StatusCode: 503, Body: http.NoBody}},
+        {resp: &http.Response{StatusCode: 503, Body: http.NoBody}},
+    }}, seen: &seen}
 
+    var events, gaveUp []RetryEvent
+    attempts := 0
+    rc := newRetryHTTPClient(fc, RetryConfig{
+        MaxRetries: 1,
+        BaseDelay:  1 * time.Millisecond,
+        MaxDelay:   2 * time.Millisecond,
+        OnRetry:    func(req *http.Request, ev RetryEvent) { events = append(events, ev) },
+        OnAttempt:  func(req *http.Request, attempt int) { attempts++ },
+        OnGiveUp:   func(req *http.Request, ev RetryEvent) { gaveUp = append(gaveUp, ev) },
+    })
+    req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+    resp, err := rc.Do(req)
+    if err != nil || resp.StatusCode != 503 {
+        t.Fatalf("expected final 503, got resp=%v err=%v", resp, err)
+    }
+
+    if len(seen) != 2 || seen[0] != "1" || seen[1] != "2" {
+        t.Fatalf("unexpected attempt headers: %v", seen)
+    }
+    if len(events) != 1 || events[0].Attempt != 1 || events[0].Reason != "status_503" {
+        t.Fatalf("unexpected retry events: %+v", events)
+    }
+    if len(gaveUp) != 1 || gaveUp[0].Attempt != 2 || gaveUp[0].Reason != "status_503" || gaveUp[0].StatusCode != 503 {
+        t.Fatalf("unexpected give-up events: %+v", gaveUp)
+    }
+    stats := rc.Stats()
+    if uint64(attempts) != stats.Attempts {
+        t.Fatalf("OnAttempt saw %d attempts, stats report %d", attempts, stats.Attempts)
+    }
+    if stats.Attempts != 2 || stats.Retried != 1 || stats.GaveUp != 1 || stats.ByReason["status_503"] != 2 {
+        t.Fatalf("unexpected stats: %+v", stats)
+    }
+}
+
+// headerRecordingClient records the retry attempt header of every call.
+type headerRecordingClient struct {
+    fakeClient
+    seen *[]string
+}
+
+func (h *headerRecordingClient) Do(req *http.Request) (*http.Response, error) {
+    *h.seen = append(*h.seen, req.Header.Get(RetryAttemptHeader))
+    return h.fakeClient.Do(req)
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Sampling modes selectable with TRACING_SAMPLER.
+const (
+	SamplerAlways        = "always"
+	SamplerNever         = "never"
+	SamplerProbabilistic = "probabilistic"
+	SamplerRateLimited   = "rate_limited"
+)
+
+// SamplingConfig decides which traces are recorded and exported.
+type SamplingConfig struct {
+	// Mode is the sampler for root spans, SamplerAlways by default.
+	Mode string
+	// Ratio of traces kept by SamplerProbabilistic.
+	Ratio float64
+	// Rate of traces per second kept by SamplerRateLimited.
+	Rate float64
+	// ParentBased follows the sampling decision of the upstream caller.
+	ParentBased bool
+	// SampleErrors also exports traces dropped by the sampler when one of
+	// their spans in this process ends with an error.
+	SampleErrors bool
+	// DebugHeader forces sampling of a request carrying it; empty, the
+	// default, disables it. Forced traces are limited to Rate per second.
+	DebugHeader string
+}
+
+// samplingConfigFromEnv reads TRACING_SAMPLER, TRACING_SAMPLER_RATIO,
+// TRACING_SAMPLER_RATE, TRACING_SAMPLER_PARENT_BASED, TRACING_SAMPLE_ERRORS
+// and TRACING_DEBUG_HEADER.
+func samplingConfigFromEnv(env configLookup) (SamplingConfig, error) {
+	c := SamplingConfig{
+		Mode:        SamplerAlways,
+		Ratio:       0.1,
+		Rate:        10,
+		ParentBased: true,
+	}
+	if v := env.get("TRACING_SAMPLER"); v != "" {
+		c.Mode = strings.ToLower(v)
+	}
+	switch c.Mode {
+	case SamplerAlways, SamplerNever, SamplerProbabilistic, SamplerRateLimited:
+	default:
+		return c, fmt.Errorf("unknown TRACING_SAMPLER %q", c.Mode)
+	}
+
+	if v := env.get("TRACING_SAMPLER_RATIO"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < 0 || f > 1 {
+			return c, fmt.Errorf("TRACING_SAMPLER_RATIO must be between 0 and 1, got %q", v)
+		}
+		c.Ratio = f
+	}
+	if v := env.get("TRACING_SAMPLER_RATE"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f <= 0 {
+			return c, fmt.Errorf("TRACING_SAMPLER_RATE must be a positive number, got %q", v)
+		}
+		c.Rate = f
+	}
+	if v := env.get("TRACING_SAMPLER_PARENT_BASED"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return c, fmt.Errorf("TRACING_SAMPLER_PARENT_BASED: %w", err)
+		}
+		c.ParentBased = b
+	}
+	if v := env.get("TRACING_SAMPLE_ERRORS"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return c, fmt.Errorf("TRACING_SAMPLE_ERRORS: %w", err)
+		}
+		c.SampleErrors = b
+	}
+	c.DebugHeader = env.get("TRACING_DEBUG_HEADER")
+	return c, nil
+}
+
+// Sampler builds the head sampler described by the config.
+func (c SamplingConfig) Sampler() sdktrace.Sampler {
+	// forced traces share the rate limit of SamplerRateLimited, so the
+	// debug header cannot raise the volume of traces over it
+	limit := newRateLimitingSampler(c.Rate)
+	var root sdktrace.Sampler
+	switch c.Mode {
+	case SamplerNever:
+		root = sdktrace.NeverSample()
+	case SamplerProbabilistic:
+		root = sdktrace.TraceIDRatioBased(c.Ratio)
+	case SamplerRateLimited:
+		root = limit
+	default:
+		root = sdktrace.AlwaysSample()
+	}
+	if c.ParentBased {
+		root = sdktrace.ParentBased(root)
+	}
+
+	var s sdktrace.Sampler = forcedSampler{base: root, limit: limit}
+	if c.SampleErrors {
+		s = recordDroppedSampler{base: s}
+	}
+	return s
+}
+
+type forcedSamplingKey struct{}
+
+// withForcedSampling makes every span started from ctx sampled.
+func withForcedSampling(ctx context.Context) context.Context {
+	return context.WithValue(ctx, forcedSamplingKey{}, true)
+}
+
+// debugSamplingMiddleware forces sampling of requests carrying header.
+func debugSamplingMiddleware(header string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if v := r.Header.Get(header); v != "" && v != "0" && !strings.EqualFold(v, "false") {
+			r = r.WithContext(withForcedSampling(r.Context()))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// forcedSampler samples spans started from a context marked by
+// withForcedSampling, as long as limit has a token for the trace, and defers
+// to base otherwise. Spans below a local sampled parent cost no token.
+type forcedSampler struct {
+	base  sdktrace.Sampler
+	limit *rateLimitingSampler
+}
+
+func (s forcedSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	if forced, _ := p.ParentContext.Value(forcedSamplingKey{}).(bool); forced {
+		parent := trace.SpanContextFromContext(p.ParentContext)
+		if (parent.IsSampled() && !parent.IsRemote()) || s.limit.take() {
+			return sdktrace.SamplingResult{
+				Decision:   sdktrace.RecordAndSample,
+				Tracestate: parent.TraceState(),
+			}
+		}
+	}
+	return s.base.ShouldSample(p)
+}
+
+func (s forcedSampler) Description() string {
+	return "Forced{" + s.base.Description() + "}"
+}
+
+// recordDroppedSampler records the spans base would drop so errorSpanProcessor
+// can still export the traces that end up failing.
+type recordDroppedSampler struct {
+	base sdktrace.Sampler
+}
+
+func (s recordDroppedSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	r := s.base.ShouldSample(p)
+	if r.Decision == sdktrace.Drop {
+		r.Decision = sdktrace.RecordOnly
+	}
+	return r
+}
+
+func (s recordDroppedSampler) Description() string {
+	return "RecordDropped{" + s.base.Description() + "}"
+}
+
+// rateLimitingSampler samples at most rate traces per second.
+type rateLimitingSampler struct {
+	rate float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+func newRateLimitingSampler(rate float64) *rateLimitingSampler {
+	return &rateLimitingSampler{rate: rate, tokens: rate, last: time.Now(), now: time.Now}
+}
+
+func (s *rateLimitingSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	ts := trace.SpanContextFromContext(p.ParentContext).TraceState()
+	if !s.take() {
+		return sdktrace.SamplingResult{Decision: sdktrace.Drop, Tracestate: ts}
+	}
+	return sdktrace.SamplingResult{Decision: sdktrace.RecordAndSample, Tracestate: ts}
+}
+
+// take spends a token if one is available.
+func (s *rateLimitingSampler) take() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	s.tokens += now.Sub(s.last).Seconds() * s.rate
+	if s.tokens > s.rate {
+		// allow bursts of at most one second worth of traces
+		s.tokens = s.rate
+	}
+	s.last = now
+	if s.tokens < 1 {
+		return false
+	}
+	s.tokens--
+	return true
+}
+
+func (s *rateLimitingSampler) Description() string {
+	return fmt.Sprintf("RateLimiting{%g/s}", s.rate)
+}
+
+// errorSpanProcessor buffers the recorded but unsampled spans of each trace
+// until its local root ends, and hands them to next as sampled when any of
+// them failed. Sampled spans go straight to next. Spans ending after their
+// local root are buffered until maxAge and then evicted.
+type errorSpanProcessor struct {
+	next      sdktrace.SpanProcessor
+	maxTraces int
+	maxSpans  int
+	maxAge    time.Duration
+	now       func() time.Time
+
+	mu     sync.Mutex
+	traces map[trace.TraceID]*bufferedTrace
+}
+
+type bufferedTrace struct {
+	spans     []sdktrace.ReadOnlySpan
+	failed    bool
+	firstSeen time.Time
+}
+
+func newErrorSpanProcessor(next sdktrace.SpanProcessor) *errorSpanProcessor {
+	return &errorSpanProcessor{
+		next:      next,
+		maxTraces: 1000,
+		maxSpans:  256,
+		maxAge:    time.Minute,
+		now:       time.Now,
+		traces:    map[trace.TraceID]*bufferedTrace{},
+	}
+}
+
+func (p *errorSpanProcessor) OnStart(parent context.Context, s sdktrace.ReadWriteSpan) {
+	p.next.OnStart(parent, s)
+}
+
+func (p *errorSpanProcessor) OnEnd(s sdktrace.ReadOnlySpan) {
+	if s.SpanContext().IsSampled() {
+		p.next.OnEnd(s)
+		return
+	}
+
+	id := s.SpanContext().TraceID()
+	localRoot := !s.Parent().IsValid() || s.Parent().IsRemote()
+
+	p.mu.Lock()
+	t, ok := p.traces[id]
+	if !ok {
+		if len(p.traces) >= p.maxTraces {
+			// make room by evicting the traces whose root ended long ago
+			p.mu.Unlock()
+			p.evictStale()
+			p.mu.Lock()
+		}
+		if len(p.traces) >= p.maxTraces {
+			// too many traces in flight: drop this one as the sampler asked
+			p.mu.Unlock()
+			return
+		}
+		t = &bufferedTrace{firstSeen: p.now()}
+		p.traces[id] = t
+	}
+	if len(t.spans) < p.maxSpans {
+		t.spans = append(t.spans, s)
+	}
+	t.failed = t.failed || s.Status().Code == codes.Error
+	if !localRoot {
+		p.mu.Unlock()
+		return
+	}
+	delete(p.traces, id)
+	p.mu.Unlock()
+	p.export(t)
+}
+
+// export hands the spans of a failed trace to next.
+func (p *errorSpanProcessor) export(t *bufferedTrace) {
+	if t.failed {
+		for _, span := range t.spans {
+			p.next.OnEnd(sampledSpan{span})
+		}
+	}
+}
+
+// evictStale drops the traces buffered for longer than maxAge, exporting the
+// failed ones. They are made of spans that ended after their local root, so
+// no root will come to flush them.
+func (p *errorSpanProcessor) evictStale() {
+	var stale []*bufferedTrace
+	p.mu.Lock()
+	now := p.now()
+	for id, t := range p.traces {
+		if now.Sub(t.firstSeen) >= p.maxAge {
+			stale = append(stale, t)
+			delete(p.traces, id)
+		}
+	}
+	p.mu.Unlock()
+	for _, t := range stale {
+		p.export(t)
+	}
+}
+
+func (p *errorSpanProcessor) Shutdown(ctx context.Context) error {
+	return p.next.Shutdown(ctx)
+}
+
+func (p *errorSpanProcessor) ForceFlush(ctx context.Context) error {
+	p.evictStale()
+	return p.next.ForceFlush(ctx)
+}
+
+// sampledSpan reports a recorded span as sampled so exporters accept it.
+type sampledSpan struct {
+	sdktrace.ReadOnlySpan
+}
+
+func (s sampledSpan) SpanContext() trace.SpanContext {
+	sc := s.ReadOnlySpan.SpanContext()
+	return sc.WithTraceFlags(sc.TraceFlags().WithSampled(true))
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func newSampledProvider(c SamplingConfig) (*sdktrace.TracerProvider, *tracetest.InMemoryExporter) {
+	exporter := tracetest.NewInMemoryExporter()
+	var processor sdktrace.SpanProcessor = sdktrace.NewSimpleSpanProcessor(exporter)
+	if c.SampleErrors {
+		processor = newErrorSpanProcessor(processor)
+	}
+	return sdktrace.NewTracerProvider(sdktrace.WithSampler(c.Sampler()), sdktrace.WithSpanProcessor(processor)), exporter
+}
+
+func remoteParent(sampled bool) context.Context {
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1},
+		SpanID:     trace.SpanID{1},
+		TraceFlags: trace.TraceFlags(0).WithSampled(sampled),
+		Remote:     true,
+	})
+	return trace.ContextWithRemoteSpanContext(context.Background(), sc)
+}
+
+func TestSampling_ParentBasedHonorsUpstream(t *testing.T) {
+	provider, exporter := newSampledProvider(SamplingConfig{Mode: SamplerProbabilistic, Ratio: 0, ParentBased: true})
+	tracer := provider.Tracer("test")
+
+	_, root := tracer.Start(context.Background(), "root")
+	root.End()
+	_, child := tracer.Start(remoteParent(true), "sampled upstream")
+	child.End()
+	_, dropped := tracer.Start(remoteParent(false), "unsampled upstream")
+	dropped.End()
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 || spans[0].Name != "sampled upstream" {
+		t.Fatalf("expected only the upstream-sampled span, got %v", spans)
+	}
+}
+
+func TestSampling_RateLimited(t *testing.T) {
+	s := newRateLimitingSampler(2)
+	now := time.Now()
+	s.last, s.now = now, func() time.Time { return now }
+	p := sdktrace.SamplingParameters{ParentContext: context.Background()}
+
+	for i, want := range []sdktrace.SamplingDecision{sdktrace.RecordAndSample, sdktrace.RecordAndSample, sdktrace.Drop} {
+		if got := s.ShouldSample(p).Decision; got != want {
+			t.Fatalf("call %d: expected %v, got %v", i, want, got)
+		}
+	}
+	now = now.Add(500 * time.Millisecond)
+	if got := s.ShouldSample(p).Decision; got != sdktrace.RecordAndSample {
+		t.Fatalf("expected a token after half a second, got %v", got)
+	}
+}
+
+func TestSampling_DebugHeaderForcesSampling(t *testing.T) {
+	c := SamplingConfig{Mode: SamplerNever, Rate: 10, ParentBased: true, DebugHeader: "X-Debug-Trace"}
+	provider, exporter := newSampledProvider(c)
+	tr := &tracing{provider: provider, propagator: newPropagator(), debugHeader: c.DebugHeader}
+	handler := tr.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/login", nil))
+	if n := len(exporter.GetSpans()); n != 0 {
+		t.Fatalf("expected no spans without the debug header, got %d", n)
+	}
+
+	req := httptest.NewRequest("POST", "/login", nil)
+	req.Header.Set("X-Debug-Trace", "1")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if n := len(exporter.GetSpans()); n != 1 {
+		t.Fatalf("expected the debug request to be sampled, got %d spans", n)
+	}
+}
+
+func TestSampling_DebugHeaderIsRateLimited(t *testing.T) {
+	c := SamplingConfig{Mode: SamplerNever, Rate: 1, ParentBased: true, DebugHeader: "X-Debug-Trace"}
+	provider, exporter := newSampledProvider(c)
+	tr := &tracing{provider: provider, propagator: newPropagator(), debugHeader: c.DebugHeader}
+	handler := tr.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, child := provider.Tracer("test").Start(r.Context(), "GET /users/{username}")
+		child.End()
+	}))
+
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest("POST", "/login", nil)
+		req.Header.Set("X-Debug-Trace", "1")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	if n := len(exporter.GetSpans()); n != 2 {
+		t.Fatalf("expected only the first debug trace (server and child span), got %d spans", n)
+	}
+}
+
+func TestSampling_ErrorsAreAlwaysExported(t *testing.T) {
+	provider, exporter := newSampledProvider(SamplingConfig{Mode: SamplerNever, ParentBased: true, SampleErrors: true})
+	tracer := provider.Tracer("test")
+
+	ctx, root := tracer.Start(context.Background(), "ok login")
+	_, child := tracer.Start(ctx, "GET /users/{username}")
+	child.End()
+	root.End()
+	if n := len(exporter.GetSpans()); n != 0 {
+		t.Fatalf("expected successful trace to be dropped, got %d spans", n)
+	}
+
+	ctx, root = tracer.Start(context.Background(), "failed login")
+	_, child = tracer.Start(ctx, "GET /users/{username}")
+	child.RecordError(errors.New("connection refused"))
+	child.SetStatus(codes.Error, "connection refused")
+	child.End()
+	root.End()
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("expected the failed trace to be exported, got %d spans", len(spans))
+	}
+	for _, s := range spans {
+		if !s.SpanContext.IsSampled() {
+			t.Errorf("expected exported span %q to be marked sampled", s.Name)
+		}
+	}
+}
+
+func TestSampling_LateSpansAreEvicted(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	processor := newErrorSpanProcessor(sdktrace.NewSimpleSpanProcessor(exporter))
+	processor.maxTraces = 2
+	now := time.Now()
+	processor.now = func() time.Time { return now }
+	c := SamplingConfig{Mode: SamplerNever, ParentBased: true, SampleErrors: true}
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSampler(c.Sampler()), sdktrace.WithSpanProcessor(processor)).Tracer("test")
+
+	// children ending after their root leave their traces buffered
+	for i := 0; i < processor.maxTraces; i++ {
+		ctx, root := tracer.Start(context.Background(), "login")
+		_, child := tracer.Start(ctx, "hedged GET /users/{username}")
+		root.End()
+		child.End()
+	}
+	now = now.Add(processor.maxAge)
+
+	ctx, root := tracer.Start(context.Background(), "failed login")
+	_, child := tracer.Start(ctx, "GET /users/{username}")
+	child.SetStatus(codes.Error, "connection refused")
+	child.End()
+	root.End()
+	if n := len(exporter.GetSpans()); n != 2 {
+		t.Fatalf("expected stale traces to make room for the failed one, got %d spans", n)
+	}
+	if n := len(processor.traces); n != 0 {
+		t.Fatalf("expected no trace left buffered, got %d", n)
+	}
+}
+
+func TestSamplingConfigFromEnv(t *testing.T) {
+	t.Setenv("TRACING_SAMPLER", "rate_limited")
+	t.Setenv("TRACING_SAMPLER_RATE", "5")
+
+	c, err := samplingConfigFromEnv(os.LookupEnv)
+	if err != nil || c.Mode != SamplerRateLimited || c.Rate != 5 || !c.ParentBased || c.DebugHeader != "" {
+		t.Fatalf("unexpected config %+v err=%v", c, err)
+	}
+
+	t.Setenv("TRACING_SAMPLER_RATIO", "1.5")
+	if _, err := samplingConfigFromEnv(os.LookupEnv); err == nil {
+		t.Fatal("expected an error for a ratio above 1")
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/middleware"
+)
+
+// Modes of auth-api. Production refuses to start with a missing, well-known
+// or weak JWT secret; development only warns.
+const (
+	ModeDevelopment = "development"
+	ModeProduction  = "production"
+)
+
+// defaultJWTSecret is the development signing key todos-api and users-api
+// also default to. It is published, so production refuses it.
+const defaultJWTSecret = "myfancysecret"
+
+// DefaultJWTSecretMinBits is the estimated entropy a JWT secret needs.
+const DefaultJWTSecretMinBits = 96
+
+// knownDefaultSecrets are signing keys found in this repository and in
+// tutorials; they are refused in production whatever their entropy.
+var knownDefaultSecrets = []string{
+	defaultJWTSecret,
+	"secret",
+	"changeme",
+	"change-me",
+	"jwtsecret",
+	"jwt-secret",
+	"mysecret",
+	"supersecret",
+	"your-256-bit-secret",
+	"your-secret-key",
+}
+
+const redacted = "[redacted]"
+
+// Secret is a value that must not leak: it prints, marshals and logs as
+// [redacted]. Reveal returns the value itself.
+type Secret string
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+func (s Secret) GoString() string {
+	return strconv.Quote(s.String())
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+func (s Secret) LogValue() slog.Value {
+	return slog.StringValue(s.String())
+}
+
+// Reveal returns the secret value, for the code that uses it.
+func (s Secret) Reveal() string {
+	return string(s)
+}
+
+func modeFromEnv(env configLookup) (string, error) {
+	switch v := env.get("AUTH_API_MODE"); v {
+	case "":
+		return ModeDevelopment, nil
+	case ModeDevelopment, ModeProduction:
+		return v, nil
+	default:
+		return ModeDevelopment, fmt.Errorf("AUTH_API_MODE must be %s or %s, got %q", ModeDevelopment, ModeProduction, v)
+	}
+}
+
+// signingKey holds the JWT secret, replaced when the configuration is
+// reloaded: tokens are signed and checked with the current one.
+type signingKey struct {
+	secret atomic.Pointer[string]
+	check  atomic.Pointer[echo.MiddlewareFunc]
+}
+
+func newSigningKey(secret string) *signingKey {
+	k := &signingKey{}
+	k.Set(secret)
+	return k
+}
+
+// Get returns the current secret.
+func (k *signingKey) Get() string {
+	return *k.secret.Load()
+}
+
+// Set replaces the secret; tokens signed with the previous one are refused
+// from the next request.
+func (k *signingKey) Set(secret string) {
+	check := middleware.JWT([]byte(secret))
+	k.secret.Store(&secret)
+	k.check.Store(&check)
+}
+
+// Middleware validates the bearer token with the current secret.
+func (k *signingKey) Middleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return (*k.check.Load())(next)(c)
+	}
+}
+
+// jwtSecretFromEnv returns the JWT signing key, the development default when
+// JWT_SECRET is not set. A missing, well-known or weak key is an error in
+// production and a warning in development.
+func jwtSecretFromEnv(env configLookup, mode string) (secret Secret, warning string, err error) {
+	minBits := DefaultJWTSecretMinBits
+	if v := env.get("JWT_SECRET_MIN_BITS"); v != "" {
+		if minBits, err = strconv.Atoi(v); err != nil || minBits < 0 {
+			return "", "", fmt.Errorf("JWT_SECRET_MIN_BITS must be a non-negative integer, got %q", v)
+		}
+	}
+
+	v := env.get("JWT_SECRET")
+	var problem string
+	switch {
+	case v == "":
+		if mode == ModeProduction {
+			return "", "", errors.New("JWT_SECRET or JWT_SECRET_FILE is required in production")
+		}
+		return defaultJWTSecret, "JWT_SECRET is not set, signing with the development default", nil
+	case isKnownDefaultSecret(v):
+		problem = "JWT_SECRET is a well-known default"
+	default:
+		if bits := secretEntropyBits(v); bits < float64(minBits) {
+			problem = fmt.Sprintf("JWT_SECRET is too weak: about %.0f bits of entropy, at least %d (JWT_SECRET_MIN_BITS) needed", math.Floor(bits), minBits)
+		}
+	}
+	switch {
+	case problem == "":
+		return Secret(v), "", nil
+	case mode == ModeProduction:
+		return "", "", errors.New(problem + ", refusing it in production")
+	default:
+		return Secret(v), problem, nil
+	}
+}
+
+func isKnownDefaultSecret(v string) bool {
+	v = strings.ToLower(strings.TrimSpace(v))
+	for _, s := range knownDefaultSecrets {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// secretEntropyBits estimates the entropy of v from the frequency of its
+// bytes (Shannon entropy times length). It underrates random keys a little
+// and catches short, repetitive or dictionary-like ones.
+func secretEntropyBits(v string) float64 {
+	if v == "" {
+		return 0
+	}
+	var counts [256]int
+	for i := 0; i < len(v); i++ {
+		counts[v[i]]++
+	}
+	n := float64(len(v))
+	var perByte float64
+	for _, c := range counts {
+		if c > 0 {
+			p := float64(c) / n
+			perByte -= p * math.Log2(p)
+		}
+	}
+	return perByte * n
+}
+
+// readSecretFile reads a secret mounted as a file, e.g. by Docker or
+// Kubernetes, dropping the trailing newline editors and echo add.
+func readSecretFile(key, path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", key, err)
+	}
+	v := strings.TrimRight(string(data), "\r\n")
+	if v == "" {
+		return "", fmt.Errorf("%s: %s is empty", key, path)
+	}
+	return v, nil
+}
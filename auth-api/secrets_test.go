@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo"
+)
+
+const strongSecret = "q8Zr2vN0xWf5LkT7pYc3HsJ9dA1mEgUb"
+
+func writeSecretFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig_ReadsSecretFiles(t *testing.T) {
+	env := mapLookup(map[string]string{
+		"USERS_API_ADDRESS": "http://users-api:8083",
+		"AUTH_API_MODE":     ModeProduction,
+		"JWT_SECRET_FILE":   writeSecretFile(t, strongSecret+"\n"),
+	})
+	cfg, err := loadConfig(nil, env, io.Discard)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.JWTSecret.Reveal() != strongSecret {
+		t.Fatalf("expected the file content without its newline, got %d bytes", len(cfg.JWTSecret))
+	}
+	for _, s := range cfg.Settings() {
+		if s.Key == "JWT_SECRET" && (s.Source != SourceSecretFile || s.Value != "[redacted]") {
+			t.Fatalf("unexpected secret setting %+v", s)
+		}
+	}
+
+	env = mapLookup(map[string]string{
+		"USERS_API_ADDRESS": "http://users-api:8083",
+		"JWT_SECRET":        strongSecret,
+		"JWT_SECRET_FILE":   writeSecretFile(t, strongSecret),
+	})
+	if _, err := loadConfig(nil, env, io.Discard); err == nil || !strings.Contains(err.Error(), "set only one of JWT_SECRET and JWT_SECRET_FILE") {
+		t.Fatalf("expected a secret set twice to be rejected, got %v", err)
+	}
+	env = mapLookup(map[string]string{
+		"USERS_API_ADDRESS": "http://users-api:8083",
+		"JWT_SECRET_FILE":   writeSecretFile(t, "\n"),
+	})
+	if _, err := loadConfig(nil, env, io.Discard); err == nil || !strings.Contains(err.Error(), "is empty") {
+		t.Fatalf("expected an empty secret file to be rejected, got %v", err)
+	}
+}
+
+func TestLoadConfig_ProductionRefusesWeakSecrets(t *testing.T) {
+	for name, secret := range map[string]string{
+		"unset":   "",
+		"default": "myfancysecret",
+		"known":   "ChangeMe",
+		"weak":    "abcabcabcabcabcabcabc",
+	} {
+		t.Run(name, func(t *testing.T) {
+			vars := map[string]string{"USERS_API_ADDRESS": "http://users-api:8083", "AUTH_API_MODE": ModeProduction}
+			if secret != "" {
+				vars["JWT_SECRET"] = secret
+			}
+			_, err := loadConfig(nil, mapLookup(vars), io.Discard)
+			if err == nil || !strings.Contains(err.Error(), "JWT_SECRET") {
+				t.Fatalf("expected the secret to be refused, got %v", err)
+			}
+			if secret != "" && strings.Contains(err.Error(), secret) {
+				t.Fatalf("the secret leaked in %v", err)
+			}
+
+			vars["AUTH_API_MODE"] = ModeDevelopment
+			cfg, err := loadConfig(nil, mapLookup(vars), io.Discard)
+			if err != nil {
+				t.Fatalf("expected development mode to start, got %v", err)
+			}
+			if len(cfg.Warnings) != 1 || cfg.JWTSecret == "" {
+				t.Fatalf("expected a warning and a signing key, got %q", cfg.Warnings)
+			}
+		})
+	}
+
+	env := mapLookup(map[string]string{"USERS_API_ADDRESS": "http://users-api:8083", "AUTH_API_MODE": ModeProduction, "JWT_SECRET": strongSecret})
+	cfg, err := loadConfig(nil, env, io.Discard)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.Warnings) != 0 {
+		t.Fatalf("unexpected warnings %q", cfg.Warnings)
+	}
+}
+
+func TestSecretEntropyBits(t *testing.T) {
+	if bits := secretEntropyBits(strings.Repeat("a", 64)); bits != 0 {
+		t.Fatalf("expected a repeated byte to have no entropy, got %f", bits)
+	}
+	if bits := secretEntropyBits("myfancysecret"); bits >= DefaultJWTSecretMinBits {
+		t.Fatalf("expected the development default to be weak, got %f", bits)
+	}
+	if bits := secretEntropyBits(strongSecret); bits < DefaultJWTSecretMinBits {
+		t.Fatalf("expected a random key to be strong, got %f", bits)
+	}
+}
+
+func TestSecret_NeverPrinted(t *testing.T) {
+	env := mapLookup(map[string]string{"USERS_API_ADDRESS": "http://users-api:8083", "JWT_SECRET": strongSecret})
+	cfg, err := loadConfig(nil, env, io.Discard)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "%v %+v %#v %s %q ", cfg, cfg, cfg, cfg.JWTSecret, cfg.JWTSecret)
+	json.NewEncoder(&out).Encode(cfg)
+	slog.New(slog.NewJSONHandler(&out, nil)).Info("config", "secret", cfg.JWTSecret)
+	slog.New(slog.NewTextHandler(&out, nil)).Info("config", "config", cfg, "ptr", &cfg)
+	if strings.Contains(out.String(), strongSecret) {
+		t.Fatalf("the secret leaked in %s", out.String())
+	}
+}
+
+func TestConfigReloader_WatchesSecretFiles(t *testing.T) {
+	accounts := writeSecretFile(t, "ops:"+strings.Repeat("ab", 32)+":ADMIN\n")
+	r, _ := newReloadTest(t, "usersApi.address: http://users-api:8083\nbreakGlass.accountsFile: "+accounts+"\n")
+	applied := make(chan map[string]breakGlassAccount, 1)
+	r.OnReload(ReloadCredentials, func(c *Config) { applied <- c.BreakGlass })
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go r.Watch(nil, 10*time.Millisecond, stop)
+
+	os.WriteFile(accounts, []byte("oncall:"+strings.Repeat("cd", 32)+":ADMIN\n"), 0o600)
+	select {
+	case got := <-applied:
+		if _, ok := got["oncall"]; !ok || len(got) != 1 {
+			t.Fatalf("expected the rotated accounts, got %v", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected the secret file change to be applied, status %+v", r.Status())
+	}
+}
+
+func TestSigningKey_ChecksTokensWithCurrentSecret(t *testing.T) {
+	key := newSigningKey("first-" + strongSecret)
+	e := echo.New()
+	e.GET("/admin/ping", func(c echo.Context) error { return c.NoContent(http.StatusOK) }, key.Middleware)
+	get := func(secret string) int {
+		token, _ := jwt.New(jwt.SigningMethodHS256).SignedString([]byte(secret))
+		req := httptest.NewRequest(http.MethodGet, "/admin/ping", nil)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := get("first-" + strongSecret); code != http.StatusOK {
+		t.Fatalf("expected a token signed with the secret to be accepted, got %d", code)
+	}
+
+	key.Set("second-" + strongSecret)
+	if key.Get() != "second-"+strongSecret {
+		t.Fatal("expected tokens to be signed with the new secret")
+	}
+	if code := get("first-" + strongSecret); code != http.StatusUnauthorized {
+		t.Fatalf("expected tokens signed with the old secret to be refused, got %d", code)
+	}
+	if code := get("second-" + strongSecret); code != http.StatusOK {
+		t.Fatalf("expected tokens signed with the new secret to be accepted, got %d", code)
+	}
+}
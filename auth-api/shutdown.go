@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+// Exit codes of the service.
+const (
+	// ExitOK is a clean stop after a signal.
+	ExitOK = 0
+	// ExitServerError means the HTTP server could not start or failed.
+	ExitServerError = 1
+	// ExitShutdownIncomplete means the shutdown deadline cut in-flight
+	// requests or a flush step failed.
+	ExitShutdownIncomplete = 2
+	// ExitInvalidConfig means the configuration was rejected at startup.
+	ExitInvalidConfig = 3
+)
+
+// ShutdownConfig times the graceful shutdown.
+type ShutdownConfig struct {
+	// DrainPeriod is how long readiness fails before the server stops
+	// accepting connections, so load balancers stop routing to it first.
+	DrainPeriod time.Duration
+	// Timeout bounds the wait for in-flight requests and the flushes.
+	Timeout time.Duration
+}
+
+// shutdownConfigFromEnv reads SHUTDOWN_DRAIN_SECONDS and SHUTDOWN_TIMEOUT_SECONDS.
+func shutdownConfigFromEnv(env configLookup) (ShutdownConfig, error) {
+	c := ShutdownConfig{DrainPeriod: 5 * time.Second, Timeout: 20 * time.Second}
+	if v := env.get("SHUTDOWN_DRAIN_SECONDS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return c, fmt.Errorf("SHUTDOWN_DRAIN_SECONDS must be a non-negative integer, got %q", v)
+		}
+		c.DrainPeriod = time.Duration(n) * time.Second
+	}
+	if v := env.get("SHUTDOWN_TIMEOUT_SECONDS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return c, fmt.Errorf("SHUTDOWN_TIMEOUT_SECONDS must be a positive integer, got %q", v)
+		}
+		c.Timeout = time.Duration(n) * time.Second
+	}
+	return c, nil
+}
+
+// shutdownStep is one stage of the graceful shutdown.
+type shutdownStep struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+// gracefulShutdown fails readiness through drain, waits DrainPeriod (or until
+// abort is closed, e.g. by a second signal) and runs steps in order within
+// Timeout. Every step runs even when an earlier one failed, so spans and
+// events are flushed after an incomplete drain. It returns the exit code.
+func gracefulShutdown(cfg ShutdownConfig, logger *slog.Logger, drain func(), abort <-chan struct{}, steps ...shutdownStep) int {
+	drain()
+	if cfg.DrainPeriod > 0 {
+		logger.Info("draining before shutdown", "drain_period", cfg.DrainPeriod.String())
+		select {
+		case <-time.After(cfg.DrainPeriod):
+		case <-abort:
+			logger.Warn("drain period cut short")
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+	defer cancel()
+
+	code := ExitOK
+	for _, step := range steps {
+		start := time.Now()
+		if err := step.Run(ctx); err != nil {
+			logger.Error("shutdown step failed", "step", step.Name, "error", err)
+			code = ExitShutdownIncomplete
+			continue
+		}
+		logger.Info("shutdown step done", "step", step.Name, "duration_ms", time.Since(start).Milliseconds())
+	}
+	return code
+}
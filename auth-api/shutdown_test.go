@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sony/gobreaker/v2"
+)
+
+func TestGracefulShutdown_RunsEveryStepInOrder(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	checker := newHealthChecker(HealthConfig{})
+	abort := make(chan struct{})
+	close(abort)
+
+	var order []string
+	step := func(name string, err error) shutdownStep {
+		return shutdownStep{name, func(ctx context.Context) error {
+			if _, ok := ctx.Deadline(); !ok {
+				t.Errorf("step %s has no deadline", name)
+			}
+			order = append(order, name)
+			return err
+		}}
+	}
+
+	start := time.Now()
+	code := gracefulShutdown(ShutdownConfig{DrainPeriod: time.Hour, Timeout: time.Second}, logger, checker.SetDraining, abort,
+		step("http server", nil), step("breaker webhooks", errors.New("deadline exceeded")), step("tracing", nil))
+
+	if time.Since(start) > time.Second {
+		t.Fatal("expected the closed abort channel to cut the drain period")
+	}
+	if code != ExitShutdownIncomplete {
+		t.Fatalf("expected exit code %d after a failed step, got %d", ExitShutdownIncomplete, code)
+	}
+	if len(order) != 3 || order[0] != "http server" || order[2] != "tracing" {
+		t.Fatalf("expected every step to run in order, got %v", order)
+	}
+	if r := checker.Report(context.Background()); r.Status != HealthNotReady {
+		t.Fatalf("expected readiness to fail while draining, got %+v", r)
+	}
+}
+
+func TestEventStream_CloseEndsSubscriptions(t *testing.T) {
+	events := newEventStream()
+	ch, cancel := events.Subscribe(1)
+	events.Close()
+	if _, ok := <-ch; ok {
+		t.Fatal("expected the subscription channel to be closed")
+	}
+	cancel() // must not close the channel twice
+	events.Publish(AuthEvent{Type: EventAudit})
+
+	late, _ := events.Subscribe(1)
+	if _, ok := <-late; ok {
+		t.Fatal("expected subscriptions after Close to be closed")
+	}
+}
+
+func TestBreakerNotifier_CloseDeliversQueuedEvents(t *testing.T) {
+	var delivered int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&delivered, 1)
+	}))
+	defer srv.Close()
+
+	n := newBreakerNotifier(nil, nil, []string{srv.URL})
+	n.OnStateChange("users-api-breaker", gobreaker.StateClosed, gobreaker.StateOpen)
+	n.OnStateChange("users-api-breaker", gobreaker.StateOpen, gobreaker.StateHalfOpen)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := n.Close(ctx); err != nil {
+		t.Fatalf("unexpected close error: %v", err)
+	}
+	if got := atomic.LoadInt32(&delivered); got != 2 {
+		t.Fatalf("expected both events delivered before Close returned, got %d", got)
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sony/gobreaker/v2"
+)
+
+// windowBucket aggregates the calls finished during one slice of the window.
+type windowBucket struct {
+	epoch    int64
+	calls    uint32
+	failures uint32
+	slow     uint32
+}
+
+// WindowStats summarizes the calls inside the rolling window.
+type WindowStats struct {
+	Calls       uint32  `json:"calls"`
+	Failures    uint32  `json:"failures"`
+	SlowCalls   uint32  `json:"slowCalls"`
+	FailureRate float64 `json:"failureRate"`
+	SlowRate    float64 `json:"slowRate"`
+}
+
+// slidingWindowBreaker is a circuit breaker over a rolling, time-bucketed
+// window. It trips on failure rate or slow-call rate once MinRequests calls
+// were seen in the window, and closes again after HalfOpenSuccesses good probes.
+type slidingWindowBreaker struct {
+	name  string
+	s     BreakerSettings
+	width time.Duration
+	now   func() time.Time
+
+	mu         sync.Mutex
+	state      gobreaker.State
+	generation uint64
+	openedAt   time.Time
+	buckets    []windowBucket
+	consSucc   uint32
+	consFail   uint32
+	probes     uint32
+	probeSucc  uint32
+}
+
+func newSlidingWindowBreaker(s BreakerSettings) *slidingWindowBreaker {
+	if s.Window <= 0 {
+		s.Window = 60 * time.Second
+	}
+	if s.WindowBuckets <= 0 {
+		s.WindowBuckets = 10
+	}
+	if s.MaxRequests == 0 {
+		s.MaxRequests = 1
+	}
+	if s.HalfOpenSuccesses == 0 || s.HalfOpenSuccesses > s.MaxRequests {
+		s.HalfOpenSuccesses = s.MaxRequests
+	}
+	if s.Timeout <= 0 {
+		s.Timeout = 60 * time.Second
+	}
+	return &slidingWindowBreaker{
+		name:    s.Name,
+		s:       s,
+		width:   s.Window / time.Duration(s.WindowBuckets),
+		now:     time.Now,
+		buckets: make([]windowBucket, s.WindowBuckets),
+	}
+}
+
+func (b *slidingWindowBreaker) Name() string { return b.name }
+
+func (b *slidingWindowBreaker) State() gobreaker.State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refreshState(b.now())
+	return b.state
+}
+
+// Counts maps the window onto gobreaker.Counts so callers see the same shape
+// whichever engine is configured.
+func (b *slidingWindowBreaker) Counts() gobreaker.Counts {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	w := b.window(b.now())
+	return gobreaker.Counts{
+		Requests:             w.Calls,
+		TotalSuccesses:       w.Calls - w.Failures,
+		TotalFailures:        w.Failures,
+		ConsecutiveSuccesses: b.consSucc,
+		ConsecutiveFailures:  b.consFail,
+	}
+}
+
+// Window returns the failure and slow-call statistics of the rolling window.
+func (b *slidingWindowBreaker) Window() WindowStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.window(b.now())
+}
+
+// Execute runs req if the breaker accepts it and records its outcome and latency.
+func (b *slidingWindowBreaker) Execute(req func() (interface{}, error)) (interface{}, error) {
+	generation, err := b.before()
+	if err != nil {
+		return nil, err
+	}
+
+	start := b.now()
+	defer func() {
+		if e := recover(); e != nil {
+			b.after(generation, false, b.now().Sub(start))
+			panic(e)
+		}
+	}()
+
+	result, err := req()
+	if isIgnoredOutcome(err) {
+		b.release(generation)
+		return result, err
+	}
+	b.after(generation, err == nil, b.now().Sub(start))
+	return result, err
+}
+
+// release gives back the half-open probe taken by an ignored call.
+func (b *slidingWindowBreaker) release(generation uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if generation == b.generation && b.state == gobreaker.StateHalfOpen && b.probes > 0 {
+		b.probes--
+	}
+}
+
+func (b *slidingWindowBreaker) before() (uint64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refreshState(b.now())
+	switch b.state {
+	case gobreaker.StateOpen:
+		return b.generation, gobreaker.ErrOpenState
+	case gobreaker.StateHalfOpen:
+		if b.probes >= b.s.MaxRequests {
+			return b.generation, gobreaker.ErrTooManyRequests
+		}
+		b.probes++
+	}
+	return b.generation, nil
+}
+
+func (b *slidingWindowBreaker) after(generation uint64, success bool, elapsed time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	b.refreshState(now)
+	if generation != b.generation {
+		// the call started under a previous state; its outcome no longer matters
+		return
+	}
+
+	slow := b.s.SlowCallDuration > 0 && elapsed >= b.s.SlowCallDuration
+	if success {
+		b.consSucc++
+		b.consFail = 0
+	} else {
+		b.consFail++
+		b.consSucc = 0
+	}
+
+	switch b.state {
+	case gobreaker.StateClosed:
+		bucket := b.bucket(now)
+		bucket.calls++
+		if !success {
+			bucket.failures++
+		}
+		if slow {
+			bucket.slow++
+		}
+		if b.shouldTrip(b.window(now)) {
+			b.setState(gobreaker.StateOpen, now)
+		}
+	case gobreaker.StateHalfOpen:
+		if !success || (slow && b.s.SlowCallRatio > 0) {
+			b.setState(gobreaker.StateOpen, now)
+			return
+		}
+		b.probeSucc++
+		if b.probeSucc >= b.s.HalfOpenSuccesses {
+			b.setState(gobreaker.StateClosed, now)
+		}
+	}
+}
+
+func (b *slidingWindowBreaker) shouldTrip(w WindowStats) bool {
+	if b.s.ConsecutiveFailures > 0 && b.consFail >= b.s.ConsecutiveFailures {
+		return true
+	}
+	if w.Calls < b.s.MinRequests || w.Calls == 0 {
+		return false
+	}
+	if w.FailureRate >= b.s.FailureRatio {
+		return true
+	}
+	return b.s.SlowCallDuration > 0 && b.s.SlowCallRatio > 0 && w.SlowRate >= b.s.SlowCallRatio
+}
+
+// refreshState moves an open breaker to half-open once Timeout has elapsed.
+func (b *slidingWindowBreaker) refreshState(now time.Time) {
+	if b.state == gobreaker.StateOpen && !now.Before(b.openedAt.Add(b.s.Timeout)) {
+		b.setState(gobreaker.StateHalfOpen, now)
+	}
+}
+
+func (b *slidingWindowBreaker) setState(state gobreaker.State, now time.Time) {
+	if b.state == state {
+		return
+	}
+	from := b.state
+	b.state = state
+	b.generation++
+	b.probes, b.probeSucc = 0, 0
+	b.consSucc, b.consFail = 0, 0
+	switch state {
+	case gobreaker.StateOpen:
+		b.openedAt = now
+	case gobreaker.StateClosed:
+		for i := range b.buckets {
+			b.buckets[i] = windowBucket{}
+		}
+	}
+	if b.s.OnStateChange != nil {
+		b.s.OnStateChange(b.name, from, state)
+	}
+}
+
+// bucket returns the bucket for now, recycling it if it belongs to an old epoch.
+func (b *slidingWindowBreaker) bucket(now time.Time) *windowBucket {
+	epoch := now.UnixNano() / int64(b.width)
+	bucket := &b.buckets[epoch%int64(len(b.buckets))]
+	if bucket.epoch != epoch {
+		*bucket = windowBucket{epoch: epoch}
+	}
+	return bucket
+}
+
+func (b *slidingWindowBreaker) window(now time.Time) WindowStats {
+	var w WindowStats
+	current := now.UnixNano() / int64(b.width)
+	for _, bucket := range b.buckets {
+		if bucket.epoch <= current-int64(len(b.buckets)) || bucket.epoch > current {
+			continue
+		}
+		w.Calls += bucket.calls
+		w.Failures += bucket.failures
+		w.SlowCalls += bucket.slow
+	}
+	if w.Calls > 0 {
+		w.FailureRate = float64(w.Failures) / float64(w.Calls)
+		w.SlowRate = float64(w.SlowCalls) / float64(w.Calls)
+	}
+	return w
+}
+
+var _ circuitBreaker = (*slidingWindowBreaker)(nil)
+var _ circuitBreaker = (*gobreaker.CircuitBreaker[interface{}])(nil)
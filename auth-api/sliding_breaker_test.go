@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sony/gobreaker/v2"
+)
+
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestSlidingBreaker(clock *fakeClock) *slidingWindowBreaker {
+	b := newSlidingWindowBreaker(BreakerSettings{
+		Name:             "test-sliding",
+		MaxRequests:      2,
+		Timeout:          5 * time.Second,
+		MinRequests:      4,
+		FailureRatio:     0.5,
+		Window:           10 * time.Second,
+		WindowBuckets:    10,
+		SlowCallDuration: time.Second,
+		SlowCallRatio:    0.5,
+	})
+	b.now = clock.now
+	return b
+}
+
+// call executes a request on b that takes d and fails when fail is set.
+func call(b *slidingWindowBreaker, clock *fakeClock, d time.Duration, fail bool) error {
+	_, err := b.Execute(func() (interface{}, error) {
+		clock.advance(d)
+		if fail {
+			return nil, errors.New("simulated failure")
+		}
+		return nil, nil
+	})
+	return err
+}
+
+func TestSlidingBreaker_TripsOnSlowCalls(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	b := newTestSlidingBreaker(clock)
+
+	call(b, clock, 10*time.Millisecond, false)
+	call(b, clock, 10*time.Millisecond, false)
+	call(b, clock, 3*time.Second, false)
+	if b.State() != gobreaker.StateClosed {
+		t.Fatalf("breaker should stay closed below minimum volume")
+	}
+	call(b, clock, 3*time.Second, false)
+
+	if b.State() != gobreaker.StateOpen {
+		t.Fatalf("expected slow calls to open the breaker, window=%+v", b.Window())
+	}
+	if err := call(b, clock, 0, false); err != gobreaker.ErrOpenState {
+		t.Fatalf("expected ErrOpenState, got %v", err)
+	}
+}
+
+func TestSlidingBreaker_OldBucketsExpire(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	b := newTestSlidingBreaker(clock)
+
+	call(b, clock, 0, true)
+	call(b, clock, 0, true)
+	clock.advance(11 * time.Second)
+	call(b, clock, 0, false)
+	call(b, clock, 0, false)
+	call(b, clock, 0, true)
+
+	if w := b.Window(); w.Calls != 3 || w.Failures != 1 {
+		t.Fatalf("expected failures older than the window to be dropped, got %+v", w)
+	}
+	if b.State() != gobreaker.StateClosed {
+		t.Fatalf("expected breaker to stay closed")
+	}
+}
+
+func TestSlidingBreaker_HalfOpenProbes(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	b := newTestSlidingBreaker(clock)
+	for i := 0; i < 4; i++ {
+		call(b, clock, 0, true)
+	}
+	if b.State() != gobreaker.StateOpen {
+		t.Fatalf("expected breaker to open")
+	}
+
+	clock.advance(5 * time.Second)
+	if b.State() != gobreaker.StateHalfOpen {
+		t.Fatalf("expected half-open after timeout, got %s", b.State())
+	}
+	if err := call(b, clock, 0, false); err != nil {
+		t.Fatalf("first probe rejected: %v", err)
+	}
+	if b.State() != gobreaker.StateHalfOpen {
+		t.Fatalf("one probe must not close the breaker")
+	}
+	call(b, clock, 0, false)
+	if b.State() != gobreaker.StateClosed {
+		t.Fatalf("expected breaker to close after successful probes, got %s", b.State())
+	}
+
+	for i := 0; i < 4; i++ {
+		call(b, clock, 0, true)
+	}
+	clock.advance(5 * time.Second)
+	call(b, clock, 2*time.Second, false)
+	if b.State() != gobreaker.StateOpen {
+		t.Fatalf("expected slow probe to reopen the breaker, got %s", b.State())
+	}
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// SpanReporterConfig bounds the memory and disk used to report spans.
+type SpanReporterConfig struct {
+	// QueueSize is the number of finished spans kept in memory before the
+	// batch processor starts dropping them.
+	QueueSize int
+	// BatchSize is the number of spans sent to the collector at once.
+	BatchSize int
+	// SpoolFile receives the spans the collector could not accept; empty
+	// disables spooling.
+	SpoolFile string
+	// SpoolMaxBytes caps the spool file; spans that do not fit are dropped.
+	SpoolMaxBytes int64
+	// ReplayInterval is how often the spool is retried while the collector
+	// is unreachable.
+	ReplayInterval time.Duration
+}
+
+// spanReporterConfigFromEnv reads TRACING_QUEUE_SIZE, TRACING_BATCH_SIZE,
+// TRACING_SPOOL_FILE and TRACING_SPOOL_MAX_BYTES.
+func spanReporterConfigFromEnv(env configLookup) (SpanReporterConfig, error) {
+	c := SpanReporterConfig{
+		QueueSize:      2048,
+		BatchSize:      512,
+		SpoolFile:      filepath.Join(os.TempDir(), "auth-api-spans.jsonl"),
+		SpoolMaxBytes:  10 << 20,
+		ReplayInterval: 10 * time.Second,
+	}
+	for _, v := range []struct {
+		name string
+		dst  *int
+	}{{"TRACING_QUEUE_SIZE", &c.QueueSize}, {"TRACING_BATCH_SIZE", &c.BatchSize}} {
+		if s := env.get(v.name); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n <= 0 {
+				return c, fmt.Errorf("%s must be a positive integer, got %q", v.name, s)
+			}
+			*v.dst = n
+		}
+	}
+	if c.BatchSize > c.QueueSize {
+		return c, fmt.Errorf("TRACING_BATCH_SIZE (%d) cannot exceed TRACING_QUEUE_SIZE (%d)", c.BatchSize, c.QueueSize)
+	}
+	if v, ok := env("TRACING_SPOOL_FILE"); ok {
+		c.SpoolFile = v
+	}
+	if v := env.get("TRACING_SPOOL_MAX_BYTES"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			return c, fmt.Errorf("TRACING_SPOOL_MAX_BYTES must be a positive integer, got %q", v)
+		}
+		c.SpoolMaxBytes = n
+	}
+	return c, nil
+}
+
+// SpanReporterStats are the counters of the span reporter.
+type SpanReporterStats struct {
+	Exporter string `json:"exporter"`
+	// Initialised is false while the exporter is still being created.
+	Initialised bool `json:"initialised"`
+	// Exported spans were accepted by the collector on the first try.
+	Exported uint64 `json:"exported"`
+	// Spooled spans were written to the spool file after a failed export.
+	Spooled uint64 `json:"spooled"`
+	// Replayed spans were sent from the spool once the collector came back.
+	Replayed uint64 `json:"replayed"`
+	// Dropped spans were lost because the processor queue or the spool was
+	// full, the spool disabled or corrupt.
+	Dropped    uint64 `json:"dropped"`
+	SpoolBytes int64  `json:"spoolBytes"`
+	LastError  string `json:"lastError,omitempty"`
+}
+
+// resilientExporter never loses spans to a collector outage: failed batches
+// are spooled to disk and replayed in the background once the collector
+// answers again. The real exporter is created in the background too, so a
+// collector that is down at startup no longer disables tracing.
+type resilientExporter struct {
+	name     string
+	newInner func(context.Context) (sdktrace.SpanExporter, error)
+	spool    *spanSpool
+	cfg      SpanReporterConfig
+	timeout  time.Duration
+
+	mu      sync.RWMutex
+	inner   sdktrace.SpanExporter
+	lastErr string
+
+	// exportMu serializes calls to inner between the processor and replays
+	exportMu sync.Mutex
+
+	exported, spooled, replayed, dropped uint64
+	pending                              int32
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newResilientExporter(name string, newInner func(context.Context) (sdktrace.SpanExporter, error), cfg SpanReporterConfig) *resilientExporter {
+	e := &resilientExporter{
+		name:     name,
+		newInner: newInner,
+		cfg:      cfg,
+		timeout:  10 * time.Second,
+		wake:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if cfg.SpoolFile != "" {
+		e.spool = newSpanSpool(cfg.SpoolFile, cfg.SpoolMaxBytes)
+		if e.spool.Size() > 0 {
+			// spans left over by a previous run
+			e.pending = 1
+		}
+	}
+	e.connect(context.Background())
+	go e.run()
+	return e
+}
+
+// connect creates the real exporter if it does not exist yet.
+func (e *resilientExporter) connect(ctx context.Context) bool {
+	if e.current() != nil {
+		return true
+	}
+	inner, err := e.newInner(ctx)
+	if err != nil {
+		e.setError(fmt.Errorf("create %s exporter: %w", e.name, err))
+		return false
+	}
+	e.mu.Lock()
+	e.inner = inner
+	e.mu.Unlock()
+	return true
+}
+
+func (e *resilientExporter) current() sdktrace.SpanExporter {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.inner
+}
+
+func (e *resilientExporter) setError(err error) {
+	e.mu.Lock()
+	if err == nil {
+		e.lastErr = ""
+	} else {
+		e.lastErr = err.Error()
+	}
+	e.mu.Unlock()
+}
+
+func (e *resilientExporter) export(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	inner := e.current()
+	if inner == nil {
+		return fmt.Errorf("%s exporter is not initialised", e.name)
+	}
+	e.exportMu.Lock()
+	defer e.exportMu.Unlock()
+	err := inner.ExportSpans(ctx, spans)
+	e.setError(err)
+	return err
+}
+
+// ExportSpans sends spans to the collector, or spools them when it fails.
+// It never returns an error so the batch processor keeps going.
+func (e *resilientExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	if len(spans) == 0 {
+		return nil
+	}
+	if err := e.export(ctx, spans); err == nil {
+		atomic.AddUint64(&e.exported, uint64(len(spans)))
+		if atomic.LoadInt32(&e.pending) == 1 {
+			e.kick()
+		}
+		return nil
+	}
+
+	if e.spool == nil {
+		atomic.AddUint64(&e.dropped, uint64(len(spans)))
+		return nil
+	}
+	if err := e.spool.Append(spans); err != nil {
+		atomic.AddUint64(&e.dropped, uint64(len(spans)))
+		if err != errSpoolFull {
+			e.setError(fmt.Errorf("spool spans: %w", err))
+		}
+		return nil
+	}
+	atomic.AddUint64(&e.spooled, uint64(len(spans)))
+	atomic.StoreInt32(&e.pending, 1)
+	return nil
+}
+
+// Processor returns the batch span processor that feeds e. Spans that do
+// not fit in its queue are dropped, as the SDK processor does, but counted
+// in the dropped counter.
+func (e *resilientExporter) Processor() sdktrace.SpanProcessor {
+	p := &boundedProcessor{reporter: e, size: int64(e.cfg.QueueSize)}
+	p.SpanProcessor = sdktrace.NewBatchSpanProcessor(dequeueExporter{e, &p.queued},
+		sdktrace.WithMaxQueueSize(e.cfg.QueueSize),
+		sdktrace.WithMaxExportBatchSize(e.cfg.BatchSize),
+	)
+	return p
+}
+
+// boundedProcessor keeps at most size spans between the batch processor
+// queue and its exporter, so the batch processor never drops spans itself.
+type boundedProcessor struct {
+	sdktrace.SpanProcessor
+	reporter *resilientExporter
+	size     int64
+	queued   atomic.Int64
+}
+
+func (p *boundedProcessor) OnEnd(s sdktrace.ReadOnlySpan) {
+	if !s.SpanContext().IsSampled() {
+		// ignored by the batch processor
+		p.SpanProcessor.OnEnd(s)
+		return
+	}
+	if p.queued.Add(1) > p.size {
+		p.queued.Add(-1)
+		atomic.AddUint64(&p.reporter.dropped, 1)
+		return
+	}
+	p.SpanProcessor.OnEnd(s)
+}
+
+// dequeueExporter releases the queue slots of the spans the batch processor exports.
+type dequeueExporter struct {
+	sdktrace.SpanExporter
+	queued *atomic.Int64
+}
+
+func (d dequeueExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	d.queued.Add(-int64(len(spans)))
+	return d.SpanExporter.ExportSpans(ctx, spans)
+}
+
+// kick asks the background loop to replay the spool now.
+func (e *resilientExporter) kick() {
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run creates the exporter with backoff until it succeeds, then replays the
+// spool whenever an export succeeds again and every ReplayInterval.
+func (e *resilientExporter) run() {
+	defer close(e.done)
+
+	delay := time.Second
+	for !e.connect(context.Background()) {
+		select {
+		case <-e.stop:
+			return
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > time.Minute {
+			delay = time.Minute
+		}
+	}
+
+	interval := e.cfg.ReplayInterval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	e.replay()
+	for {
+		select {
+		case <-e.stop:
+			return
+		case <-e.wake:
+		case <-ticker.C:
+		}
+		e.replay()
+	}
+}
+
+// replay sends the spooled spans, keeping those the collector refuses.
+func (e *resilientExporter) replay() {
+	if e.spool == nil || atomic.LoadInt32(&e.pending) == 0 {
+		return
+	}
+	batch := e.cfg.BatchSize
+	if batch <= 0 {
+		batch = 512
+	}
+	// cleared first so a batch spooled during the replay sets it again
+	atomic.StoreInt32(&e.pending, 0)
+	replayed, corrupt, err := e.spool.Replay(batch, func(spans []sdktrace.ReadOnlySpan) error {
+		ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
+		defer cancel()
+		return e.export(ctx, spans)
+	})
+	atomic.AddUint64(&e.replayed, uint64(replayed))
+	atomic.AddUint64(&e.dropped, uint64(corrupt))
+	if err != nil {
+		atomic.StoreInt32(&e.pending, 1)
+	}
+}
+
+// Shutdown stops the background loop and the real exporter. Spans still
+// in the spool are replayed on the next start.
+func (e *resilientExporter) Shutdown(ctx context.Context) error {
+	select {
+	case <-e.stop:
+		return nil
+	default:
+		close(e.stop)
+	}
+	select {
+	case <-e.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if inner := e.current(); inner != nil {
+		return inner.Shutdown(ctx)
+	}
+	return nil
+}
+
+// Stats returns a snapshot of the reporter counters.
+func (e *resilientExporter) Stats() SpanReporterStats {
+	e.mu.RLock()
+	s := SpanReporterStats{
+		Exporter:    e.name,
+		Initialised: e.inner != nil,
+		LastError:   e.lastErr,
+	}
+	e.mu.RUnlock()
+	s.Exported = atomic.LoadUint64(&e.exported)
+	s.Spooled = atomic.LoadUint64(&e.spooled)
+	s.Replayed = atomic.LoadUint64(&e.replayed)
+	s.Dropped = atomic.LoadUint64(&e.dropped)
+	if e.spool != nil {
+		s.SpoolBytes = e.spool.Size()
+	}
+	return s
+}
+
+var _ sdktrace.SpanExporter = (*resilientExporter)(nil)
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// flakyExporter stands in for a collector that can go down.
+type flakyExporter struct {
+	mu    sync.Mutex
+	down  bool
+	spans []sdktrace.ReadOnlySpan
+}
+
+func (f *flakyExporter) setDown(down bool) {
+	f.mu.Lock()
+	f.down = down
+	f.mu.Unlock()
+}
+
+func (f *flakyExporter) received() []sdktrace.ReadOnlySpan {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]sdktrace.ReadOnlySpan(nil), f.spans...)
+}
+
+func (f *flakyExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down {
+		return errors.New("connection refused")
+	}
+	f.spans = append(f.spans, spans...)
+	return nil
+}
+
+func (f *flakyExporter) Shutdown(ctx context.Context) error { return nil }
+
+func testSpans(n int) []sdktrace.ReadOnlySpan {
+	spans := make([]sdktrace.ReadOnlySpan, n)
+	for i := range spans {
+		spans[i] = tracetest.SpanStub{
+			Name: "GET /users/{username}",
+			SpanContext: trace.NewSpanContext(trace.SpanContextConfig{
+				TraceID:    trace.TraceID{1},
+				SpanID:     trace.SpanID{byte(i + 1)},
+				TraceFlags: trace.FlagsSampled,
+			}),
+			StartTime: time.Unix(1700000000, 0),
+			EndTime:   time.Unix(1700000001, 0),
+		}.Snapshot()
+	}
+	return spans
+}
+
+func newTestReporter(t *testing.T, inner sdktrace.SpanExporter, maxBytes int64) *resilientExporter {
+	cfg := SpanReporterConfig{
+		BatchSize:      2,
+		SpoolFile:      filepath.Join(t.TempDir(), "spans.jsonl"),
+		SpoolMaxBytes:  maxBytes,
+		ReplayInterval: time.Hour,
+	}
+	e := newResilientExporter("test", func(context.Context) (sdktrace.SpanExporter, error) { return inner, nil }, cfg)
+	t.Cleanup(func() { e.Shutdown(context.Background()) })
+	return e
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestResilientExporter_SpoolsAndReplays(t *testing.T) {
+	collector := &flakyExporter{down: true}
+	e := newTestReporter(t, collector, 1<<20)
+
+	if err := e.ExportSpans(context.Background(), testSpans(3)); err != nil {
+		t.Fatalf("expected spooling to hide the export error, got %v", err)
+	}
+	if st := e.Stats(); st.Spooled != 3 || st.SpoolBytes == 0 || st.LastError == "" {
+		t.Fatalf("expected 3 spooled spans, got %+v", st)
+	}
+
+	// the next successful export replays the spool in the background
+	collector.setDown(false)
+	if err := e.ExportSpans(context.Background(), testSpans(1)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	waitFor(t, "the spool replay", func() bool { return len(collector.received()) == 4 })
+
+	st := e.Stats()
+	if st.Exported != 1 || st.Replayed != 3 || st.Dropped != 0 || st.SpoolBytes != 0 || st.LastError != "" {
+		t.Fatalf("unexpected stats after replay: %+v", st)
+	}
+	if _, err := os.Stat(e.spool.path); !os.IsNotExist(err) {
+		t.Fatalf("expected the spool file to be removed, got %v", err)
+	}
+}
+
+func TestResilientExporter_DropsWhenSpoolIsFull(t *testing.T) {
+	e := newTestReporter(t, &flakyExporter{down: true}, 512)
+
+	for i := 0; i < 10; i++ {
+		e.ExportSpans(context.Background(), testSpans(1))
+	}
+	st := e.Stats()
+	if st.Dropped == 0 || st.Spooled+st.Dropped != 10 || st.SpoolBytes > 512 {
+		t.Fatalf("expected the spool to stay under its limit, got %+v", st)
+	}
+}
+
+func TestResilientExporter_RetriesInitialisation(t *testing.T) {
+	collector := &flakyExporter{}
+	var mu sync.Mutex
+	attempts := 0
+	cfg := SpanReporterConfig{
+		BatchSize:      512,
+		SpoolFile:      filepath.Join(t.TempDir(), "spans.jsonl"),
+		SpoolMaxBytes:  1 << 20,
+		ReplayInterval: time.Hour,
+	}
+	e := newResilientExporter("test", func(context.Context) (sdktrace.SpanExporter, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if attempts++; attempts < 3 {
+			return nil, errors.New("collector unreachable")
+		}
+		return collector, nil
+	}, cfg)
+	defer e.Shutdown(context.Background())
+
+	e.ExportSpans(context.Background(), testSpans(2))
+	if st := e.Stats(); st.Initialised || st.Spooled != 2 {
+		t.Fatalf("expected spans to be spooled before initialisation, got %+v", st)
+	}
+	waitFor(t, "the exporter to be created and the spool replayed", func() bool { return len(collector.received()) == 2 })
+	if st := e.Stats(); !st.Initialised || st.Replayed != 2 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+}
+
+func TestResilientExporter_ReplaysSpoolLeftByPreviousRun(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.jsonl")
+	if err := newSpanSpool(path, 1<<20).Append(testSpans(2)); err != nil {
+		t.Fatal(err)
+	}
+
+	collector := &flakyExporter{}
+	cfg := SpanReporterConfig{BatchSize: 512, SpoolFile: path, SpoolMaxBytes: 1 << 20, ReplayInterval: time.Hour}
+	e := newResilientExporter("test", func(context.Context) (sdktrace.SpanExporter, error) { return collector, nil }, cfg)
+	defer e.Shutdown(context.Background())
+
+	waitFor(t, "the startup replay", func() bool { return len(collector.received()) == 2 })
+}
+
+func TestSpanSpool_RoundTrip(t *testing.T) {
+	parent := trace.NewSpanContext(trace.SpanContextConfig{TraceID: trace.TraceID{7}, SpanID: trace.SpanID{8}, Remote: true})
+	state, _ := trace.ParseTraceState("vendor=abc")
+	want := tracetest.SpanStub{
+		Name: "POST /login",
+		SpanContext: trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID: trace.TraceID{7}, SpanID: trace.SpanID{9}, TraceFlags: trace.FlagsSampled, TraceState: state,
+		}),
+		Parent:    parent,
+		SpanKind:  trace.SpanKindServer,
+		StartTime: time.Unix(1700000000, 0).UTC(),
+		EndTime:   time.Unix(1700000002, 0).UTC(),
+		Attributes: []attribute.KeyValue{
+			attribute.String("http.route", "/login"),
+			attribute.Int64("retry.attempt", 2),
+			attribute.Bool("breaker.fallback", true),
+			attribute.Float64Slice("latencies", []float64{0.5, 1.5}),
+		},
+		Events:                 []sdktrace.Event{{Name: "retry", Time: time.Unix(1700000001, 0).UTC(), Attributes: []attribute.KeyValue{attribute.String("reason", "status_503")}}},
+		Status:                 sdktrace.Status{Code: codes.Error, Description: "unavailable"},
+		InstrumentationLibrary: instrumentation.Scope{Name: tracerName},
+	}
+
+	path := filepath.Join(t.TempDir(), "spans.jsonl")
+	spool := newSpanSpool(path, 1<<20)
+	if err := spool.Append([]sdktrace.ReadOnlySpan{want.Snapshot()}); err != nil {
+		t.Fatal(err)
+	}
+	var got []sdktrace.ReadOnlySpan
+	if _, _, err := spool.Replay(10, func(spans []sdktrace.ReadOnlySpan) error {
+		got = spans
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("expected one span, got %d", len(got))
+	}
+
+	s := got[0]
+	if s.Name() != want.Name || s.SpanContext().SpanID() != want.SpanContext.SpanID() ||
+		s.SpanContext().TraceState().Get("vendor") != "abc" ||
+		s.Parent().SpanID() != parent.SpanID() || !s.Parent().IsRemote() ||
+		s.SpanKind() != trace.SpanKindServer || !s.EndTime().Equal(want.EndTime) ||
+		s.Status() != want.Status || s.InstrumentationScope().Name != tracerName {
+		t.Fatalf("span did not survive the spool: %+v", tracetest.SpanStubFromReadOnlySpan(s))
+	}
+	if len(s.Attributes()) != 4 || s.Attributes()[3].Value.AsFloat64Slice()[1] != 1.5 || s.Attributes()[1].Value.AsInt64() != 2 {
+		t.Fatalf("unexpected attributes: %v", s.Attributes())
+	}
+	if len(s.Events()) != 1 || s.Events()[0].Attributes[0].Value.AsString() != "status_503" {
+		t.Fatalf("unexpected events: %v", s.Events())
+	}
+}
+
+func TestSpanSpool_DropsCorruptLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.jsonl")
+	spool := newSpanSpool(path, 1<<20)
+	if err := spool.Append(testSpans(1)); err != nil {
+		t.Fatal(err)
+	}
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+	f.WriteString("{not json\n")
+	f.Close()
+	if err := spool.Append(testSpans(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	down := errors.New("collector down")
+	for i, want := range []int{1, 0} {
+		_, corrupt, err := spool.Replay(10, func([]sdktrace.ReadOnlySpan) error { return down })
+		if err != down || corrupt != want {
+			t.Fatalf("replay %d: expected %d corrupt lines and the export error, got %d %v", i, want, corrupt, err)
+		}
+	}
+	replayed, _, err := spool.Replay(10, func([]sdktrace.ReadOnlySpan) error { return nil })
+	if err != nil || replayed != 2 || spool.Size() != 0 {
+		t.Fatalf("expected the two spans replayed and the spool emptied, got %d %v size=%d", replayed, err, spool.Size())
+	}
+}
+
+func TestSpanSpool_AppendsWhileReplaying(t *testing.T) {
+	spool := newSpanSpool(filepath.Join(t.TempDir(), "spans.jsonl"), 1<<20)
+	if err := spool.Append(testSpans(2)); err != nil {
+		t.Fatal(err)
+	}
+
+	// a batch spooled by the processor while the replay waits on the collector
+	down := errors.New("collector down")
+	replayed, _, err := spool.Replay(1, func(spans []sdktrace.ReadOnlySpan) error {
+		if err := spool.Append(testSpans(1)); err != nil {
+			t.Fatalf("append during replay: %v", err)
+		}
+		if spans[0].SpanContext().SpanID() == (trace.SpanID{2}) {
+			return down
+		}
+		return nil
+	})
+	if err != down || replayed != 1 {
+		t.Fatalf("expected one span replayed before the failure, got %d %v", replayed, err)
+	}
+
+	var ids []trace.SpanID
+	if _, _, err := spool.Replay(10, func(spans []sdktrace.ReadOnlySpan) error {
+		for _, s := range spans {
+			ids = append(ids, s.SpanContext().SpanID())
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 3 || ids[0] != (trace.SpanID{2}) {
+		t.Fatalf("expected the failed span then the two appended ones, got %v", ids)
+	}
+}
+
+// blockingExporter holds every export until release is closed.
+type blockingExporter struct {
+	flakyExporter
+	release chan struct{}
+}
+
+func (b *blockingExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	<-b.release
+	return b.flakyExporter.ExportSpans(ctx, spans)
+}
+
+func TestResilientExporter_CountsQueueDrops(t *testing.T) {
+	collector := &blockingExporter{release: make(chan struct{})}
+	cfg := SpanReporterConfig{QueueSize: 2, BatchSize: 1, ReplayInterval: time.Hour}
+	e := newResilientExporter("test", func(context.Context) (sdktrace.SpanExporter, error) { return collector, nil }, cfg)
+	t.Cleanup(func() { e.Shutdown(context.Background()) })
+	processor := e.Processor()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(processor)).Tracer("test")
+
+	for i := 0; i < 10; i++ {
+		_, span := tracer.Start(context.Background(), "login")
+		span.End()
+	}
+	close(collector.release)
+	if err := processor.ForceFlush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	st := e.Stats()
+	if st.Dropped < 7 || st.Dropped+uint64(len(collector.received())) != 10 {
+		t.Fatalf("expected the spans over the queue counted as dropped, got %+v and %d exported", st, len(collector.received()))
+	}
+}
+
+func TestSpanReporterConfigFromEnv(t *testing.T) {
+	t.Setenv("TRACING_SPOOL_MAX_BYTES", "1024")
+	c, err := spanReporterConfigFromEnv(os.LookupEnv)
+	if err != nil || c.SpoolMaxBytes != 1024 || c.QueueSize != 2048 || c.SpoolFile == "" {
+		t.Fatalf("unexpected config %+v err=%v", c, err)
+	}
+
+	t.Setenv("TRACING_BATCH_SIZE", "4096")
+	if _, err := spanReporterConfigFromEnv(os.LookupEnv); err == nil {
+		t.Fatal("expected an error for a batch larger than the queue")
+	}
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// errSpoolFull is returned when appending would grow the spool past its limit.
+var errSpoolFull = errors.New("span spool is full")
+
+// spanSpool keeps spans that could not be exported in a JSON lines file,
+// bounded to maxBytes, until they can be replayed.
+type spanSpool struct {
+	path     string
+	maxBytes int64
+
+	mu sync.Mutex
+	// replayMu serializes replays; mu is only held for file access so
+	// Append does not wait for the collector while a replay exports
+	replayMu sync.Mutex
+}
+
+func newSpanSpool(path string, maxBytes int64) *spanSpool {
+	return &spanSpool{path: path, maxBytes: maxBytes}
+}
+
+// Append writes spans to the spool, all or none.
+func (s *spanSpool) Append(spans []sdktrace.ReadOnlySpan) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, span := range spans {
+		if err := enc.Encode(encodeSpan(span)); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if size := s.sizeLocked(); size+int64(buf.Len()) > s.maxBytes {
+		return errSpoolFull
+	}
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(buf.Bytes())
+	return err
+}
+
+// Size returns the spool size in bytes.
+func (s *spanSpool) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sizeLocked()
+}
+
+func (s *spanSpool) sizeLocked() int64 {
+	fi, err := os.Stat(s.path)
+	if err != nil {
+		return 0
+	}
+	return fi.Size()
+}
+
+// Replay hands the spooled spans to export in batches of batchSize, oldest
+// first, and keeps in the spool only the batches that failed. It returns the
+// number of spans replayed; lines that cannot be decoded are dropped and counted.
+// Spans appended while the batches are exported stay in the spool after the
+// failed ones.
+func (s *spanSpool) Replay(batchSize int, export func([]sdktrace.ReadOnlySpan) error) (replayed, corrupt int, err error) {
+	s.replayMu.Lock()
+	defer s.replayMu.Unlock()
+
+	s.mu.Lock()
+	data, err := os.ReadFile(s.path)
+	s.mu.Unlock()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, 0, nil
+		}
+		return 0, 0, err
+	}
+
+	// corrupt lines are dropped here, so they are counted once and not
+	// written back
+	var lines [][]byte
+	var spans []sdktrace.ReadOnlySpan
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64<<10), int(s.maxBytes)+1)
+	for scanner.Scan() {
+		var ss spooledSpan
+		if json.Unmarshal(scanner.Bytes(), &ss) != nil {
+			corrupt++
+			continue
+		}
+		span, derr := ss.decode()
+		if derr != nil {
+			corrupt++
+			continue
+		}
+		lines = append(lines, append([]byte(nil), scanner.Bytes()...))
+		spans = append(spans, span)
+	}
+
+	for len(lines) > 0 {
+		n := batchSize
+		if n > len(lines) {
+			n = len(lines)
+		}
+		if err = export(spans[:n]); err != nil {
+			break
+		}
+		replayed += n
+		lines, spans = lines[n:], spans[n:]
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Append only ever adds to the file, so what lies past the bytes read
+	// above was spooled during the export
+	current, rerr := os.ReadFile(s.path)
+	if rerr != nil && !os.IsNotExist(rerr) {
+		return replayed, corrupt, rerr
+	}
+	var rest []byte
+	if len(lines) > 0 {
+		rest = append(bytes.Join(lines, []byte("\n")), '\n')
+	}
+	if len(current) > len(data) {
+		rest = append(rest, current[len(data):]...)
+	}
+	if len(rest) == 0 {
+		if rerr := os.Remove(s.path); rerr != nil && !os.IsNotExist(rerr) {
+			return replayed, corrupt, rerr
+		}
+		return replayed, corrupt, err
+	}
+	if werr := os.WriteFile(s.path, rest, 0o600); werr != nil {
+		return replayed, corrupt, werr
+	}
+	return replayed, corrupt, err
+}
+
+// spooledSpan is the spool encoding of a finished span.
+type spooledSpan struct {
+	Name              string                `json:"name"`
+	TraceID           string                `json:"traceId"`
+	SpanID            string                `json:"spanId"`
+	TraceFlags        byte                  `json:"traceFlags"`
+	TraceState        string                `json:"traceState,omitempty"`
+	ParentSpanID      string                `json:"parentSpanId,omitempty"`
+	ParentRemote      bool                  `json:"parentRemote,omitempty"`
+	Kind              int                   `json:"kind"`
+	Start             time.Time             `json:"start"`
+	End               time.Time             `json:"end"`
+	Attributes        []spooledAttr         `json:"attributes,omitempty"`
+	Events            []spooledEvent        `json:"events,omitempty"`
+	Links             []spooledLink         `json:"links,omitempty"`
+	StatusCode        uint32                `json:"statusCode"`
+	StatusDescription string                `json:"statusDescription,omitempty"`
+	DroppedAttributes int                   `json:"droppedAttributes,omitempty"`
+	DroppedEvents     int                   `json:"droppedEvents,omitempty"`
+	DroppedLinks      int                   `json:"droppedLinks,omitempty"`
+	ChildSpanCount    int                   `json:"childSpanCount,omitempty"`
+	Resource          []spooledAttr         `json:"resource,omitempty"`
+	ResourceSchemaURL string                `json:"resourceSchemaUrl,omitempty"`
+	Scope             instrumentation.Scope `json:"scope"`
+}
+
+type spooledEvent struct {
+	Name       string        `json:"name"`
+	Time       time.Time     `json:"time"`
+	Attributes []spooledAttr `json:"attributes,omitempty"`
+}
+
+type spooledLink struct {
+	TraceID    string        `json:"traceId"`
+	SpanID     string        `json:"spanId"`
+	TraceFlags byte          `json:"traceFlags"`
+	Attributes []spooledAttr `json:"attributes,omitempty"`
+}
+
+type spooledAttr struct {
+	Key   string          `json:"k"`
+	Type  string          `json:"t"`
+	Value json.RawMessage `json:"v"`
+}
+
+func encodeSpan(s sdktrace.ReadOnlySpan) spooledSpan {
+	sc := s.SpanContext()
+	ss := spooledSpan{
+		Name:              s.Name(),
+		TraceID:           sc.TraceID().String(),
+		SpanID:            sc.SpanID().String(),
+		TraceFlags:        byte(sc.TraceFlags()),
+		TraceState:        sc.TraceState().String(),
+		Kind:              int(s.SpanKind()),
+		Start:             s.StartTime(),
+		End:               s.EndTime(),
+		Attributes:        encodeAttrs(s.Attributes()),
+		StatusCode:        uint32(s.Status().Code),
+		StatusDescription: s.Status().Description,
+		DroppedAttributes: s.DroppedAttributes(),
+		DroppedEvents:     s.DroppedEvents(),
+		DroppedLinks:      s.DroppedLinks(),
+		ChildSpanCount:    s.ChildSpanCount(),
+		Scope:             s.InstrumentationScope(),
+	}
+	if parent := s.Parent(); parent.IsValid() {
+		ss.ParentSpanID = parent.SpanID().String()
+		ss.ParentRemote = parent.IsRemote()
+	}
+	for _, e := range s.Events() {
+		ss.Events = append(ss.Events, spooledEvent{Name: e.Name, Time: e.Time, Attributes: encodeAttrs(e.Attributes)})
+	}
+	for _, l := range s.Links() {
+		ss.Links = append(ss.Links, spooledLink{
+			TraceID:    l.SpanContext.TraceID().String(),
+			SpanID:     l.SpanContext.SpanID().String(),
+			TraceFlags: byte(l.SpanContext.TraceFlags()),
+			Attributes: encodeAttrs(l.Attributes),
+		})
+	}
+	if res := s.Resource(); res != nil {
+		ss.Resource = encodeAttrs(res.Attributes())
+		ss.ResourceSchemaURL = res.SchemaURL()
+	}
+	return ss
+}
+
+func (ss spooledSpan) decode() (sdktrace.ReadOnlySpan, error) {
+	traceID, err := trace.TraceIDFromHex(ss.TraceID)
+	if err != nil {
+		return nil, err
+	}
+	spanID, err := trace.SpanIDFromHex(ss.SpanID)
+	if err != nil {
+		return nil, err
+	}
+	state, err := trace.ParseTraceState(ss.TraceState)
+	if err != nil {
+		return nil, err
+	}
+
+	span := &replayedSpan{
+		name: ss.Name,
+		spanContext: trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID:    traceID,
+			SpanID:     spanID,
+			TraceFlags: trace.TraceFlags(ss.TraceFlags),
+			TraceState: state,
+		}),
+		kind:              trace.SpanKind(ss.Kind),
+		start:             ss.Start,
+		end:               ss.End,
+		status:            sdktrace.Status{Code: codes.Code(ss.StatusCode), Description: ss.StatusDescription},
+		droppedAttributes: ss.DroppedAttributes,
+		droppedEvents:     ss.DroppedEvents,
+		droppedLinks:      ss.DroppedLinks,
+		childSpanCount:    ss.ChildSpanCount,
+		scope:             ss.Scope,
+	}
+	if span.attributes, err = decodeAttrs(ss.Attributes); err != nil {
+		return nil, err
+	}
+	if ss.ParentSpanID != "" {
+		parentID, err := trace.SpanIDFromHex(ss.ParentSpanID)
+		if err != nil {
+			return nil, err
+		}
+		span.parent = trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID:    traceID,
+			SpanID:     parentID,
+			TraceFlags: trace.TraceFlags(ss.TraceFlags),
+			Remote:     ss.ParentRemote,
+		})
+	}
+	for _, e := range ss.Events {
+		attrs, err := decodeAttrs(e.Attributes)
+		if err != nil {
+			return nil, err
+		}
+		span.events = append(span.events, sdktrace.Event{Name: e.Name, Time: e.Time, Attributes: attrs})
+	}
+	for _, l := range ss.Links {
+		linkTrace, err := trace.TraceIDFromHex(l.TraceID)
+		if err != nil {
+			return nil, err
+		}
+		linkSpan, err := trace.SpanIDFromHex(l.SpanID)
+		if err != nil {
+			return nil, err
+		}
+		attrs, err := decodeAttrs(l.Attributes)
+		if err != nil {
+			return nil, err
+		}
+		span.links = append(span.links, sdktrace.Link{
+			SpanContext: trace.NewSpanContext(trace.SpanContextConfig{
+				TraceID: linkTrace, SpanID: linkSpan, TraceFlags: trace.TraceFlags(l.TraceFlags),
+			}),
+			Attributes: attrs,
+		})
+	}
+	resAttrs, err := decodeAttrs(ss.Resource)
+	if err != nil {
+		return nil, err
+	}
+	span.resource = resource.NewWithAttributes(ss.ResourceSchemaURL, resAttrs...)
+	return span, nil
+}
+
+// replayedSpan is a span read back from the spool. ReadOnlySpan cannot be
+// implemented outside the SDK, so the interface is embedded, nil, and every
+// method is overridden.
+type replayedSpan struct {
+	sdktrace.ReadOnlySpan
+
+	name              string
+	spanContext       trace.SpanContext
+	parent            trace.SpanContext
+	kind              trace.SpanKind
+	start             time.Time
+	end               time.Time
+	attributes        []attribute.KeyValue
+	links             []sdktrace.Link
+	events            []sdktrace.Event
+	status            sdktrace.Status
+	scope             instrumentation.Scope
+	resource          *resource.Resource
+	droppedAttributes int
+	droppedLinks      int
+	droppedEvents     int
+	childSpanCount    int
+}
+
+func (s *replayedSpan) Name() string                                { return s.name }
+func (s *replayedSpan) SpanContext() trace.SpanContext              { return s.spanContext }
+func (s *replayedSpan) Parent() trace.SpanContext                   { return s.parent }
+func (s *replayedSpan) SpanKind() trace.SpanKind                    { return s.kind }
+func (s *replayedSpan) StartTime() time.Time                        { return s.start }
+func (s *replayedSpan) EndTime() time.Time                          { return s.end }
+func (s *replayedSpan) Attributes() []attribute.KeyValue            { return s.attributes }
+func (s *replayedSpan) Links() []sdktrace.Link                      { return s.links }
+func (s *replayedSpan) Events() []sdktrace.Event                    { return s.events }
+func (s *replayedSpan) Status() sdktrace.Status                     { return s.status }
+func (s *replayedSpan) InstrumentationScope() instrumentation.Scope { return s.scope }
+func (s *replayedSpan) Resource() *resource.Resource                { return s.resource }
+func (s *replayedSpan) DroppedAttributes() int                      { return s.droppedAttributes }
+func (s *replayedSpan) DroppedLinks() int                           { return s.droppedLinks }
+func (s *replayedSpan) DroppedEvents() int                          { return s.droppedEvents }
+func (s *replayedSpan) ChildSpanCount() int                         { return s.childSpanCount }
+
+// InstrumentationLibrary is kept for exporters still reading it.
+func (s *replayedSpan) InstrumentationLibrary() instrumentation.Library { return s.scope }
+
+func encodeAttrs(kvs []attribute.KeyValue) []spooledAttr {
+	attrs := make([]spooledAttr, 0, len(kvs))
+	for _, kv := range kvs {
+		v, err := json.Marshal(kv.Value.AsInterface())
+		if err != nil {
+			continue
+		}
+		attrs = append(attrs, spooledAttr{Key: string(kv.Key), Type: kv.Value.Type().String(), Value: v})
+	}
+	return attrs
+}
+
+func decodeAttrs(attrs []spooledAttr) ([]attribute.KeyValue, error) {
+	kvs := make([]attribute.KeyValue, 0, len(attrs))
+	for _, a := range attrs {
+		key := attribute.Key(a.Key)
+		var kv attribute.KeyValue
+		var err error
+		switch a.Type {
+		case "BOOL":
+			var v bool
+			err = json.Unmarshal(a.Value, &v)
+			kv = key.Bool(v)
+		case "INT64":
+			var v int64
+			err = json.Unmarshal(a.Value, &v)
+			kv = key.Int64(v)
+		case "FLOAT64":
+			var v float64
+			err = json.Unmarshal(a.Value, &v)
+			kv = key.Float64(v)
+		case "STRING":
+			var v string
+			err = json.Unmarshal(a.Value, &v)
+			kv = key.String(v)
+		case "BOOLSLICE":
+			var v []bool
+			err = json.Unmarshal(a.Value, &v)
+			kv = key.BoolSlice(v)
+		case "INT64SLICE":
+			var v []int64
+			err = json.Unmarshal(a.Value, &v)
+			kv = key.Int64Slice(v)
+		case "FLOAT64SLICE":
+			var v []float64
+			err = json.Unmarshal(a.Value, &v)
+			kv = key.Float64Slice(v)
+		case "STRINGSLICE":
+			var v []string
+			err = json.Unmarshal(a.Value, &v)
+			kv = key.StringSlice(v)
+		default:
+			err = fmt.Errorf("unknown attribute type %q", a.Type)
+		}
+		if err != nil {
+			return nil, err
+		}
+		kvs = append(kvs, kv)
+	}
+	return kvs, nil
+}
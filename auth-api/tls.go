@@ -0,0 +1,262 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Client certificate policies of the HTTPS listener.
+const (
+	TLSClientAuthNone = "none"
+	// TLSClientAuthOptional verifies the certificates clients present but
+	// lets through clients without one.
+	TLSClientAuthOptional = "optional"
+	TLSClientAuthRequire  = "require"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// TLSConfig configures HTTPS on AUTH_API_PORT. TLS is off unless a
+// certificate is set.
+type TLSConfig struct {
+	CertFile string
+	KeyFile  string
+	// MinVersion is a tls.VersionTLS* constant.
+	MinVersion uint16
+	// CipherSuites restricts the suites of TLS 1.2 and below; nil keeps the
+	// Go defaults. TLS 1.3 suites are not configurable.
+	CipherSuites []uint16
+	ClientAuth   string
+	ClientCAFile string
+	// RedirectPort, when set, serves a plain HTTP listener redirecting to
+	// HTTPS.
+	RedirectPort string
+
+	certificate *tls.Certificate
+	clientCAs   *x509.CertPool
+}
+
+// Enabled tells whether auth-api serves HTTPS.
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != ""
+}
+
+// tlsConfigFromEnv reads the TLS_* settings and loads the certificate and
+// the client CAs, so a broken file is reported like any invalid setting.
+func tlsConfigFromEnv(env configLookup) (TLSConfig, error) {
+	c := TLSConfig{
+		CertFile:     env.get("TLS_CERT_FILE"),
+		KeyFile:      env.get("TLS_KEY_FILE"),
+		MinVersion:   tls.VersionTLS12,
+		ClientAuth:   TLSClientAuthNone,
+		ClientCAFile: env.get("TLS_CLIENT_CA_FILE"),
+		RedirectPort: env.get("TLS_REDIRECT_PORT"),
+	}
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return c, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if v := env.get("TLS_MIN_VERSION"); v != "" {
+		var ok bool
+		if c.MinVersion, ok = tlsVersions[v]; !ok {
+			return c, fmt.Errorf("TLS_MIN_VERSION must be 1.0, 1.1, 1.2 or 1.3, got %q", v)
+		}
+	}
+	if v := env.get("TLS_CIPHER_SUITES"); v != "" {
+		suites, err := parseCipherSuites(v)
+		if err != nil {
+			return c, err
+		}
+		c.CipherSuites = suites
+	}
+	switch v := env.get("TLS_CLIENT_AUTH"); v {
+	case "":
+	case TLSClientAuthNone, TLSClientAuthOptional, TLSClientAuthRequire:
+		c.ClientAuth = v
+	default:
+		return c, fmt.Errorf("TLS_CLIENT_AUTH must be %s, %s or %s, got %q", TLSClientAuthNone, TLSClientAuthOptional, TLSClientAuthRequire, v)
+	}
+	switch {
+	case c.ClientAuth != TLSClientAuthNone && c.ClientCAFile == "":
+		return c, fmt.Errorf("TLS_CLIENT_AUTH %s needs TLS_CLIENT_CA_FILE", c.ClientAuth)
+	case c.ClientAuth == TLSClientAuthNone && c.ClientCAFile != "":
+		return c, errors.New("TLS_CLIENT_CA_FILE needs TLS_CLIENT_AUTH optional or require")
+	}
+	if c.RedirectPort != "" {
+		if err := validatePort(c.RedirectPort); err != nil {
+			return c, fmt.Errorf("TLS_REDIRECT_PORT must be a port number, got %q", c.RedirectPort)
+		}
+	}
+	if !c.Enabled() {
+		if c.ClientAuth != TLSClientAuthNone || c.RedirectPort != "" {
+			return c, errors.New("TLS_CLIENT_AUTH and TLS_REDIRECT_PORT need TLS_CERT_FILE and TLS_KEY_FILE")
+		}
+		return c, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return c, fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE: %w", err)
+	}
+	c.certificate = &cert
+	if c.ClientCAFile != "" {
+		pem, err := os.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return c, fmt.Errorf("TLS_CLIENT_CA_FILE: %w", err)
+		}
+		c.clientCAs = x509.NewCertPool()
+		if !c.clientCAs.AppendCertsFromPEM(pem) {
+			return c, fmt.Errorf("TLS_CLIENT_CA_FILE: no PEM certificate in %s", c.ClientCAFile)
+		}
+	}
+	return c, nil
+}
+
+// parseCipherSuites reads comma separated suite names, e.g.
+// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. Suites Go deems insecure are refused.
+func parseCipherSuites(v string) ([]uint16, error) {
+	secure := map[string]uint16{}
+	for _, s := range tls.CipherSuites() {
+		secure[s.Name] = s.ID
+	}
+	insecure := map[string]bool{}
+	for _, s := range tls.InsecureCipherSuites() {
+		insecure[s.Name] = true
+	}
+	var suites []uint16
+	http2 := false
+	for _, name := range strings.Split(v, ",") {
+		name = strings.TrimSpace(name)
+		id, ok := secure[name]
+		switch {
+		case insecure[name]:
+			return nil, fmt.Errorf("TLS_CIPHER_SUITES: %s is insecure", name)
+		case !ok:
+			return nil, fmt.Errorf("TLS_CIPHER_SUITES: unknown cipher suite %q", name)
+		}
+		suites = append(suites, id)
+		http2 = http2 || id == tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 || id == tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256
+	}
+	if !http2 {
+		return nil, errors.New("TLS_CIPHER_SUITES must include TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 or TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, required by HTTP/2")
+	}
+	return suites, nil
+}
+
+// tlsServer holds the TLS settings of the HTTPS listener. Apply swaps them,
+// certificate included, for the handshakes that follow; open connections
+// keep theirs.
+type tlsServer struct {
+	logger  *slog.Logger
+	enabled bool
+	current atomic.Pointer[tls.Config]
+}
+
+func newTLSServer(cfg TLSConfig, logger *slog.Logger) *tlsServer {
+	s := &tlsServer{logger: logger, enabled: cfg.Enabled()}
+	if s.enabled {
+		s.current.Store(cfg.serverConfig())
+		s.logCertificate(cfg)
+	}
+	return s
+}
+
+// Config is the tls.Config of the HTTPS listener, serving the settings last
+// applied.
+func (s *tlsServer) Config() *tls.Config {
+	return &tls.Config{
+		NextProtos: []string{"h2", "http/1.1"},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return s.current.Load(), nil
+		},
+	}
+}
+
+// Apply serves cfg from the next handshake. Turning TLS on or off needs a
+// restart.
+func (s *tlsServer) Apply(cfg TLSConfig) {
+	if cfg.Enabled() != s.enabled {
+		s.logger.Warn("turning TLS on or off needs a restart, keeping the running listener", "tls", s.enabled)
+		return
+	}
+	if !s.enabled {
+		return
+	}
+	s.current.Store(cfg.serverConfig())
+	s.logCertificate(cfg)
+}
+
+func (s *tlsServer) logCertificate(cfg TLSConfig) {
+	leaf, err := x509.ParseCertificate(cfg.certificate.Certificate[0])
+	if err != nil {
+		return
+	}
+	s.logger.Info("serving TLS certificate", "file", cfg.CertFile, "subject", leaf.Subject.String(),
+		"not_after", leaf.NotAfter.Format(time.RFC3339), "client_auth", cfg.ClientAuth)
+}
+
+func (c TLSConfig) serverConfig() *tls.Config {
+	t := &tls.Config{
+		Certificates: []tls.Certificate{*c.certificate},
+		MinVersion:   c.MinVersion,
+		CipherSuites: c.CipherSuites,
+		NextProtos:   []string{"h2", "http/1.1"},
+		ClientCAs:    c.clientCAs,
+	}
+	switch c.ClientAuth {
+	case TLSClientAuthOptional:
+		t.ClientAuth = tls.VerifyClientCertIfGiven
+	case TLSClientAuthRequire:
+		t.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return t
+}
+
+// newRedirectServer serves RedirectPort, redirecting GET and HEAD requests
+// to the same URL over HTTPS on httpsPort.
+func newRedirectServer(cfg TLSConfig, httpsPort string, logger *slog.Logger) *http.Server {
+	return &http.Server{
+		Addr:              ":" + cfg.RedirectPort,
+		Handler:           httpsRedirect(httpsPort),
+		ReadHeaderTimeout: 5 * time.Second,
+		ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelWarn),
+	}
+}
+
+// httpsRedirect answers GET and HEAD with a permanent redirect. Other methods
+// get 403 instead: a POST /login on this port has already sent the password in
+// clear text, and a redirect would make the client silently resend it over
+// HTTPS, hiding the leak from whoever misconfigured it.
+func httpsRedirect(httpsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			// the body is not read, so do not reuse the connection
+			w.Header().Set("Connection", "close")
+			http.Error(w, "plain HTTP is not accepted, use HTTPS", http.StatusForbidden)
+			return
+		}
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
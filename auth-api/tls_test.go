@@ -0,0 +1,223 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCA issues certificates for the TLS tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns the PEM certificate and key of name, for a server or a client.
+func (ca *testCA) issue(t *testing.T, name string, serial int64, usage x509.ExtKeyUsage) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// writeServerCert writes a server certificate with serial to dir.
+func (ca *testCA) writeServerCert(t *testing.T, dir string, serial int64) (certFile, keyFile string) {
+	t.Helper()
+	certPEM, keyPEM := ca.issue(t, "localhost", serial, x509.ExtKeyUsageServerAuth)
+	certFile, keyFile = filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	os.WriteFile(certFile, certPEM, 0o600)
+	os.WriteFile(keyFile, keyPEM, 0o600)
+	return certFile, keyFile
+}
+
+// serveTLS serves 200 over the HTTPS listener of s.
+func serveTLS(t *testing.T, s *tlsServer) string {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = s.Config()
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv.Listener.Addr().String()
+}
+
+func TestTLSConfig_RejectsInvalidSettings(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := newTestCA(t).writeServerCert(t, dir, 2)
+	for name, tc := range map[string]struct {
+		env  map[string]string
+		want string
+	}{
+		"cert without key": {map[string]string{"TLS_CERT_FILE": certFile}, "must be set together"},
+		"old version":      {map[string]string{"TLS_MIN_VERSION": "1.4"}, "TLS_MIN_VERSION"},
+		"insecure suite":   {map[string]string{"TLS_CIPHER_SUITES": "TLS_RSA_WITH_RC4_128_SHA"}, "is insecure"},
+		"no http2 suite":   {map[string]string{"TLS_CIPHER_SUITES": "TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"}, "required by HTTP/2"},
+		"client auth":      {map[string]string{"TLS_CERT_FILE": certFile, "TLS_KEY_FILE": keyFile, "TLS_CLIENT_AUTH": "require"}, "needs TLS_CLIENT_CA_FILE"},
+		"redirect no tls":  {map[string]string{"TLS_REDIRECT_PORT": "8080"}, "need TLS_CERT_FILE"},
+		"mismatched key":   {map[string]string{"TLS_CERT_FILE": certFile, "TLS_KEY_FILE": certFile}, "TLS_CERT_FILE and TLS_KEY_FILE:"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := tlsConfigFromEnv(mapLookup(tc.env)); err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("expected an error containing %q, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestTLSServer_ReloadsCertificate(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	certFile, keyFile := ca.writeServerCert(t, dir, 2)
+	r, _ := newReloadTest(t, "usersApi.address: http://users-api:8083\ntls: {certFile: "+certFile+", keyFile: "+keyFile+"}\n")
+	server := newTLSServer(r.Current().TLS, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	r.OnReload(ReloadTLS, func(c *Config) { server.Apply(c.TLS) })
+	addr := serveTLS(t, server)
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(ca.pem)
+	serial := func() int64 {
+		conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: roots, ServerName: "localhost"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+	}
+	if got := serial(); got != 2 {
+		t.Fatalf("expected the first certificate, got serial %d", got)
+	}
+
+	ca.writeServerCert(t, dir, 3)
+	if err := r.Reload("test"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := serial(); got != 3 {
+		t.Fatalf("expected the renewed certificate, got serial %d", got)
+	}
+
+	os.WriteFile(keyFile, []byte("not a key"), 0o600)
+	if err := r.Reload("test"); err == nil {
+		t.Fatal("expected a broken key to be rejected")
+	}
+	if got := serial(); got != 3 {
+		t.Fatalf("expected the running certificate to be kept, got serial %d", got)
+	}
+}
+
+func TestTLSServer_VerifiesClientCertificates(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	certFile, keyFile := ca.writeServerCert(t, dir, 2)
+	caFile := filepath.Join(dir, "ca.crt")
+	os.WriteFile(caFile, ca.pem, 0o600)
+	cfg, err := tlsConfigFromEnv(mapLookup(map[string]string{
+		"TLS_CERT_FILE": certFile, "TLS_KEY_FILE": keyFile, "TLS_MIN_VERSION": "1.3",
+		"TLS_CLIENT_AUTH": TLSClientAuthRequire, "TLS_CLIENT_CA_FILE": caFile,
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	addr := serveTLS(t, newTLSServer(cfg, slog.New(slog.NewTextHandler(io.Discard, nil))))
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(ca.pem)
+	get := func(certs ...tls.Certificate) error {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs}}}
+		resp, err := client.Get("https://" + addr + "/")
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+	if err := get(); err == nil {
+		t.Fatal("expected a client without certificate to be refused")
+	}
+	certPEM, keyPEM := ca.issue(t, "todos-api", 4, x509.ExtKeyUsageClientAuth)
+	clientCert, _ := tls.X509KeyPair(certPEM, keyPEM)
+	if err := get(clientCert); err != nil {
+		t.Fatalf("expected the client certificate to be accepted, got %v", err)
+	}
+	other := newTestCA(t)
+	certPEM, keyPEM = other.issue(t, "intruder", 5, x509.ExtKeyUsageClientAuth)
+	foreign, _ := tls.X509KeyPair(certPEM, keyPEM)
+	if err := get(foreign); err == nil {
+		t.Fatal("expected a certificate from another CA to be refused")
+	}
+	if _, err := (&tls.Dialer{Config: &tls.Config{RootCAs: roots, Certificates: []tls.Certificate{clientCert}, MaxVersion: tls.VersionTLS12}}).Dial("tcp", addr); err == nil {
+		t.Fatal("expected TLS 1.2 to be refused")
+	}
+}
+
+func TestHTTPSRedirect(t *testing.T) {
+	for _, tc := range []struct{ host, port, want string }{
+		{"auth.example.com", "8443", "https://auth.example.com:8443/login?next=%2F"},
+		{"auth.example.com:8080", "443", "https://auth.example.com/login?next=%2F"},
+		{"[::1]:8080", "443", "https://[::1]/login?next=%2F"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/login?next=%2F", nil)
+		req.Host = tc.host
+		rec := httptest.NewRecorder()
+		httpsRedirect(tc.port).ServeHTTP(rec, req)
+		if rec.Code != http.StatusPermanentRedirect || rec.Header().Get("Location") != tc.want {
+			t.Errorf("%s: expected %d to %s, got %d to %s", tc.host, http.StatusPermanentRedirect, tc.want, rec.Code, rec.Header().Get("Location"))
+		}
+	}
+
+	// credentials sent over plain HTTP are refused, not forwarded to HTTPS
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(`{"username":"admin","password":"admin"}`))
+	rec := httptest.NewRecorder()
+	httpsRedirect("8443").ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden || rec.Header().Get("Location") != "" {
+		t.Errorf("expected POST to be refused with %d, got %d to %q", http.StatusForbidden, rec.Code, rec.Header().Get("Location"))
+	}
+}
@@ -1,47 +1,301 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
 
-	zipkin "github.com/openzipkin/zipkin-go"
-	zipkinhttp "github.com/openzipkin/zipkin-go/middleware/http"
-	zipkinhttpreporter "github.com/openzipkin/zipkin-go/reporter/http"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/contrib/propagators/b3"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/exporters/zipkin"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
-type TracedClient struct {
-	client *zipkinhttp.Client
+// tracerName is the instrumentation scope of the spans started by auth-api.
+const tracerName = "auth-api"
+
+// Span exporters selectable with TRACING_EXPORTER.
+const (
+	ExporterNone     = "none"
+	ExporterZipkin   = "zipkin"
+	ExporterOTLPHTTP = "otlp-http"
+	ExporterOTLPGRPC = "otlp-grpc"
+	ExporterStdout   = "stdout"
+)
+
+// TracingConfig selects the span exporter and describes this service.
+type TracingConfig struct {
+	Exporter string
+	// Endpoint of the exporter; the OTLP exporters fall back to the
+	// standard OTEL_EXPORTER_OTLP_* variables when empty.
+	Endpoint       string
+	ServiceName    string
+	ServiceVersion string
+	InstanceID     string
+	Sampling       SamplingConfig
+	Reporter       SpanReporterConfig
 }
 
-func (c *TracedClient) Do(req *http.Request) (*http.Response, error) {
-	name := req.Method + " " + req.RequestURI
-	return c.client.DoWithAppSpan(req, name)
+// tracingConfigFromEnv reads TRACING_EXPORTER, TRACING_ENDPOINT, ZIPKIN_URL,
+// OTEL_SERVICE_NAME, SERVICE_VERSION and the sampling and reporter variables. Without
+// TRACING_EXPORTER, setting ZIPKIN_URL keeps selecting the Zipkin exporter.
+func tracingConfigFromEnv(env configLookup) (TracingConfig, error) {
+	sampling, err := samplingConfigFromEnv(env)
+	if err != nil {
+		return TracingConfig{}, err
+	}
+	reporter, err := spanReporterConfigFromEnv(env)
+	if err != nil {
+		return TracingConfig{}, err
+	}
+	cfg := TracingConfig{
+		Sampling:       sampling,
+		Reporter:       reporter,
+		Exporter:       strings.ToLower(env.get("TRACING_EXPORTER")),
+		Endpoint:       env.get("TRACING_ENDPOINT"),
+		ServiceName:    env.get("OTEL_SERVICE_NAME"),
+		ServiceVersion: env.get("SERVICE_VERSION"),
+		InstanceID:     instanceID(),
+	}
+	zipkinURL := env.get("ZIPKIN_URL")
+	if cfg.Exporter == "" {
+		cfg.Exporter = ExporterNone
+		if zipkinURL != "" {
+			cfg.Exporter = ExporterZipkin
+		}
+	}
+	if cfg.Exporter == ExporterZipkin && cfg.Endpoint == "" {
+		cfg.Endpoint = zipkinURL
+	}
+	if cfg.ServiceName == "" {
+		cfg.ServiceName = "auth-api"
+	}
+	if cfg.ServiceVersion == "" {
+		cfg.ServiceVersion = "unknown"
+	}
+
+	switch cfg.Exporter {
+	case ExporterNone, ExporterOTLPHTTP, ExporterOTLPGRPC, ExporterStdout:
+	case ExporterZipkin:
+		if cfg.Endpoint == "" {
+			return cfg, fmt.Errorf("the zipkin exporter needs ZIPKIN_URL or TRACING_ENDPOINT")
+		}
+	default:
+		return cfg, fmt.Errorf("unknown TRACING_EXPORTER %q", cfg.Exporter)
+	}
+	return cfg, nil
+}
+
+// instanceID identifies this process among the replicas of the service.
+func instanceID() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
 }
 
-func initTracing(zipkinURL string) (func(http.Handler) http.Handler, *TracedClient, error) {
-	reporter := zipkinhttpreporter.NewReporter(zipkinURL)
+// tracing owns the OpenTelemetry tracer provider of the service.
+type tracing struct {
+	provider    *sdktrace.TracerProvider
+	propagator  propagation.TextMapPropagator
+	debugHeader string
+	reporter    *resilientExporter
+}
+
+// newPropagator accepts and emits both W3C traceparent and B3 multi-header
+// context, so traces from the frontend's zipkin.js and todos-api still join.
+func newPropagator() propagation.TextMapPropagator {
+	return propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+		b3.New(b3.WithInjectEncoding(b3.B3MultipleHeader)),
+	)
+}
 
-	endpoint, err := zipkin.NewEndpoint("auth-api", "")
+// initTracing installs the tracer provider and propagator globally. The
+// exporter selected by cfg is created in the background and spans are spooled
+// to disk while it or its collector is unavailable.
+func initTracing(ctx context.Context, cfg TracingConfig) (*tracing, error) {
+	res, err := resource.New(ctx,
+		resource.WithTelemetrySDK(),
+		resource.WithAttributes(
+			semconv.ServiceName(cfg.ServiceName),
+			semconv.ServiceVersion(cfg.ServiceVersion),
+			semconv.ServiceInstanceID(cfg.InstanceID),
+		),
+		// OTEL_RESOURCE_ATTRIBUTES and OTEL_SERVICE_NAME win over the defaults above
+		resource.WithFromEnv(),
+	)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
-	tracer, err := zipkin.NewTracer(reporter,
-		zipkin.WithLocalEndpoint(endpoint),
-		zipkin.WithSharedSpans(false))
-	if err != nil {
-		return nil, nil, err
+	reporter := newResilientExporter(cfg.Exporter, func(ctx context.Context) (sdktrace.SpanExporter, error) {
+		return newSpanExporter(ctx, cfg)
+	}, cfg.Reporter)
+	processor := reporter.Processor()
+	if cfg.Sampling.SampleErrors {
+		processor = newErrorSpanProcessor(processor)
 	}
 
-	// create global zipkin http server middleware
-	serverMiddleware := zipkinhttp.NewServerMiddleware(
-		tracer, zipkinhttp.TagResponseSize(true),
+	t := &tracing{
+		provider: sdktrace.NewTracerProvider(
+			sdktrace.WithSampler(cfg.Sampling.Sampler()),
+			sdktrace.WithSpanProcessor(processor),
+			sdktrace.WithResource(res),
+		),
+		propagator:  newPropagator(),
+		debugHeader: cfg.Sampling.DebugHeader,
+		reporter:    reporter,
+	}
+	otel.SetTracerProvider(t.provider)
+	otel.SetTextMapPropagator(t.propagator)
+	return t, nil
+}
+
+func newSpanExporter(ctx context.Context, cfg TracingConfig) (sdktrace.SpanExporter, error) {
+	switch cfg.Exporter {
+	case ExporterZipkin:
+		return zipkin.New(cfg.Endpoint)
+	case ExporterOTLPHTTP:
+		var opts []otlptracehttp.Option
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(cfg.Endpoint))
+		}
+		return otlptracehttp.New(ctx, opts...)
+	case ExporterOTLPGRPC:
+		var opts []otlptracegrpc.Option
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracegrpc.WithEndpointURL(cfg.Endpoint))
+		}
+		return otlptracegrpc.New(ctx, opts...)
+	case ExporterStdout:
+		return stdouttrace.New(stdouttrace.WithPrettyPrint())
+	}
+	return nil, fmt.Errorf("no span exporter for %q", cfg.Exporter)
+}
+
+// Middleware starts a server span for every request, continuing the caller's
+// trace and tagged with its request id. Requests carrying the debug header are always sampled.
+func (t *tracing) Middleware(next http.Handler) http.Handler {
+	tagged := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id := requestIDFromContext(r.Context()); id != "" {
+			trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("request.id", id))
+		}
+		next.ServeHTTP(w, r)
+	})
+	h := otelhttp.NewHandler(tagged, "auth-api",
+		otelhttp.WithTracerProvider(t.provider),
+		otelhttp.WithPropagators(t.propagator),
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return r.Method + " " + r.URL.Path
+		}),
+	)
+	if t.debugHeader == "" {
+		return h
+	}
+	return debugSamplingMiddleware(t.debugHeader, h)
+}
+
+// Client returns an HTTP client that traces the calls client makes to
+// peerService and propagates the trace.
+func (t *tracing) Client(client HTTPDoer, peerService string) *TracedClient {
+	return &TracedClient{
+		client:      client,
+		tracer:      t.provider.Tracer(tracerName),
+		propagator:  t.propagator,
+		peerService: peerService,
+	}
+}
+
+// Stats returns the span reporter counters.
+func (t *tracing) Stats() SpanReporterStats {
+	if t.reporter == nil {
+		return SpanReporterStats{}
+	}
+	return t.reporter.Stats()
+}
+
+// Check fails while the exporter is not created or its last export failed.
+func (t *tracing) Check(context.Context) error {
+	st := t.Stats()
+	if t.reporter != nil && !st.Initialised {
+		return fmt.Errorf("%s exporter is not initialised: %s", st.Exporter, st.LastError)
+	}
+	if st.LastError != "" {
+		return errors.New(st.LastError)
+	}
+	return nil
+}
+
+// Shutdown flushes the pending spans and stops the exporter.
+func (t *tracing) Shutdown(ctx context.Context) error {
+	return t.provider.Shutdown(ctx)
+}
+
+// TracedClient starts a client span for every call, named after the route
+// template of the request and tagged with the retry and breaker context.
+type TracedClient struct {
+	client      HTTPDoer
+	tracer      trace.Tracer
+	propagator  propagation.TextMapPropagator
+	peerService string
+}
+
+func (c *TracedClient) Do(req *http.Request) (*http.Response, error) {
+	ctx, span := c.tracer.Start(req.Context(), clientSpanName(req),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(req.Method),
+			semconv.URLFull(req.URL.Redacted()),
+			semconv.ServerAddress(req.URL.Hostname()),
+			semconv.PeerService(c.peerService),
+		),
 	)
+	defer span.End()
 
-	// create global zipkin traced http client
-	client, err := zipkinhttp.NewClient(tracer, zipkinhttp.ClientTrace(true))
+	if route := routeTemplateFromContext(ctx); route != "" {
+		span.SetAttributes(semconv.HTTPRoute(route))
+	}
+	if attempt, err := strconv.Atoi(req.Header.Get(RetryAttemptHeader)); err == nil {
+		span.SetAttributes(attribute.Int("retry.attempt", attempt))
+	}
+	if state := breakerStateFromContext(ctx); state != "" {
+		span.SetAttributes(attribute.String("breaker.state", state))
+	}
+
+	req = req.Clone(ctx)
+	c.propagator.Inject(ctx, propagation.HeaderCarrier(req.Header))
+	resp, err := c.client.Do(req)
 	if err != nil {
-		return nil, nil, err
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return resp, err
 	}
+	span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+	if resp.StatusCode >= 400 {
+		span.SetStatus(codes.Error, http.StatusText(resp.StatusCode))
+	}
+	return resp, nil
+}
 
-	return serverMiddleware, &TracedClient{client}, nil
+// clientSpanName is "METHOD /route/{template}", or only the method when the
+// route is unknown, so span names never carry user names or ids.
+func clientSpanName(req *http.Request) string {
+	if route := routeTemplateFromContext(req.Context()); route != "" {
+		return req.Method + " " + route
+	}
+	return req.Method
 }
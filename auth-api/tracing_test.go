@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func newTestTracing() (*tracing, *tracetest.InMemoryExporter) {
+	exporter := tracetest.NewInMemoryExporter()
+	return &tracing{
+		provider:   sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)),
+		propagator: newPropagator(),
+	}, exporter
+}
+
+func TestTracingConfigFromEnv(t *testing.T) {
+	t.Setenv("ZIPKIN_URL", "http://zipkin:9411/api/v2/spans")
+
+	cfg, err := tracingConfigFromEnv(os.LookupEnv)
+	if err != nil || cfg.Exporter != ExporterZipkin || cfg.Endpoint != "http://zipkin:9411/api/v2/spans" {
+		t.Fatalf("expected ZIPKIN_URL to select zipkin, got %+v err=%v", cfg, err)
+	}
+	if cfg.ServiceName != "auth-api" || cfg.InstanceID == "" {
+		t.Fatalf("unexpected service attributes: %+v", cfg)
+	}
+
+	t.Setenv("TRACING_EXPORTER", "otlp-grpc")
+	if cfg, err := tracingConfigFromEnv(os.LookupEnv); err != nil || cfg.Exporter != ExporterOTLPGRPC || cfg.Endpoint != "" {
+		t.Fatalf("expected otlp-grpc with the default endpoint, got %+v err=%v", cfg, err)
+	}
+
+	t.Setenv("TRACING_EXPORTER", "jaeger")
+	if _, err := tracingConfigFromEnv(os.LookupEnv); err == nil {
+		t.Fatal("expected an error for an unknown exporter")
+	}
+}
+
+func TestTracing_JoinsB3AndPropagatesBothFormats(t *testing.T) {
+	tr, exporter := newTestTracing()
+
+	// users-api stand-in recording the propagation headers it receives
+	var got http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer upstream.Close()
+
+	client := tr.Client(http.DefaultClient, "users-api")
+	handler := tr.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, _ := http.NewRequestWithContext(r.Context(), "GET", upstream.URL+"/users/admin", nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Errorf("unexpected client error: %v", err)
+			return
+		}
+		resp.Body.Close()
+	}))
+
+	// a request coming from zipkin.js with B3 multi headers
+	req := httptest.NewRequest("POST", "/login", nil)
+	req.Header.Set("X-B3-TraceId", "463ac35c9f6413ad48485a3953bb6124")
+	req.Header.Set("X-B3-SpanId", "a2fb4a1d1a96d312")
+	req.Header.Set("X-B3-Sampled", "1")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if tp := got.Get("traceparent"); len(tp) < 36 || tp[3:35] != "463ac35c9f6413ad48485a3953bb6124" {
+		t.Fatalf("expected W3C traceparent in the joined trace, got %q", tp)
+	}
+	if id := got.Get("X-B3-TraceId"); id != "463ac35c9f6413ad48485a3953bb6124" {
+		t.Fatalf("expected B3 trace id to be propagated, got %q", id)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("expected server and client spans, got %d", len(spans))
+	}
+	for _, s := range spans {
+		if s.SpanContext.TraceID().String() != "463ac35c9f6413ad48485a3953bb6124" {
+			t.Errorf("span %q is not part of the incoming trace", s.Name)
+		}
+	}
+}
+
+func spanAttr(s tracetest.SpanStub, key string) attribute.Value {
+	for _, kv := range s.Attributes {
+		if string(kv.Key) == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestTracing_ClientSpansByRouteWithRetryAndBreakerLayers(t *testing.T) {
+	tr, exporter := newTestTracing()
+	otel.SetTracerProvider(tr.provider)
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+	calls := 0
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer upstream.Close()
+
+	breaker := newBreakerHTTPClient(tr.Client(http.DefaultClient, "users-api"), "trace-breaker")
+	client := newRetryHTTPClient(breaker, RetryConfig{MaxRetries: 2, BaseDelay: time.Millisecond, MaxDelay: 2 * time.Millisecond})
+
+	ctx, root := tr.provider.Tracer("test").Start(context.Background(), "POST /login")
+	req, _ := http.NewRequestWithContext(WithRouteTemplate(ctx, "/users/{username}"), "GET", upstream.URL+"/users/admin", nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	root.End()
+
+	byName := map[string][]tracetest.SpanStub{}
+	for _, s := range exporter.GetSpans() {
+		byName[s.Name] = append(byName[s.Name], s)
+	}
+	if len(byName["retry"]) != 1 || len(byName["circuit_breaker"]) != 2 || len(byName["GET /users/{username}"]) != 2 {
+		t.Fatalf("unexpected spans: %v", byName)
+	}
+
+	retrySpan := byName["retry"][0]
+	if retrySpan.Parent.SpanID() != root.SpanContext().SpanID() || spanAttr(retrySpan, "retry.attempts").AsInt64() != 2 {
+		t.Fatalf("unexpected retry span: %+v", retrySpan)
+	}
+	for i, s := range byName["GET /users/{username}"] {
+		breakerSpan := byName["circuit_breaker"][i]
+		if breakerSpan.Parent.SpanID() != retrySpan.SpanContext.SpanID() || s.Parent.SpanID() != breakerSpan.SpanContext.SpanID() {
+			t.Fatalf("client span %d is not nested under retry and breaker spans", i)
+		}
+		if spanAttr(s, "retry.attempt").AsInt64() != int64(i+1) ||
+			spanAttr(s, "peer.service").AsString() != "users-api" ||
+			spanAttr(s, "breaker.state").AsString() != "closed" ||
+			spanAttr(s, "http.route").AsString() != "/users/{username}" {
+			t.Fatalf("unexpected client span attributes: %v", s.Attributes)
+		}
+	}
+	if code := spanAttr(byName["GET /users/{username}"][0], "http.response.status_code").AsInt64(); code != 503 {
+		t.Fatalf("expected first attempt to record 503, got %d", code)
+	}
+	if outcome := spanAttr(byName["circuit_breaker"][0], "breaker.outcome").AsString(); outcome != "failure" {
+		t.Fatalf("expected first breaker outcome failure, got %q", outcome)
+	}
+}
+
+func TestClientSpanName_WithoutRoute(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://users-api:8083/users/admin", nil)
+	if name := clientSpanName(req); name != "GET" {
+		t.Fatalf("expected method-only span name, got %q", name)
+	}
+}
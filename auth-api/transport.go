@@ -0,0 +1,230 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// ProxyNone disables the proxy of the users-api transport, whatever the
+// HTTP_PROXY environment.
+const ProxyNone = "none"
+
+// TransportConfig tunes the connections to users-api.
+type TransportConfig struct {
+	DialTimeout time.Duration
+	// TCPKeepAlive is the keep-alive probe period of the connections.
+	TCPKeepAlive          time.Duration
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration
+	// Timeout bounds one attempt, body included; retries and hedged
+	// attempts each get their own.
+	Timeout             time.Duration
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	// MaxConnsPerHost limits the connections to users-api; 0 is unlimited.
+	MaxConnsPerHost int
+	IdleConnTimeout time.Duration
+	// KeepAlive reuses connections between requests.
+	KeepAlive bool
+	// HTTP2 is negotiated with an https users-api when enabled.
+	HTTP2 bool
+	// Proxy is the proxy URL, ProxyNone, or empty to follow HTTP_PROXY,
+	// HTTPS_PROXY and NO_PROXY.
+	Proxy string
+	// CAFile replaces the system CAs to verify users-api.
+	CAFile string
+	// CertFile and KeyFile are the client certificate for mTLS.
+	CertFile string
+	KeyFile  string
+
+	rootCAs     *x509.CertPool
+	certificate *tls.Certificate
+}
+
+// DefaultTransportConfig bounds every phase of a call, unlike
+// http.DefaultClient.
+func DefaultTransportConfig() TransportConfig {
+	return TransportConfig{
+		DialTimeout:           2 * time.Second,
+		TCPKeepAlive:          30 * time.Second,
+		TLSHandshakeTimeout:   3 * time.Second,
+		ResponseHeaderTimeout: 5 * time.Second,
+		Timeout:               10 * time.Second,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   10,
+		IdleConnTimeout:       90 * time.Second,
+		KeepAlive:             true,
+		HTTP2:                 true,
+	}
+}
+
+// transportConfigFromEnv reads the USERS_API_* transport settings and loads
+// the CA bundle and the client certificate.
+func transportConfigFromEnv(env configLookup) (TransportConfig, error) {
+	c := DefaultTransportConfig()
+	durations := []struct {
+		name string
+		dst  *time.Duration
+		unit time.Duration
+	}{
+		{"USERS_API_DIAL_TIMEOUT_MS", &c.DialTimeout, time.Millisecond},
+		{"USERS_API_TLS_HANDSHAKE_TIMEOUT_MS", &c.TLSHandshakeTimeout, time.Millisecond},
+		{"USERS_API_RESPONSE_HEADER_TIMEOUT_MS", &c.ResponseHeaderTimeout, time.Millisecond},
+		{"USERS_API_TIMEOUT_MS", &c.Timeout, time.Millisecond},
+		{"USERS_API_TCP_KEEP_ALIVE_SECONDS", &c.TCPKeepAlive, time.Second},
+		{"USERS_API_IDLE_CONN_TIMEOUT_SECONDS", &c.IdleConnTimeout, time.Second},
+	}
+	for _, d := range durations {
+		if v := env.get(d.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				return c, fmt.Errorf("%s must be a positive integer, got %q", d.name, v)
+			}
+			*d.dst = time.Duration(n) * d.unit
+		}
+	}
+	if c.ResponseHeaderTimeout > c.Timeout {
+		return c, fmt.Errorf("USERS_API_RESPONSE_HEADER_TIMEOUT_MS (%d) cannot exceed USERS_API_TIMEOUT_MS (%d)",
+			c.ResponseHeaderTimeout.Milliseconds(), c.Timeout.Milliseconds())
+	}
+
+	counts := []struct {
+		name string
+		dst  *int
+	}{
+		{"USERS_API_MAX_IDLE_CONNS", &c.MaxIdleConns},
+		{"USERS_API_MAX_IDLE_CONNS_PER_HOST", &c.MaxIdleConnsPerHost},
+		{"USERS_API_MAX_CONNS_PER_HOST", &c.MaxConnsPerHost},
+	}
+	for _, n := range counts {
+		if v := env.get(n.name); v != "" {
+			i, err := strconv.Atoi(v)
+			if err != nil || i < 0 {
+				return c, fmt.Errorf("%s must be a non-negative integer, got %q", n.name, v)
+			}
+			*n.dst = i
+		}
+	}
+	flags := []struct {
+		name string
+		dst  *bool
+	}{
+		{"USERS_API_KEEP_ALIVE", &c.KeepAlive},
+		{"USERS_API_HTTP2", &c.HTTP2},
+	}
+	for _, f := range flags {
+		if v := env.get(f.name); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return c, fmt.Errorf("%s must be true or false, got %q", f.name, v)
+			}
+			*f.dst = b
+		}
+	}
+
+	if c.Proxy = env.get("USERS_API_PROXY"); c.Proxy != "" && c.Proxy != ProxyNone {
+		u, err := url.Parse(c.Proxy)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5") || u.Host == "" {
+			return c, fmt.Errorf("USERS_API_PROXY must be %s or an http(s) or socks5 URL, got %q", ProxyNone, redactURL(c.Proxy))
+		}
+	}
+
+	c.CAFile = env.get("USERS_API_CA_FILE")
+	c.CertFile = env.get("USERS_API_CERT_FILE")
+	c.KeyFile = env.get("USERS_API_KEY_FILE")
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return c, errors.New("USERS_API_CERT_FILE and USERS_API_KEY_FILE must be set together")
+	}
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return c, fmt.Errorf("USERS_API_CA_FILE: %w", err)
+		}
+		c.rootCAs = x509.NewCertPool()
+		if !c.rootCAs.AppendCertsFromPEM(pem) {
+			return c, fmt.Errorf("USERS_API_CA_FILE: no PEM certificate in %s", c.CAFile)
+		}
+	}
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return c, fmt.Errorf("USERS_API_CERT_FILE and USERS_API_KEY_FILE: %w", err)
+		}
+		c.certificate = &cert
+	}
+	return c, nil
+}
+
+// client builds the HTTP client of the configuration.
+func (c TransportConfig) client() *http.Client {
+	dialer := &net.Dialer{Timeout: c.DialTimeout, KeepAlive: c.TCPKeepAlive}
+	t := &http.Transport{
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   c.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: c.ResponseHeaderTimeout,
+		ExpectContinueTimeout: time.Second,
+		MaxIdleConns:          c.MaxIdleConns,
+		MaxIdleConnsPerHost:   c.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       c.MaxConnsPerHost,
+		IdleConnTimeout:       c.IdleConnTimeout,
+		DisableKeepAlives:     !c.KeepAlive,
+		ForceAttemptHTTP2:     c.HTTP2,
+		TLSClientConfig:       &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: c.rootCAs},
+	}
+	if c.certificate != nil {
+		t.TLSClientConfig.Certificates = []tls.Certificate{*c.certificate}
+	}
+	if !c.HTTP2 {
+		// a non-nil empty map turns HTTP/2 off
+		t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	switch c.Proxy {
+	case "":
+		t.Proxy = http.ProxyFromEnvironment
+	case ProxyNone:
+	default:
+		u, _ := url.Parse(c.Proxy)
+		t.Proxy = http.ProxyURL(u)
+	}
+	return &http.Client{Transport: t, Timeout: c.Timeout}
+}
+
+// usersAPIClient sends the requests to users-api over the transport of the
+// running configuration. Reconfigure swaps it for the requests that follow;
+// requests in progress finish on the previous one.
+type usersAPIClient struct {
+	current atomic.Pointer[http.Client]
+}
+
+func newUsersAPIClient(cfg TransportConfig) *usersAPIClient {
+	c := &usersAPIClient{}
+	c.current.Store(cfg.client())
+	return c
+}
+
+func (c *usersAPIClient) Do(req *http.Request) (*http.Response, error) {
+	return c.current.Load().Do(req)
+}
+
+// RoundTrip lets other clients, e.g. the health check, share the transport
+// with their own timeout.
+func (c *usersAPIClient) RoundTrip(req *http.Request) (*http.Response, error) {
+	return c.current.Load().Transport.RoundTrip(req)
+}
+
+// Reconfigure applies cfg and closes the idle connections of the previous
+// transport.
+func (c *usersAPIClient) Reconfigure(cfg TransportConfig) {
+	if old := c.current.Swap(cfg.client()); old != nil {
+		old.CloseIdleConnections()
+	}
+}
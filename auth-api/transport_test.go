@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTransportConfig_RejectsInvalidSettings(t *testing.T) {
+	for name, tc := range map[string]struct {
+		env  map[string]string
+		want string
+	}{
+		"zero timeout":   {map[string]string{"USERS_API_DIAL_TIMEOUT_MS": "0"}, "USERS_API_DIAL_TIMEOUT_MS must be a positive integer"},
+		"header timeout": {map[string]string{"USERS_API_RESPONSE_HEADER_TIMEOUT_MS": "3000", "USERS_API_TIMEOUT_MS": "1000"}, "cannot exceed USERS_API_TIMEOUT_MS"},
+		"pool size":      {map[string]string{"USERS_API_MAX_IDLE_CONNS": "-1"}, "USERS_API_MAX_IDLE_CONNS must be a non-negative integer"},
+		"http2":          {map[string]string{"USERS_API_HTTP2": "maybe"}, "USERS_API_HTTP2 must be true or false"},
+		"proxy":          {map[string]string{"USERS_API_PROXY": "ftp://proxy:21"}, "USERS_API_PROXY must be"},
+		"cert":           {map[string]string{"USERS_API_CERT_FILE": "client.crt"}, "must be set together"},
+		"ca":             {map[string]string{"USERS_API_CA_FILE": "/does/not/exist"}, "USERS_API_CA_FILE:"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := transportConfigFromEnv(mapLookup(tc.env)); err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("expected an error containing %q, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestUsersAPIClient_TimesOutHungServer(t *testing.T) {
+	hang := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-hang
+	}))
+	defer upstream.Close()
+	defer close(hang)
+
+	cfg, err := transportConfigFromEnv(mapLookup(map[string]string{"USERS_API_RESPONSE_HEADER_TIMEOUT_MS": "50"}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	client := newUsersAPIClient(cfg)
+	start := time.Now()
+	req, _ := http.NewRequest(http.MethodGet, upstream.URL+"/users/admin", nil)
+	_, err = client.Do(req)
+	if err == nil {
+		t.Fatal("expected the hung call to fail")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected the response header timeout to cut the call, took %s", elapsed)
+	}
+	if class := classifyError(err); class != ErrClassTimeout {
+		t.Fatalf("expected the retry layer to see a timeout, got %s", class)
+	}
+}
+
+func TestUsersAPIClient_MutualTLSAndHTTP2(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	serverCert, serverKey := ca.issue(t, "localhost", 2, x509.ExtKeyUsageServerAuth)
+	clientCert, clientKey := ca.issue(t, "auth-api", 3, x509.ExtKeyUsageClientAuth)
+	files := map[string][]byte{"ca.crt": ca.pem, "client.crt": clientCert, "client.key": clientKey}
+	for name, content := range files {
+		os.WriteFile(filepath.Join(dir, name), content, 0o600)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(ca.pem)
+	cert, _ := tls.X509KeyPair(serverCert, serverKey)
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	upstream.EnableHTTP2 = true
+	upstream.TLS = &tls.Config{Certificates: []tls.Certificate{cert}, ClientCAs: pool, ClientAuth: tls.RequireAndVerifyClientCert}
+	upstream.StartTLS()
+	defer upstream.Close()
+
+	get := func(env map[string]string) (*http.Response, error) {
+		t.Helper()
+		cfg, err := transportConfigFromEnv(mapLookup(env))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		req, _ := http.NewRequest(http.MethodGet, upstream.URL+"/users/admin", nil)
+		resp, err := newUsersAPIClient(cfg).Do(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return resp, err
+	}
+
+	env := map[string]string{"USERS_API_CA_FILE": filepath.Join(dir, "ca.crt")}
+	if _, err := get(env); err == nil {
+		t.Fatal("expected users-api to refuse a client without certificate")
+	}
+	env["USERS_API_CERT_FILE"] = filepath.Join(dir, "client.crt")
+	env["USERS_API_KEY_FILE"] = filepath.Join(dir, "client.key")
+	resp, err := get(env)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.ProtoMajor != 2 {
+		t.Fatalf("expected HTTP/2, got %s", resp.Proto)
+	}
+	env["USERS_API_HTTP2"] = "false"
+	if resp, err = get(env); err != nil || resp.ProtoMajor != 1 {
+		t.Fatalf("expected HTTP/1.1 with HTTP/2 off, got %v %v", resp, err)
+	}
+	delete(env, "USERS_API_CA_FILE")
+	if _, err := get(env); err == nil {
+		t.Fatal("expected the private CA not to be trusted without USERS_API_CA_FILE")
+	}
+}
+
+func TestUsersAPIClient_Proxy(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+	}))
+	defer proxy.Close()
+
+	cfg, err := transportConfigFromEnv(mapLookup(map[string]string{"USERS_API_PROXY": proxy.URL}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "http://users-api:8083/users/admin", nil)
+	resp, err := newUsersAPIClient(cfg).Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if proxied != "http://users-api:8083/users/admin" {
+		t.Fatalf("expected the call to go through the proxy, got %q", proxied)
+	}
+}
@@ -2,18 +2,71 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"log/slog"
 	"net/http"
+	"strings"
+	"sync"
 
 	jwt "github.com/dgrijalva/jwt-go"
 )
 
-var allowedUserHashes = map[string]interface{}{
-	"admin_admin": nil,
-	"johnd_foo":   nil,
-	"janed_ddd":   nil,
+// defaultAllowedUsers are the demo users of users-api.
+const defaultAllowedUsers = "admin:admin,johnd:foo,janed:ddd"
+
+// allowedUserStore holds the username and password pairs allowed to log in,
+// replaced as a whole when the configuration is reloaded. A nil store has no
+// users.
+type allowedUserStore struct {
+	mu    sync.RWMutex
+	users map[string]string
+}
+
+func newAllowedUserStore(users map[string]string) *allowedUserStore {
+	return &allowedUserStore{users: users}
+}
+
+// Allowed tells whether username may log in with password.
+func (s *allowedUserStore) Allowed(username, password string) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	want, ok := s.users[username]
+	return ok && subtle.ConstantTimeCompare([]byte(password), []byte(want)) == 1
+}
+
+// Set replaces every user.
+func (s *allowedUserStore) Set(users map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users = users
+}
+
+// allowedUsersFromEnv reads ALLOWED_USERS, "username:password" entries
+// separated by commas, defaulting to the demo users.
+func allowedUsersFromEnv(env configLookup) (map[string]string, error) {
+	v := env.get("ALLOWED_USERS")
+	if v == "" {
+		v = defaultAllowedUsers
+	}
+	users := map[string]string{}
+	for _, item := range strings.Split(v, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		username, password, ok := strings.Cut(item, ":")
+		if !ok || username == "" || password == "" {
+			return nil, fmt.Errorf("ALLOWED_USERS: invalid entry for user %q, expected username:password", username)
+		}
+		users[username] = password
+	}
+	return users, nil
 }
 
 type User struct {
@@ -27,58 +80,104 @@ type HTTPDoer interface {
 	Do(req *http.Request) (*http.Response, error)
 }
 
+type routeTemplateKey struct{}
+
+// WithRouteTemplate records the route template (e.g. "/users/{username}") of an
+// outbound request so client wrappers can group calls without high-cardinality paths.
+func WithRouteTemplate(ctx context.Context, route string) context.Context {
+	return context.WithValue(ctx, routeTemplateKey{}, route)
+}
+
+func routeTemplateFromContext(ctx context.Context) string {
+	route, _ := ctx.Value(routeTemplateKey{}).(string)
+	return route
+}
+
 type UserService struct {
-	Client            HTTPDoer
-	UserAPIAddress    string
-	AllowedUserHashes map[string]interface{}
+	Client         HTTPDoer
+	UserAPIAddress string
+	// AllowedUsers may log in with their password.
+	AllowedUsers *allowedUserStore
+	// Profiles caches fetched users for the breaker fallback; nil disables it.
+	Profiles *profileCache
+	// BreakGlass accounts may log in while users-api is unavailable.
+	BreakGlass *breakGlassStore
+	// Metrics, when set, counts the service tokens issued for users-api.
+	Metrics *authMetrics
+	// Logger, when set, replaces the default logger.
+	Logger *slog.Logger
+}
+
+func (h *UserService) logger() *slog.Logger {
+	if h.Logger != nil {
+		return h.Logger
+	}
+	return slog.Default()
 }
 
 func (h *UserService) Login(ctx context.Context, username, password string) (User, error) {
-	user, err := h.getUser(ctx, username)
+	user, fallback, err := h.getUser(ctx, username)
 	if err != nil {
 		return user, err
 	}
 
-	userKey := fmt.Sprintf("%s_%s", username, password)
+	if fallback == FallbackBreakGlass {
+		if account, _ := h.BreakGlass.Get(username); !account.checkPassword(password) {
+			return user, ErrWrongCredentials
+		}
+		return user, nil
+	}
 
-	if _, ok := h.AllowedUserHashes[userKey]; !ok {
+	if !h.AllowedUsers.Allowed(username, password) {
 		return user, ErrWrongCredentials // this is BAD, business logic layer must not return HTTP-specific errors
 	}
 
 	return user, nil
 }
 
-func (h *UserService) getUser(ctx context.Context, username string) (User, error) {
+// getUser fetches the user from users-api. When the result was produced by a
+// breaker fallback, the fallback path is returned as well.
+func (h *UserService) getUser(ctx context.Context, username string) (User, string, error) {
 	var user User
 
 	token, err := h.getUserAPIToken(username)
 	if err != nil {
-		return user, err
+		return user, "", err
 	}
 	url := fmt.Sprintf("%s/users/%s", h.UserAPIAddress, username)
 	req, _ := http.NewRequest("GET", url, nil)
 	req.Header.Add("Authorization", "Bearer "+token)
+	if id := requestIDFromContext(ctx); id != "" {
+		req.Header.Set(RequestIDHeader, id)
+	}
 
-	req = req.WithContext(ctx)
+	ctx, fallback := withFallbackRecord(ctx)
+	req = req.WithContext(WithRouteTemplate(ctx, "/users/{username}"))
 
 	resp, err := h.Client.Do(req)
 	if err != nil {
-		return user, err
+		return user, "", err
 	}
 
 	defer resp.Body.Close()
 	bodyBytes, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return user, err
+		return user, "", err
 	}
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return user, fmt.Errorf("could not get user data: %s", string(bodyBytes))
+		return user, "", fmt.Errorf("could not get user data: %s", string(bodyBytes))
 	}
 
-	err = json.Unmarshal(bodyBytes, &user)
+	if err = json.Unmarshal(bodyBytes, &user); err != nil {
+		return user, "", err
+	}
 
-	return user, err
+	path := fallback.Path()
+	if path == "" && h.Profiles != nil {
+		h.Profiles.Put(user)
+	}
+	return user, path, nil
 }
 
 func (h *UserService) getUserAPIToken(username string) (string, error) {
@@ -86,5 +185,9 @@ func (h *UserService) getUserAPIToken(username string) (string, error) {
 	claims := token.Claims.(jwt.MapClaims)
 	claims["username"] = username
 	claims["scope"] = "read"
-	return token.SignedString([]byte(jwtSecret))
+	t, err := token.SignedString([]byte(jwtSecret.Get()))
+	if err == nil {
+		h.Metrics.TokenIssued(TokenService)
+	}
+	return t, err
 }
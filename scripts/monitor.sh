@@ -80,8 +80,9 @@ get_service_metrics() {
 
     case "$service" in
         "auth-api")
-            # Verificar estado del circuit breaker (endpoint disponible sin auth)
-            if check_http_service "$service" "$host" "$port" "/health/circuit-breaker" 2; then
+            # Verificar que el servicio esté vivo y leer el estado del circuit breaker
+            # (/health/circuit-breaker responde 503 mientras el breaker está abierto)
+            if check_http_service "$service" "$host" "$port" "/health/live" 2; then
                 local cb_state
                 cb_state=$(curl -s "http://$host:$port/health/circuit-breaker" 2>/dev/null | grep -o '"state":"[^"]*"' | cut -d'"' -f4 2>/dev/null || echo "unknown")
                 echo "CB:$cb_state"
@@ -111,7 +111,7 @@ fi
 CB_URL="http://localhost:8000/status/circuit-breaker"
 BEFORE_REQS=""
 if command -v jq >/dev/null 2>&1; then
-  BEFORE_REQS=$(curl -fs "$CB_URL" | jq -r '.totals.Requests // .Requests // 0' || echo "0")
+  BEFORE_REQS=$(curl -fs "$CB_URL" | jq -r '.lifetime.requests // .totals.Requests // .Requests // 0' || echo "0")
 fi
 
 # 6) Call /login and expect 200 despite first 500, measuring elapsed
@@ -136,7 +136,7 @@ AFTER_REQS=""
 if command -v jq >/dev/null 2>&1; then
   WIRE_REQ_COUNT=$(curl -fs http://localhost:8089/__admin/requests | jq '.requests | length')
   STATUSES_JSON=$(curl -fs http://localhost:8089/__admin/requests | jq '[.requests[] | select(.request.url|test("/users/")) | {status:.response.status, t:(.loggedDate // 0)}] | sort_by(.t) | .[-2:] | map(.status)')
-  AFTER_REQS=$(curl -fs "$CB_URL" | jq -r '.totals.Requests // .Requests // 0')
+  AFTER_REQS=$(curl -fs "$CB_URL" | jq -r '.lifetime.requests // .totals.Requests // .Requests // 0')
 fi
 
 ELAPSED_MS=""